@@ -1,20 +1,42 @@
 package decimal
 
 import (
+	"fmt"
 	"github.com/shopspring/decimal"
 	"math/big"
 	"strconv"
 )
 
+// Float - The Float struct is a fixed-point decimal number of arbitrary precision. It is used for all money arithmetic, so that
+// amounts do not drift the way float64 values do after many additions and multiplications. A Float can be scanned from a
+// numeric column of the database without loss, and it is written back and sent on the wire as its exact string.
 type Float struct {
 	decimal.Decimal
 }
 
-// New - This function creates a new Float object from an interface, which could be a float64, int64, int, or *big.Int. It
-// takes the value from the interface and converts it to a decimal.NewFromFloat, decimal.NewFromInt, or
-// decimal.NewFromString, and then stores it in the Float object.
+// New - This function creates a new Float object from an interface, which could be any integer or float type, *big.Int, a
+// decimal string, a decimal.Decimal or another Float. It takes the value from the interface and converts it to a
+// decimal.NewFromFloat, decimal.NewFromInt, or decimal.NewFromString, and then stores it in the Float object. A string
+// that is not a decimal number, a nil value and any other type give zero, New never panics; a caller that has to tell
+// such a value apart from zero uses From.
 func New(value interface{}) *Float {
 
+	// This code converts the value with From and keeps zero when the value can not be converted, so that a bad argument fails
+	// the computation it is part of rather than the whole process.
+	number, err := From(value)
+	if err != nil {
+		return &Float{
+			decimal.NewFromFloat(0),
+		}
+	}
+
+	return number
+}
+
+// From - This function creates a new Float object from an interface like New, but returns an error when the type of the value
+// is not supported or a string is not a decimal number. A nil value, including a nil pointer, is taken as zero.
+func From(value interface{}) (*Float, error) {
+
 	// This code is used to create a new decimal object with a value of 0.0. The decimal object can then be used to store
 	// and manipulate decimal values in an efficient way.
 	number := decimal.NewFromFloat(0)
@@ -23,45 +45,99 @@ func New(value interface{}) *Float {
 	// of the value, then use the corresponding function to convert it into decimal. For example, if the value is a float64,
 	// then decimal.NewFromFloat will be used.
 	switch v := value.(type) {
+	case nil:
 	case float64:
 		number = decimal.NewFromFloat(v)
+	case float32:
+		number = decimal.NewFromFloat32(v)
 	case int64:
 		number = decimal.NewFromInt(v)
+	case int32:
+		number = decimal.NewFromInt32(v)
+	case int16:
+		number = decimal.NewFromInt(int64(v))
+	case int8:
+		number = decimal.NewFromInt(int64(v))
 	case int:
 		number = decimal.NewFromInt(int64(v))
+	case uint64:
+		number = decimal.NewFromBigInt(new(big.Int).SetUint64(v), 0)
+	case uint32:
+		number = decimal.NewFromInt(int64(v))
+	case uint16:
+		number = decimal.NewFromInt(int64(v))
+	case uint8:
+		number = decimal.NewFromInt(int64(v))
+	case uint:
+		number = decimal.NewFromBigInt(new(big.Int).SetUint64(uint64(v)), 0)
 	case *big.Int:
-		number, _ = decimal.NewFromString(v.String())
+		if v != nil {
+			number = decimal.NewFromBigInt(v, 0)
+		}
+	case string:
+		value, err := decimal.NewFromString(v)
+		if err != nil {
+			return nil, err
+		}
+		number = value
+	case decimal.Decimal:
+		number = v
+	case *decimal.Decimal:
+		if v != nil {
+			number = *v
+		}
+	case Float:
+		number = v.Decimal
+	case *Float:
+		if v != nil {
+			number = v.Decimal
+		}
+	default:
+		return nil, fmt.Errorf("decimal: unsupported type %T", value)
 	}
 
 	return &Float{
 		number,
+	}, nil
+}
+
+// Parse - This function creates a new Float object from a decimal string, unlike New it returns an error when the string is
+// not a decimal number, so that a value sent by a client is never silently taken as zero.
+func Parse(value string) (*Float, error) {
+
+	number, err := decimal.NewFromString(value)
+	if err != nil {
+		return nil, err
 	}
+
+	return &Float{
+		number,
+	}, nil
 }
 
-// Mul - This function is used to multiply a float value with another float value and return a new float value. The function
-// takes a pointer to a float type and a float64 value as parameters and returns a pointer to a new float type containing
-// the result of the multiplication.
-func (p *Float) Mul(value float64) *Float {
-	return &Float{p.Decimal.Mul(decimal.NewFromFloat(value))}
+// Mul - This function is used to multiply a float value with another value and return a new float value. The value can be of any
+// type accepted by New, a *Float or a decimal string keeps the computation exact, without a round trip through float64.
+func (p *Float) Mul(value interface{}) *Float {
+	return &Float{p.Decimal.Mul(New(value).Decimal)}
 }
 
-// Div - This function is used to divide a float value by another float value and return the result as a Float type. The
-// 'Div()' function is a method of the decimal package, and it takes in a float64 value as an argument. The function
-// returns the result as a Float type instead of a float64 type to ensure precision and accuracy of the computation.
-func (p *Float) Div(value float64) *Float {
-	return &Float{p.Decimal.Div(decimal.NewFromFloat(value))}
+// Div - This function is used to divide a float value by another value and return the result as a Float type. The value can be
+// of any type accepted by New. The function returns the result as a Float type instead of a float64 type to ensure
+// precision and accuracy of the computation.
+func (p *Float) Div(value interface{}) *Float {
+	return &Float{p.Decimal.Div(New(value).Decimal)}
 }
 
-// Sub - This is a method of a Float type in Go. The purpose of this method is to subtract a given float64 value from the Float
-// type value and then return the resulting Float type.
-func (p *Float) Sub(value float64) *Float {
-	return &Float{p.Decimal.Sub(decimal.NewFromFloat(value))}
+// Sub - This is a method of a Float type in Go. The purpose of this method is to subtract a given value of any type accepted by
+// New from the Float type value and then return the resulting Float type.
+func (p *Float) Sub(value interface{}) *Float {
+	return &Float{p.Decimal.Sub(New(value).Decimal)}
 }
 
-// Add - This function adds a float64 value to a Float type which is a struct containing a decimal.Decimal struct. The function
-// returns a pointer to a new Float struct with the new decimal.Decimal value.
-func (p *Float) Add(value float64) *Float {
-	return &Float{p.Decimal.Add(decimal.NewFromFloat(value))}
+// Add - This function adds a value of any type accepted by New to a Float type which is a struct containing a decimal.Decimal
+// struct. The function returns a pointer to a new Float struct with the new decimal.Decimal value.
+func (p *Float) Add(value interface{}) *Float {
+	return &Float{p.Decimal.Add(New(value).Decimal)}
 }
 
 // Float - This is a method of the Float type. It is used to convert a Float to a float64 value by returning the float64
@@ -87,6 +163,16 @@ func (p *Float) Round(number int32) *Float {
 	return &Float{p.Decimal.Round(number)}
 }
 
+// Floor - This function rounds a float number down (towards negative infinity) to the specified number of decimal places.
+func (p *Float) Floor(number int32) *Float {
+	return &Float{p.Decimal.RoundFloor(number)}
+}
+
+// Ceil - This function rounds a float number up (towards positive infinity) to the specified number of decimal places.
+func (p *Float) Ceil(number int32) *Float {
+	return &Float{p.Decimal.RoundCeil(number)}
+}
+
 // Int64 - convert to int64.
 func (p *Float) Int64() int64 {
 	return p.Decimal.IntPart()
@@ -0,0 +1,118 @@
+package decimal
+
+import (
+	"testing"
+)
+
+func TestFloat_Exact(t *testing.T) {
+	tests := []struct {
+		name string
+		got  *Float
+		want string
+	}{
+		{
+			name: t.Name(),
+			got:  New(0.1).Add(0.2),
+			want: "0.3",
+		},
+		{
+			name: t.Name(),
+			got:  New("12345.123456789012345678").Add("0.000000000000000001"),
+			want: "12345.123456789012345679",
+		},
+		{
+			name: t.Name(),
+			got:  New("0.000000000000000003").Mul(New(3)).Sub(0.000000000000000009),
+			want: "0",
+		},
+		{
+			name: t.Name(),
+			got:  New("invalid"),
+			want: "0",
+		},
+		{
+			name: t.Name(),
+			got:  New(int32(2)).Add(*New(0.5)).Mul(float32(2)),
+			want: "5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.got.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFloat_Scan(t *testing.T) {
+	var (
+		value Float
+	)
+
+	if err := value.Scan([]byte("0.123456789012345678")); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	if got := value.String(); got != "0.123456789012345678" {
+		t.Errorf("Scan() = %v, want %v", got, "0.123456789012345678")
+	}
+}
+
+func TestFrom(t *testing.T) {
+	var (
+		nothing *Float
+	)
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  t.Name(),
+			value: uint8(7),
+			want:  "7",
+		},
+		{
+			name:  t.Name(),
+			value: uint64(18446744073709551615),
+			want:  "18446744073709551615",
+		},
+		{
+			name:  t.Name(),
+			value: nil,
+			want:  "0",
+		},
+		{
+			name:  t.Name(),
+			value: nothing,
+			want:  "0",
+		},
+		{
+			name:    t.Name(),
+			value:   "invalid",
+			wantErr: true,
+		},
+		{
+			name:    t.Name(),
+			value:   struct{}{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := From(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("From() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("From() = %v, want %v", got, tt.want)
+			}
+			if err != nil && New(tt.value).String() != "0" {
+				t.Errorf("New() = %v, want 0", New(tt.value))
+			}
+		})
+	}
+}
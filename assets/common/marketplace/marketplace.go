@@ -7,6 +7,7 @@ import (
 	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -48,6 +49,35 @@ func Price() *Marketplace {
 // exchange. It takes a base and quote currency as parameters and returns the average market price of the currency pair.
 func (p *Marketplace) Unit(base, quote string) float64 {
 
+	var (
+		price float64
+		scale = p.Scale(base, quote)
+	)
+
+	// This loop iterates through a slice of prices (scale) and adds each element of the slice to the variable price.
+	// It is used to calculate the total cost of the items in the slice.
+	for i := 0; i < len(scale); i++ {
+		price += scale[i]
+	}
+
+	// The purpose of time.Sleep(1 * time.Second) is to pause the program for one second before continuing. This is useful
+	// for when you need to pause a program to allow other processes to run or to slow down the execution speed.
+	time.Sleep(1 * time.Second)
+
+	// The purpose of this if statement is to check if the length of the list of prices is greater than 0. If the length is
+	// greater than 0, the statement will return the price divided by the length of the list. Otherwise, the statement will
+	// not do anything.
+	if len(scale) > 0 {
+		return price / float64(len(scale))
+	}
+
+	return 0
+}
+
+// Scale - This function returns the prices of the currency pair reported by each of the exchanges, an exchange that does not
+// list the pair or can not be reached is left out. The prices are in the order of the exchanges.
+func (p *Marketplace) Scale(base, quote string) []float64 {
+
 	// This code is used to initialize four variables in Go. The variables are base, quote, p.count and p.scale. The base
 	// and quote variables are set to the uppercase versions of the strings passed in as arguments, while the p.count
 	// variable is set to 0 and the p.scale variable is set to an empty slice of float64 values.
@@ -62,28 +92,28 @@ func (p *Marketplace) Unit(base, quote string) float64 {
 	p.scale = append(p.scale, p.getKuna(base, quote))
 	p.scale = append(p.scale, p.getHuobi(base, quote))
 
-	var (
-		price float64
-	)
+	return p.filter()
+}
 
-	// This loop iterates through a slice of prices (p.filter()) and adds each element of the slice to the variable price.
-	// It is used to calculate the total cost of the items in the slice.
-	for i := 0; i < len(p.filter()); i++ {
-		price += p.filter()[i]
+// Median - This function returns the middle value of the prices, or the average of the two middle values of an even number of
+// prices. Unlike the average, a single exchange that reports a wrong price can not move the median far. It returns zero
+// without any prices.
+func Median(prices []float64) float64 {
+
+	if len(prices) == 0 {
+		return 0
 	}
 
-	// The purpose of time.Sleep(1 * time.Second) is to pause the program for one second before continuing. This is useful
-	// for when you need to pause a program to allow other processes to run or to slow down the execution speed.
-	time.Sleep(1 * time.Second)
+	// The prices are sorted in a copy, the order of the given slice is kept.
+	sorted := append([]float64(nil), prices...)
+	sort.Float64s(sorted)
 
-	// The purpose of this if statement is to check if the length of the list returned by the p.filter() function is greater
-	// than 0. If the length is greater than 0, the statement will return the price divided by the length of the list
-	// returned by the p.filter() function. Otherwise, the statement will not do anything.
-	if len(p.filter()) > 0 {
-		return price / float64(len(p.filter()))
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
 	}
 
-	return 0
+	return sorted[middle]
 }
 
 // filter - This function is used to filter a Marketplace structure by removing all the zero values from the scale field. It
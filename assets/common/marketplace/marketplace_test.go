@@ -53,3 +53,44 @@ func TestMarketplace_Unit(t *testing.T) {
 		})
 	}
 }
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name   string
+		prices []float64
+		want   float64
+	}{
+		{
+			name:   "empty",
+			prices: nil,
+			want:   0,
+		},
+		{
+			name:   "single",
+			prices: []float64{100},
+			want:   100,
+		},
+		{
+			name:   "odd",
+			prices: []float64{103, 99, 100},
+			want:   100,
+		},
+		{
+			name:   "even",
+			prices: []float64{101, 99, 100, 102},
+			want:   100.5,
+		},
+		{
+			name:   "outlier",
+			prices: []float64{100, 101, 5000},
+			want:   101,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Median(tt.prices); got != tt.want {
+				t.Errorf("Median() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
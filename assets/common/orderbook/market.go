@@ -0,0 +1,110 @@
+package orderbook
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+)
+
+// Market - The Market struct keeps the order books of all pairs, one book per base unit, quote unit and type. Books are created
+// lazily on the first access and filled by the loader passed to the Book method.
+type Market struct {
+	mutex     sync.Mutex
+	books     map[string]*Book
+	sequences map[string]int64
+}
+
+// NewMarket - returns an empty set of order books.
+func NewMarket() *Market {
+	return &Market{
+		books:     make(map[string]*Book),
+		sequences: make(map[string]int64),
+	}
+}
+
+// Book - This method returns the order book of the pair. When the book does not exist yet, it is created and the load function
+// is called once to fill it with the resting orders (for example from the database). If loading fails the book is not
+// kept, so the next call tries again.
+func (m *Market) Book(base, quote, _type string, load func(book *Book) error) (*Book, error) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := m.key(base, quote, _type)
+	if book, ok := m.books[key]; ok {
+		return book, nil
+	}
+
+	book := New()
+	if err := load(book); err != nil {
+		return nil, err
+	}
+
+	// The loaded orders are the initial state of the book and not a change. A book that replaces a reset one continues its
+	// sequence with a gap of one number, so that the receivers of the diffs notice that they have to load a new snapshot.
+	book.changes = make(map[string]map[string]decimal.Float)
+	if sequence, ok := m.sequences[key]; ok {
+		book.sequence.Store(sequence + 1)
+	}
+	m.books[key] = book
+
+	return book, nil
+}
+
+// Acquire - This method returns the order book of the pair with its mutex already locked. A book that was reset while the
+// caller was waiting for the lock is skipped and the fresh book is taken instead, so that two books of the same pair are
+// never matched at the same time. The caller must unlock the book when it is done.
+func (m *Market) Acquire(base, quote, _type string, load func(book *Book) error) (*Book, error) {
+
+	for {
+
+		book, err := m.Book(base, quote, _type, load)
+		if err != nil {
+			return nil, err
+		}
+
+		book.Lock()
+		if !book.Closed() {
+			return book, nil
+		}
+		book.Unlock()
+	}
+}
+
+// Reset - This method drops every cached book of the given type that contains the symbol as a base or quote unit, the books are
+// loaded again on the next access. It is used when the persisted orders were changed outside the matching engine, or when
+// the settlement of a match failed and the memory state can no longer be trusted.
+func (m *Market) Reset(symbol, _type string) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for key, book := range m.books {
+		if params := strings.Split(key, "/"); (params[0] == symbol || params[1] == symbol) && params[2] == _type {
+			book.closed.Store(true)
+			m.sequences[key] = book.Sequence()
+			delete(m.books, key)
+		}
+	}
+}
+
+// Clear - This method drops every cached book, the books are loaded again on the next access. It is used when a change affects
+// the resting orders of all pairs, for example a new link between accounts.
+func (m *Market) Clear() {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for key, book := range m.books {
+		book.closed.Store(true)
+		m.sequences[key] = book.Sequence()
+		delete(m.books, key)
+	}
+}
+
+// key - returns the map key of a pair book.
+func (m *Market) key(base, quote, _type string) string {
+	return fmt.Sprintf("%v/%v/%v", base, quote, _type)
+}
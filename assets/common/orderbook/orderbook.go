@@ -0,0 +1,824 @@
+package orderbook
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+)
+
+const (
+	// AssigningBuy and AssigningSell mirror the values of types.AssigningBuy and types.AssigningSell, the book does not
+	// depend on the server types so that it can be used and tested on its own.
+	AssigningBuy  = "buy"
+	AssigningSell = "sell"
+
+	// The modes of the self-trade prevention mirror the values of types.Prevention*. An incoming order that would trade with a
+	// resting order of the same owner either cancels itself (the newest order), the resting order (the oldest order), both
+	// orders, or decreases both orders by the smaller open quantity and cancels the one that is used up.
+	PreventionCancelNewest = "cancel_newest"
+	PreventionCancelOldest = "cancel_oldest"
+	PreventionCancelBoth   = "cancel_both"
+	PreventionDecrement    = "decrement"
+)
+
+// Order - The Order struct is the in-memory representation of a resting order. Value is the remaining visible quantity of the
+// order in the base unit, priority is the time priority assigned by the book when the order was added to a price level.
+// Prices and quantities are exact decimals, so the open quantities of the book never drift from the ones in the database.
+// An iceberg order has a display quantity: only a slice of that size is visible in the book, the rest of the open value
+// is kept in Hidden and replenishes the slice after it was filled. The owner is the account that the user is linked to, orders
+// of the same owner never trade with each other, an owner of zero is the user itself. The prevention mode of an incoming
+// order decides what happens when it meets an order of the same owner, the cancellation of the newest order is the default.
+type Order struct {
+	Id         int64
+	UserId     int64
+	Owner      int64
+	Assigning  string
+	Price      decimal.Float
+	Value      decimal.Float
+	Display    decimal.Float
+	Hidden     decimal.Float
+	Prevention string
+	priority   int64
+}
+
+// Level - The Level struct represents a single price level of the book, orders inside the level are kept in the order in
+// which they arrived (first in, first out).
+type Level struct {
+	Price  decimal.Float
+	Orders []*Order
+}
+
+// Fill - The Fill struct describes a single match between an incoming (taker) order and a resting (maker) order. The price
+// of a fill is always the price of the maker order.
+type Fill struct {
+	Maker    *Order
+	Price    decimal.Float
+	Quantity decimal.Float
+}
+
+// Prevention - The Prevention struct describes a self-trade prevented by the book between an incoming order and the resting order
+// Maker of the same owner. Newest and Oldest report whether the incoming and the resting order are cancelled, the maker is
+// already removed from the book. A decrement carries the quantity taken from the open value of both orders instead, an
+// order that is used up by it is removed from the book as well.
+type Prevention struct {
+	Maker    *Order
+	Quantity decimal.Float
+	Newest   bool
+	Oldest   bool
+}
+
+// Cross - The Cross struct describes the fills of a single resting bid in the uncrossing of a call auction. The bid takes the
+// place of the incoming order, the fills carry the asks it was matched with and the clearing price of the auction.
+type Cross struct {
+	Order     *Order
+	Fills     []*Fill
+	Prevented []*Prevention
+}
+
+// Depth - The Depth struct is a single aggregated price level of the book: the summary open quantity and the number of the
+// orders resting at the price.
+type Depth struct {
+	Price    decimal.Float
+	Quantity decimal.Float
+	Count    int
+}
+
+// Diff - The Diff struct describes the price levels changed since the previous diff of the book. Every level carries its new
+// summary quantity, a quantity of zero means that the level was removed. Sequence increases by one with every diff, so a
+// gap in the sequence tells the receiver that it missed an update and has to load a new snapshot.
+type Diff struct {
+	Sequence int64
+	Bids     []*Depth
+	Asks     []*Depth
+}
+
+// Book - The Book struct holds the bid and ask price levels of a single pair. Bids are sorted from the highest price to the
+// lowest and asks from the lowest to the highest, so index zero is always the best price. The methods of the book are
+// not synchronized on their own, the caller is expected to hold the embedded mutex for the whole match and settle cycle.
+type Book struct {
+	sync.Mutex
+	bids     []*Level
+	asks     []*Level
+	orders   map[int64]*Order
+	changes  map[string]map[string]decimal.Float
+	priority int64
+	sequence atomic.Int64
+	closed   atomic.Bool
+}
+
+// New - returns an empty order book.
+func New() *Book {
+	return &Book{
+		orders:  make(map[int64]*Order),
+		changes: make(map[string]map[string]decimal.Float),
+	}
+}
+
+// Sequence - returns the sequence number of the last diff of the book.
+func (b *Book) Sequence() int64 {
+	return b.sequence.Load()
+}
+
+// Flush - This method returns the diff of all price levels changed since the previous call and assigns it the next sequence
+// number. It returns nil when nothing was changed. The caller must flush the book before the mutex is unlocked, so that
+// every diff contains the changes of exactly one locked section.
+func (b *Book) Flush() *Diff {
+
+	if len(b.changes[AssigningBuy]) == 0 && len(b.changes[AssigningSell]) == 0 {
+		return nil
+	}
+
+	diff := &Diff{
+		Sequence: b.sequence.Add(1),
+	}
+
+	for _, assigning := range []string{AssigningBuy, AssigningSell} {
+
+		var (
+			prices []decimal.Float
+		)
+
+		for _, price := range b.changes[assigning] {
+			prices = append(prices, price)
+		}
+
+		// The changed levels are reported in the same order as the levels of the book, from the best price to the worst.
+		sort.Slice(prices, func(i, j int) bool {
+			if assigning == AssigningBuy {
+				return prices[i].GreaterThan(prices[j].Decimal)
+			}
+			return prices[i].LessThan(prices[j].Decimal)
+		})
+
+		for _, price := range prices {
+
+			depth := &Depth{Price: price}
+			if index, ok := b.search(*b.side(assigning), assigning, price); ok {
+				level := (*b.side(assigning))[index]
+				depth.Quantity, depth.Count = *level.Quantity(), len(level.Orders)
+			}
+
+			if assigning == AssigningBuy {
+				diff.Bids = append(diff.Bids, depth)
+			} else {
+				diff.Asks = append(diff.Asks, depth)
+			}
+		}
+	}
+
+	b.changes = make(map[string]map[string]decimal.Float)
+
+	return diff
+}
+
+// Snapshot - This method returns every price level of the book, not grouped, together with the sequence number of the last
+// diff. Diffs with a sequence number up to and including the one of the snapshot are already contained in it.
+func (b *Book) Snapshot() *Diff {
+
+	diff := &Diff{
+		Sequence: b.sequence.Load(),
+	}
+
+	for _, level := range b.bids {
+		diff.Bids = append(diff.Bids, &Depth{Price: level.Price, Quantity: *level.Quantity(), Count: len(level.Orders)})
+	}
+
+	for _, level := range b.asks {
+		diff.Asks = append(diff.Asks, &Depth{Price: level.Price, Quantity: *level.Quantity(), Count: len(level.Orders)})
+	}
+
+	return diff
+}
+
+// change - marks the price level of the given side as changed for the next diff.
+func (b *Book) change(assigning string, price decimal.Float) {
+	if b.changes[assigning] == nil {
+		b.changes[assigning] = make(map[string]decimal.Float)
+	}
+	b.changes[assigning][price.String()] = price
+}
+
+// Closed - reports whether the book was dropped from the market and must not be used anymore.
+func (b *Book) Closed() bool {
+	return b.closed.Load()
+}
+
+// Quantity - returns the summary remaining quantity of all orders placed at the price level.
+func (l *Level) Quantity() *decimal.Float {
+
+	quantity := decimal.New(0)
+	for _, order := range l.Orders {
+		quantity = quantity.Add(order.Value)
+	}
+
+	return quantity
+}
+
+// Add - This method places an order into the book at the tail of its price level, creating the level in the sorted position
+// when it does not exist yet. The order receives the next sequence number and therefore the lowest time priority at its price.
+func (b *Book) Add(order *Order) {
+
+	// An order that is already resting is removed first, so that re-adding it (for example after a price change) never
+	// leaves a duplicate behind.
+	if _, ok := b.orders[order.Id]; ok {
+		b.Remove(order.Id)
+	}
+
+	// An iceberg order shows only its display quantity, the rest of its open value is kept hidden.
+	if order.Display.IsPositive() && order.Value.GreaterThan(order.Display.Decimal) {
+		order.Hidden = *order.Hidden.Add(order.Value).Sub(order.Display)
+		order.Value = order.Display
+	}
+
+	b.priority++
+	order.priority = b.priority
+	b.change(order.Assigning, order.Price)
+
+	levels := b.side(order.Assigning)
+	index, ok := b.search(*levels, order.Assigning, order.Price)
+	if !ok {
+		*levels = append(*levels, nil)
+		copy((*levels)[index+1:], (*levels)[index:])
+		(*levels)[index] = &Level{Price: order.Price}
+	}
+
+	(*levels)[index].Orders = append((*levels)[index].Orders, order)
+	b.orders[order.Id] = order
+}
+
+// Remove - This method removes a resting order from the book by its id, dropping the price level when it becomes empty. It
+// returns the removed order and a boolean that reports whether the order was found.
+func (b *Book) Remove(id int64) (*Order, bool) {
+
+	order, ok := b.orders[id]
+	if !ok {
+		return nil, false
+	}
+	delete(b.orders, id)
+	b.change(order.Assigning, order.Price)
+
+	levels := b.side(order.Assigning)
+	index, ok := b.search(*levels, order.Assigning, order.Price)
+	if !ok {
+		return order, true
+	}
+
+	level := (*levels)[index]
+	for i, item := range level.Orders {
+		if item.Id == id {
+			level.Orders = append(level.Orders[:i], level.Orders[i+1:]...)
+			break
+		}
+	}
+
+	if len(level.Orders) == 0 {
+		*levels = append((*levels)[:index], (*levels)[index+1:]...)
+	}
+
+	return order, true
+}
+
+// Amend - This method decreases the open quantity of a resting order to the given value in place, so the order keeps its time
+// priority. The hidden quantity of an iceberg order is decreased first. It returns false when the order is not resting or
+// the value is not a decrease, a price change or an increase has to remove the order and add it again instead.
+func (b *Book) Amend(id int64, value *decimal.Float) bool {
+
+	order, ok := b.orders[id]
+	if !ok || !value.IsPositive() || !value.LessThan(order.Value.Add(order.Hidden).Decimal) {
+		return false
+	}
+
+	reduce := order.Value.Add(order.Hidden).Sub(value)
+	if !order.Hidden.LessThan(reduce.Decimal) {
+		order.Hidden = *order.Hidden.Sub(reduce)
+	} else {
+		order.Value, order.Hidden = *order.Value.Sub(reduce.Sub(order.Hidden)), *decimal.New(0)
+	}
+	b.change(order.Assigning, order.Price)
+
+	return true
+}
+
+// Get - returns a resting order by its id.
+func (b *Book) Get(id int64) (*Order, bool) {
+	order, ok := b.orders[id]
+	return order, ok
+}
+
+// Best - returns the best price of the given side of the book, the highest bid or the lowest ask.
+func (b *Book) Best(assigning string) (*decimal.Float, bool) {
+
+	levels := *b.side(assigning)
+	if len(levels) == 0 {
+		return decimal.New(0), false
+	}
+
+	return decimal.New(levels[0].Price), true
+}
+
+// Levels - returns the price levels of the given side of the book ordered from the best price to the worst.
+func (b *Book) Levels(assigning string) []*Level {
+	return *b.side(assigning)
+}
+
+// Depth - This method aggregates the given side of the book by price level, from the best price to the worst. The prices are
+// grouped to the given number of decimal places: bids are rounded down and asks are rounded up, so a grouped level never
+// shows a better price than the orders it contains. At most limit levels are returned, a limit of 0 returns all levels.
+func (b *Book) Depth(assigning string, limit int, precision int32) (depth []*Depth) {
+
+	for _, level := range *b.side(assigning) {
+
+		price := level.Price.Ceil(precision)
+		if assigning == AssigningBuy {
+			price = level.Price.Floor(precision)
+		}
+
+		// Levels are sorted, so the grouped price is either the price of the last aggregated level or a new level.
+		if count := len(depth); count > 0 && depth[count-1].Price.Equal(price.Decimal) {
+			depth[count-1].Quantity = *depth[count-1].Quantity.Add(level.Quantity())
+			depth[count-1].Count += len(level.Orders)
+			continue
+		}
+
+		if limit > 0 && len(depth) == limit {
+			break
+		}
+
+		depth = append(depth, &Depth{
+			Price:    *price,
+			Quantity: *level.Quantity(),
+			Count:    len(level.Orders),
+		})
+	}
+
+	return depth
+}
+
+// Match - This method matches an incoming order against the opposite side of the book using price-time priority: the best
+// price level is consumed first and, inside a level, the oldest order first. Every match is executed at the price of the
+// resting order. A resting order of the same owner is not traded with, the prevention mode of the incoming order is
+// applied to it and returned for the caller to settle; matching stops when the incoming order is cancelled by it. Filled
+// resting orders are removed from the book, the incoming order is never added to the book here, its remaining quantity
+// is left in order.Value for the caller to decide. A filled slice of an iceberg order is replenished from its hidden
+// quantity and moved to the tail of its level, so the replenished slice loses its time priority.
+func (b *Book) Match(order *Order) (fills []*Fill, prevented []*Prevention) {
+
+	opposite := AssigningSell
+	if order.Assigning == AssigningSell {
+		opposite = AssigningBuy
+	}
+
+	levels := b.side(opposite)
+
+	for i := 0; i < len(*levels) && order.Value.IsPositive(); {
+
+		level := (*levels)[i]
+		if !b.cross(order, level.Price) {
+			break
+		}
+
+		for j := 0; j < len(level.Orders) && order.Value.IsPositive(); {
+
+			maker := level.Orders[j]
+			if maker.owner() == order.owner() {
+
+				prevention := b.prevent(order, maker)
+				prevented = append(prevented, prevention)
+
+				// The resting order is kept when only the incoming order is cancelled, otherwise it left the level or was decreased.
+				if prevention.Newest && !prevention.Oldest {
+					return fills, prevented
+				}
+
+				if _, ok := b.orders[maker.Id]; !ok {
+					level.Orders = append(level.Orders[:j], level.Orders[j+1:]...)
+				} else {
+					j++
+				}
+
+				if prevention.Newest {
+					break
+				}
+				continue
+			}
+
+			quantity := maker.Value
+			if order.Value.LessThan(quantity.Decimal) {
+				quantity = order.Value
+			}
+
+			maker.Value = *maker.Value.Sub(quantity)
+			order.Value = *order.Value.Sub(quantity)
+			b.change(maker.Assigning, maker.Price)
+
+			fills = append(fills, &Fill{
+				Maker:    maker,
+				Price:    level.Price,
+				Quantity: quantity,
+			})
+
+			if maker.Value.IsPositive() {
+				j++
+				continue
+			}
+
+			level.Orders = append(level.Orders[:j], level.Orders[j+1:]...)
+
+			if maker.Hidden.IsPositive() {
+				b.replenish(maker)
+				level.Orders = append(level.Orders, maker)
+				continue
+			}
+
+			delete(b.orders, maker.Id)
+		}
+
+		if len(level.Orders) == 0 {
+			*levels = append((*levels)[:i], (*levels)[i+1:]...)
+		} else {
+			i++
+		}
+
+		// The incoming order was cancelled together with the resting order of the same owner.
+		if len(prevented) > 0 && prevented[len(prevented)-1].Newest {
+			break
+		}
+	}
+
+	return fills, prevented
+}
+
+// prevent - This method applies the prevention mode of the incoming order to the resting order of the same owner. A cancelled
+// resting order is dropped from the map of the orders, the caller removes it from its level. A decrement takes the smaller
+// open quantity from both orders, the hidden quantity of an iceberg order first; a resting order that is used up by it is
+// dropped as well.
+func (b *Book) prevent(order, maker *Order) *Prevention {
+
+	prevention := &Prevention{
+		Maker: maker,
+	}
+
+	switch order.Prevention {
+	case PreventionCancelOldest:
+		prevention.Oldest = true
+		break
+	case PreventionCancelBoth:
+		prevention.Oldest, prevention.Newest = true, true
+		break
+	case PreventionDecrement:
+
+		open := maker.Value.Add(maker.Hidden)
+
+		prevention.Quantity = order.Value
+		if open.LessThan(prevention.Quantity.Decimal) {
+			prevention.Quantity = *open
+		}
+		order.Value = *order.Value.Sub(prevention.Quantity)
+
+		if !maker.Hidden.LessThan(prevention.Quantity.Decimal) {
+			maker.Hidden = *maker.Hidden.Sub(prevention.Quantity)
+		} else {
+			maker.Value, maker.Hidden = *maker.Value.Sub(prevention.Quantity.Sub(maker.Hidden)), *decimal.New(0)
+		}
+		b.change(maker.Assigning, maker.Price)
+
+		if maker.Value.IsZero() {
+			delete(b.orders, maker.Id)
+		}
+
+		return prevention
+	default:
+		prevention.Newest = true
+		return prevention
+	}
+
+	b.change(maker.Assigning, maker.Price)
+	delete(b.orders, maker.Id)
+
+	return prevention
+}
+
+// owner - returns the owner of the order, the user itself when the order is not linked to another account.
+func (o *Order) owner() int64 {
+	if o.Owner > 0 {
+		return o.Owner
+	}
+	return o.UserId
+}
+
+// Sweep - This method walks the opposite side of the book level by level, in the same order as Match, and returns the quantity
+// in the base unit that an incoming market order could be filled with, together with the worst price that the fill would
+// reach and the rest of the budget that could not be used. The budget of a buy order is given in the quote unit and the
+// budget of a sell order in the base unit. A price of the order above zero is the limit that the walk does not cross. The
+// orders of the same owner follow the prevention mode of the order: the walk stops at them when the order would be
+// cancelled, they are left out when they would be cancelled, and a decremented order is counted like any other, so the
+// reserved budget covers the decremented quantity as well. The book is not changed.
+func (b *Book) Sweep(order *Order, budget *decimal.Float) (quantity, price, rest *decimal.Float) {
+
+	opposite := AssigningSell
+	if order.Assigning == AssigningSell {
+		opposite = AssigningBuy
+	}
+
+	quantity, price = decimal.New(0), decimal.New(0)
+
+	for _, level := range *b.side(opposite) {
+
+		if !budget.IsPositive() || (order.Price.IsPositive() && !b.cross(order, level.Price)) {
+			break
+		}
+
+		for _, maker := range level.Orders {
+
+			if !budget.IsPositive() {
+				break
+			}
+
+			if maker.owner() == order.owner() {
+				switch order.Prevention {
+				case PreventionCancelOldest:
+					continue
+				case PreventionDecrement:
+					break
+				default:
+					return quantity, price, budget
+				}
+			}
+
+			// The whole open value of the maker, hidden quantity included, can be taken within the remaining budget or a part of it.
+			value := maker.Value.Add(maker.Hidden)
+			if order.Assigning == AssigningBuy {
+				if cost := value.Mul(level.Price); cost.GreaterThan(budget.Decimal) {
+					value = budget.Div(level.Price)
+				}
+				budget = budget.Sub(value.Mul(level.Price))
+			} else {
+				if value.GreaterThan(budget.Decimal) {
+					value = budget
+				}
+				budget = budget.Sub(value)
+			}
+
+			quantity, price = quantity.Add(value), decimal.New(level.Price)
+		}
+	}
+
+	return quantity, price, budget
+}
+
+// Marketable - reports whether the incoming order would trade against the opposite side of the book right away, that is
+// whether it would take liquidity instead of only adding it.
+func (b *Book) Marketable(order *Order) bool {
+
+	opposite := AssigningSell
+	if order.Assigning == AssigningSell {
+		opposite = AssigningBuy
+	}
+
+	price, ok := b.Best(opposite)
+	return ok && b.cross(order, *price)
+}
+
+// Fillable - This method returns the quantity of the opposite side of the book that the incoming order could be matched with,
+// counting the same resting orders as Match: the levels that cross the price of the order, including the hidden quantity
+// of iceberg orders. The orders of the same owner follow the prevention mode of the order: the count stops at them when
+// the order would be cancelled, they are left out when they would be cancelled, and a decrement takes their quantity
+// from the order without filling it. The book is not changed, the quantity is capped at the open value of the order.
+func (b *Book) Fillable(order *Order) *decimal.Float {
+
+	opposite := AssigningSell
+	if order.Assigning == AssigningSell {
+		opposite = AssigningBuy
+	}
+
+	quantity, remain := decimal.New(0), decimal.New(order.Value)
+
+	for _, level := range *b.side(opposite) {
+
+		if !b.cross(order, level.Price) || !quantity.LessThan(remain.Decimal) {
+			break
+		}
+
+		for _, maker := range level.Orders {
+
+			if !quantity.LessThan(remain.Decimal) {
+				break
+			}
+
+			open := maker.Value.Add(maker.Hidden)
+			if maker.owner() != order.owner() {
+				quantity = quantity.Add(open)
+				continue
+			}
+
+			switch order.Prevention {
+			case PreventionCancelOldest:
+				continue
+			case PreventionDecrement:
+				if remain = remain.Sub(open); remain.LessThan(quantity.Decimal) {
+					remain = quantity
+				}
+				continue
+			}
+
+			return b.min(quantity, remain)
+		}
+	}
+
+	return b.min(quantity, remain)
+}
+
+// Reach - This method returns the price of the level of the opposite side at which the incoming order would be filled with the
+// given quantity, walking the levels from the best price like Match. The resting orders of the same owner are not
+// counted, they never trade with the order. When the side can not fill the whole quantity the worst price of the side is
+// returned, and zero when the side is empty. The book is not changed.
+func (b *Book) Reach(order *Order, quantity *decimal.Float) *decimal.Float {
+
+	opposite := AssigningSell
+	if order.Assigning == AssigningSell {
+		opposite = AssigningBuy
+	}
+
+	price, total := decimal.New(0), decimal.New(0)
+
+	for _, level := range *b.side(opposite) {
+
+		if !total.LessThan(quantity.Decimal) {
+			break
+		}
+
+		for _, maker := range level.Orders {
+			if maker.owner() != order.owner() {
+				total = total.Add(maker.Value.Add(maker.Hidden))
+				price = decimal.New(level.Price)
+			}
+		}
+	}
+
+	return price
+}
+
+// Indicative - This method returns the price of a call auction on the book and the quantity that would be executed at it. For
+// every price of the book the executable quantity is the lower one of the bids at or above the price and the asks at or
+// below it, hidden quantities included. The price with the highest executable quantity wins, a tie is decided by the
+// lowest surplus (the difference between both sides) and then by the distance to the reference price. The price is zero
+// when the book is not crossed.
+func (b *Book) Indicative(reference *decimal.Float) (price, volume *decimal.Float) {
+
+	var (
+		surplus = decimal.New(0)
+	)
+
+	price, volume = decimal.New(0), decimal.New(0)
+
+	for _, levels := range [][]*Level{b.bids, b.asks} {
+		for _, level := range levels {
+
+			var (
+				demand, supply = decimal.New(0), decimal.New(0)
+			)
+
+			for _, bid := range b.bids {
+				if bid.Price.LessThan(level.Price.Decimal) {
+					break
+				}
+				for _, order := range bid.Orders {
+					demand = demand.Add(order.Value).Add(order.Hidden)
+				}
+			}
+
+			for _, ask := range b.asks {
+				if ask.Price.GreaterThan(level.Price.Decimal) {
+					break
+				}
+				for _, order := range ask.Orders {
+					supply = supply.Add(order.Value).Add(order.Hidden)
+				}
+			}
+
+			executable, rest := b.min(demand, supply), demand.Sub(supply).Abs()
+			if executable.IsZero() {
+				continue
+			}
+
+			if executable.GreaterThan(volume.Decimal) || (executable.Equal(volume.Decimal) && (rest.LessThan(surplus.Decimal) || (rest.Equal(surplus.Decimal) && level.Price.Sub(reference).Abs().LessThan(price.Sub(reference).Abs())))) {
+				price, volume, surplus = decimal.New(level.Price), executable, decimal.New(rest)
+			}
+		}
+	}
+
+	return price, volume
+}
+
+// Uncross - This method ends a call auction: the resting bids at or above the clearing price are matched in price-time priority
+// against the resting asks at or below it, like incoming orders, and every fill is executed at the clearing price. Orders
+// of the same owner are not matched with each other, the prevention mode of the bid is applied to them and a cancelled
+// bid is removed from the book. The whole open value of a bid, hidden quantity included, is matched at once, the rest of
+// a partially filled bid keeps resting with its time priority.
+func (b *Book) Uncross(price *decimal.Float) (crosses []*Cross) {
+
+	var (
+		bids []*Order
+	)
+
+	// The bids are collected first, the matching below changes the levels of the book.
+	for _, level := range b.bids {
+		if level.Price.LessThan(price.Decimal) {
+			break
+		}
+		bids = append(bids, level.Orders...)
+	}
+
+	for _, bid := range bids {
+
+		if best, ok := b.Best(AssigningSell); !ok || best.GreaterThan(price.Decimal) {
+			break
+		}
+
+		// The bid is matched with the clearing price as its limit, so only the asks that take part in the auction are reached.
+		limit := bid.Price
+		bid.Price, bid.Value, bid.Hidden = *price, *bid.Value.Add(bid.Hidden), *decimal.New(0)
+
+		fills, prevented := b.Match(bid)
+		bid.Price = limit
+
+		if len(fills) == 0 && len(prevented) == 0 {
+			b.hide(bid)
+			continue
+		}
+
+		for _, fill := range fills {
+			fill.Price = *price
+		}
+		crosses = append(crosses, &Cross{Order: bid, Fills: fills, Prevented: prevented})
+
+		b.change(AssigningBuy, bid.Price)
+		if bid.Value.IsZero() || (len(prevented) > 0 && prevented[len(prevented)-1].Newest) {
+			b.Remove(bid.Id)
+			continue
+		}
+		b.hide(bid)
+	}
+
+	return crosses
+}
+
+// hide - splits the open value of an iceberg order into its display quantity and the hidden rest again.
+func (b *Book) hide(order *Order) {
+	if order.Display.IsPositive() && order.Value.GreaterThan(order.Display.Decimal) {
+		order.Hidden, order.Value = *order.Value.Sub(order.Display), order.Display
+	}
+}
+
+// replenish - refills the visible quantity of an iceberg order from its hidden quantity and gives it the lowest time priority.
+func (b *Book) replenish(order *Order) {
+
+	order.Value = order.Display
+	if order.Hidden.LessThan(order.Value.Decimal) {
+		order.Value = order.Hidden
+	}
+	order.Hidden = *order.Hidden.Sub(order.Value)
+
+	b.priority++
+	order.priority = b.priority
+}
+
+// cross - reports whether the incoming order is allowed to trade at the given price of the opposite side.
+func (b *Book) cross(order *Order, price decimal.Float) bool {
+	if order.Assigning == AssigningBuy {
+		return !order.Price.LessThan(price.Decimal)
+	}
+	return !order.Price.GreaterThan(price.Decimal)
+}
+
+// min - returns the lower one of two quantities.
+func (b *Book) min(x, y *decimal.Float) *decimal.Float {
+	if y.LessThan(x.Decimal) {
+		return y
+	}
+	return x
+}
+
+// side - returns a pointer to the levels of the requested side of the book.
+func (b *Book) side(assigning string) *[]*Level {
+	if assigning == AssigningBuy {
+		return &b.bids
+	}
+	return &b.asks
+}
+
+// search - returns the index of the price level in the sorted levels and whether the level exists, when it does not exist
+// the index is the position at which the level has to be inserted.
+func (b *Book) search(levels []*Level, assigning string, price decimal.Float) (int, bool) {
+
+	index := sort.Search(len(levels), func(i int) bool {
+		if assigning == AssigningBuy {
+			return !levels[i].Price.GreaterThan(price.Decimal)
+		}
+		return !levels[i].Price.LessThan(price.Decimal)
+	})
+
+	return index, index < len(levels) && levels[index].Price.Equal(price.Decimal)
+}
@@ -0,0 +1,418 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+)
+
+// number - returns the exact decimal of a test value.
+func number(value interface{}) decimal.Float {
+	return *decimal.New(value)
+}
+
+func TestBook_Match(t *testing.T) {
+	type args struct {
+		resting []*Order
+		order   *Order
+	}
+	type fill struct {
+		id       int64
+		price    float64
+		quantity float64
+	}
+	tests := []struct {
+		name   string
+		args   args
+		want   []fill
+		remain float64
+	}{
+		{
+			name: t.Name(),
+			args: args{
+				resting: []*Order{
+					{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(102), Value: number(1)},
+					{Id: 2, UserId: 2, Assigning: AssigningSell, Price: number(100), Value: number(1)},
+					{Id: 3, UserId: 3, Assigning: AssigningSell, Price: number(101), Value: number(1)},
+				},
+				order: &Order{Id: 4, UserId: 4, Assigning: AssigningBuy, Price: number(101), Value: number(1.5)},
+			},
+			want: []fill{
+				{id: 2, price: 100, quantity: 1},
+				{id: 3, price: 101, quantity: 0.5},
+			},
+			remain: 0,
+		},
+		{
+			name: t.Name(),
+			args: args{
+				resting: []*Order{
+					{Id: 1, UserId: 1, Assigning: AssigningBuy, Price: number(100), Value: number(1)},
+					{Id: 2, UserId: 2, Assigning: AssigningBuy, Price: number(100), Value: number(1)},
+					{Id: 3, UserId: 3, Assigning: AssigningBuy, Price: number(99), Value: number(1)},
+				},
+				order: &Order{Id: 4, UserId: 4, Assigning: AssigningSell, Price: number(100), Value: number(3)},
+			},
+			want: []fill{
+				{id: 1, price: 100, quantity: 1},
+				{id: 2, price: 100, quantity: 1},
+			},
+			remain: 1,
+		},
+		{
+			name: t.Name(),
+			args: args{
+				resting: []*Order{
+					{Id: 1, UserId: 4, Assigning: AssigningSell, Price: number(100), Value: number(1)},
+					{Id: 2, UserId: 2, Assigning: AssigningSell, Price: number(100), Value: number(0.3)},
+				},
+				order: &Order{Id: 3, UserId: 4, Assigning: AssigningBuy, Price: number(100), Value: number(1), Prevention: PreventionCancelOldest},
+			},
+			want: []fill{
+				{id: 2, price: 100, quantity: 0.3},
+			},
+			remain: 0.7,
+		},
+		{
+			name: t.Name(),
+			args: args{
+				resting: []*Order{
+					{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(100), Value: number(3), Display: number(1)},
+					{Id: 2, UserId: 2, Assigning: AssigningSell, Price: number(100), Value: number(1)},
+				},
+				order: &Order{Id: 3, UserId: 3, Assigning: AssigningBuy, Price: number(100), Value: number(2.5)},
+			},
+			want: []fill{
+				{id: 1, price: 100, quantity: 1},
+				{id: 2, price: 100, quantity: 1},
+				{id: 1, price: 100, quantity: 0.5},
+			},
+			remain: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New()
+			for _, order := range tt.args.resting {
+				b.Add(order)
+			}
+
+			got, _ := b.Match(tt.args.order)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Match() fills = %v, want %v", len(got), len(tt.want))
+			}
+
+			for i, item := range got {
+				if item.Maker.Id != tt.want[i].id || item.Price.Float() != tt.want[i].price || item.Quantity.Float() != tt.want[i].quantity {
+					t.Errorf("Match() fill = %v %v %v, want %v", item.Maker.Id, item.Price, item.Quantity, tt.want[i])
+				}
+			}
+
+			if tt.args.order.Value.Float() != tt.remain {
+				t.Errorf("Match() remain = %v, want %v", tt.args.order.Value, tt.remain)
+			}
+		})
+	}
+}
+
+func TestBook_Remove(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningBuy, Price: number(100), Value: number(1)})
+	b.Add(&Order{Id: 2, UserId: 1, Assigning: AssigningBuy, Price: number(101), Value: number(1)})
+
+	if price, _ := b.Best(AssigningBuy); price.Float() != 101 {
+		t.Errorf("Best() = %v, want %v", price, 101)
+	}
+
+	if _, ok := b.Remove(2); !ok {
+		t.Errorf("Remove() = %v, want %v", ok, true)
+	}
+
+	if price, _ := b.Best(AssigningBuy); price.Float() != 100 {
+		t.Errorf("Best() = %v, want %v", price, 100)
+	}
+
+	if levels := b.Levels(AssigningBuy); len(levels) != 1 {
+		t.Errorf("Levels() = %v, want %v", len(levels), 1)
+	}
+}
+
+func TestBook_Amend(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(100), Value: number(2)})
+	b.Add(&Order{Id: 2, UserId: 2, Assigning: AssigningSell, Price: number(100), Value: number(1)})
+
+	if !b.Amend(1, decimal.New(1.5)) {
+		t.Fatalf("Amend() = %v, want %v", false, true)
+	}
+
+	if b.Amend(1, decimal.New(3)) {
+		t.Errorf("Amend() = %v, want %v", true, false)
+	}
+
+	fills, _ := b.Match(&Order{Id: 3, UserId: 3, Assigning: AssigningBuy, Price: number(100), Value: number(1)})
+	if len(fills) != 1 || fills[0].Maker.Id != 1 || fills[0].Quantity.Float() != 1 {
+		t.Errorf("Match() after Amend() = %v, want order 1 to keep its priority", fills)
+	}
+
+	b.Add(&Order{Id: 4, UserId: 4, Assigning: AssigningBuy, Price: number(99), Value: number(5), Display: number(1)})
+	if !b.Amend(4, decimal.New(2.5)) {
+		t.Fatalf("Amend() = %v, want %v", false, true)
+	}
+
+	if order, _ := b.Get(4); order.Value.Float() != 1 || order.Hidden.Float() != 1.5 {
+		t.Errorf("Amend() iceberg = %v %v, want %v %v", order.Value, order.Hidden, 1, 1.5)
+	}
+}
+
+func TestBook_Prevention(t *testing.T) {
+	tests := []struct {
+		name       string
+		prevention string
+		fills      int
+		remain     float64
+		resting    []int64
+		newest     bool
+		oldest     bool
+		quantity   float64
+	}{
+		{
+			name:       t.Name(),
+			prevention: PreventionCancelNewest,
+			fills:      1,
+			remain:     2,
+			resting:    []int64{2, 3},
+			newest:     true,
+		},
+		{
+			name:       t.Name(),
+			prevention: PreventionCancelOldest,
+			fills:      2,
+			remain:     1,
+			resting:    []int64{},
+			oldest:     true,
+		},
+		{
+			name:       t.Name(),
+			prevention: PreventionCancelBoth,
+			fills:      1,
+			remain:     2,
+			resting:    []int64{3},
+			newest:     true,
+			oldest:     true,
+		},
+		{
+			name:       t.Name(),
+			prevention: PreventionDecrement,
+			fills:      2,
+			remain:     0,
+			resting:    []int64{},
+			quantity:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New()
+			b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(100), Value: number(1)})
+			b.Add(&Order{Id: 2, UserId: 2, Owner: 4, Assigning: AssigningSell, Price: number(100), Value: number(1)})
+			b.Add(&Order{Id: 3, UserId: 3, Assigning: AssigningSell, Price: number(101), Value: number(1)})
+
+			order := &Order{Id: 4, UserId: 4, Assigning: AssigningBuy, Price: number(101), Value: number(3), Prevention: tt.prevention}
+			fills, prevented := b.Match(order)
+
+			if len(fills) != tt.fills || order.Value.Float() != tt.remain {
+				t.Errorf("Match() fills = %v remain = %v, want %v %v", len(fills), order.Value, tt.fills, tt.remain)
+			}
+
+			if len(prevented) != 1 || prevented[0].Maker.Id != 2 || prevented[0].Newest != tt.newest || prevented[0].Oldest != tt.oldest || prevented[0].Quantity.Float() != tt.quantity {
+				t.Fatalf("Match() prevented = %v, want the linked order 2", prevented)
+			}
+
+			for _, id := range tt.resting {
+				if _, ok := b.Get(id); !ok {
+					t.Errorf("Get(%v) = %v, want the order resting", id, ok)
+				}
+			}
+
+			if _, ok := b.Get(2); ok != (tt.prevention == PreventionCancelNewest) {
+				t.Errorf("Get(2) = %v, want %v", ok, tt.prevention == PreventionCancelNewest)
+			}
+		})
+	}
+}
+
+func TestBook_Fillable(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(100), Value: number(1)})
+	b.Add(&Order{Id: 2, UserId: 2, Assigning: AssigningSell, Price: number(101), Value: number(1)})
+	b.Add(&Order{Id: 3, UserId: 3, Assigning: AssigningSell, Price: number(102), Value: number(1)})
+
+	order := &Order{Id: 4, UserId: 2, Assigning: AssigningBuy, Price: number(101), Value: number(3)}
+	if quantity := b.Fillable(order); quantity.Float() != 1 {
+		t.Errorf("Fillable() = %v, want %v", quantity, 1)
+	}
+
+	if !b.Marketable(order) {
+		t.Errorf("Marketable() = %v, want %v", false, true)
+	}
+
+	if b.Marketable(&Order{Id: 5, UserId: 5, Assigning: AssigningBuy, Price: number(99), Value: number(1)}) {
+		t.Errorf("Marketable() = %v, want %v", true, false)
+	}
+
+	if quantity := b.Fillable(&Order{Id: 6, UserId: 6, Assigning: AssigningBuy, Price: number(102), Value: number(2.5)}); quantity.Float() != 2.5 {
+		t.Errorf("Fillable() = %v, want %v", quantity, 2.5)
+	}
+}
+
+func TestBook_Sweep(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(100), Value: number(1)})
+	b.Add(&Order{Id: 2, UserId: 2, Assigning: AssigningSell, Price: number(110), Value: number(1)})
+	b.Add(&Order{Id: 3, UserId: 3, Assigning: AssigningSell, Price: number(120), Value: number(1)})
+	b.Add(&Order{Id: 4, UserId: 4, Assigning: AssigningBuy, Price: number(90), Value: number(2)})
+
+	if quantity, price, rest := b.Sweep(&Order{UserId: 5, Assigning: AssigningBuy}, decimal.New(270)); quantity.Float() != 2.5 || price.Float() != 120 || !rest.IsZero() {
+		t.Errorf("Sweep() = %v %v %v, want %v %v %v", quantity, price, rest, 2.5, 120, 0)
+	}
+
+	if quantity, price, rest := b.Sweep(&Order{UserId: 5, Assigning: AssigningBuy, Price: number(110)}, decimal.New(1000)); quantity.Float() != 2 || price.Float() != 110 || rest.Float() != 790 {
+		t.Errorf("Sweep() = %v %v %v, want %v %v %v", quantity, price, rest, 2, 110, 790)
+	}
+
+	if quantity, price, rest := b.Sweep(&Order{UserId: 5, Assigning: AssigningSell}, decimal.New(5)); quantity.Float() != 2 || price.Float() != 90 || rest.Float() != 3 {
+		t.Errorf("Sweep() = %v %v %v, want %v %v %v", quantity, price, rest, 2, 90, 3)
+	}
+}
+
+func TestBook_Reach(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(100), Value: number(1)})
+	b.Add(&Order{Id: 2, UserId: 2, Assigning: AssigningSell, Price: number(110), Value: number(1)})
+	b.Add(&Order{Id: 3, UserId: 3, Assigning: AssigningSell, Price: number(120), Value: number(1)})
+
+	if price := b.Reach(&Order{UserId: 5, Assigning: AssigningBuy}, decimal.New(1.5)); price.Float() != 110 {
+		t.Errorf("Reach() = %v, want %v", price, 110)
+	}
+
+	if price := b.Reach(&Order{UserId: 2, Assigning: AssigningBuy}, decimal.New(1.5)); price.Float() != 120 {
+		t.Errorf("Reach() = %v, want %v", price, 120)
+	}
+
+	if price := b.Reach(&Order{UserId: 5, Assigning: AssigningBuy}, decimal.New(10)); price.Float() != 120 {
+		t.Errorf("Reach() = %v, want %v", price, 120)
+	}
+
+	if price := b.Reach(&Order{UserId: 5, Assigning: AssigningSell}, decimal.New(1)); !price.IsZero() {
+		t.Errorf("Reach() = %v, want %v", price, 0)
+	}
+}
+
+func TestBook_Indicative(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningBuy, Price: number(102), Value: number(1)})
+	b.Add(&Order{Id: 2, UserId: 2, Assigning: AssigningBuy, Price: number(101), Value: number(2)})
+	b.Add(&Order{Id: 3, UserId: 3, Assigning: AssigningBuy, Price: number(99), Value: number(1)})
+	b.Add(&Order{Id: 4, UserId: 4, Assigning: AssigningSell, Price: number(100), Value: number(2)})
+	b.Add(&Order{Id: 5, UserId: 5, Assigning: AssigningSell, Price: number(101), Value: number(2)})
+
+	if price, volume := b.Indicative(decimal.New(100)); price.Float() != 101 || volume.Float() != 3 {
+		t.Errorf("Indicative() = %v %v, want %v %v", price, volume, 101, 3)
+	}
+
+	if price, volume := New().Indicative(decimal.New(100)); !price.IsZero() || !volume.IsZero() {
+		t.Errorf("Indicative() = %v %v, want %v %v", price, volume, 0, 0)
+	}
+}
+
+func TestBook_Uncross(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningBuy, Price: number(102), Value: number(1)})
+	b.Add(&Order{Id: 2, UserId: 2, Assigning: AssigningBuy, Price: number(101), Value: number(3), Display: number(1)})
+	b.Add(&Order{Id: 3, UserId: 3, Assigning: AssigningSell, Price: number(100), Value: number(2)})
+	b.Add(&Order{Id: 4, UserId: 4, Assigning: AssigningSell, Price: number(101), Value: number(1)})
+
+	crosses := b.Uncross(decimal.New(101))
+	if len(crosses) != 2 || crosses[0].Order.Id != 1 || crosses[1].Order.Id != 2 {
+		t.Fatalf("Uncross() = %v, want the bids 1 and 2", crosses)
+	}
+
+	for _, cross := range crosses {
+		for _, fill := range cross.Fills {
+			if fill.Price.Float() != 101 {
+				t.Errorf("Uncross() fill price = %v, want %v", fill.Price, 101)
+			}
+		}
+	}
+
+	if _, ok := b.Get(1); ok {
+		t.Errorf("Get() = %v, want the filled bid removed", ok)
+	}
+
+	if order, _ := b.Get(2); order.Value.Float() != 1 || !order.Hidden.IsZero() {
+		t.Errorf("Uncross() rest = %v %v, want %v %v", order.Value, order.Hidden, 1, 0)
+	}
+
+	if _, ok := b.Best(AssigningSell); ok {
+		t.Errorf("Best() = %v, want no asks left", ok)
+	}
+}
+
+func TestBook_Depth(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(100.12), Value: number(1)})
+	b.Add(&Order{Id: 2, UserId: 1, Assigning: AssigningSell, Price: number(100.18), Value: number(2)})
+	b.Add(&Order{Id: 3, UserId: 1, Assigning: AssigningSell, Price: number(100.25), Value: number(0.5)})
+	b.Add(&Order{Id: 4, UserId: 1, Assigning: AssigningBuy, Price: number(99.98), Value: number(1)})
+	b.Add(&Order{Id: 5, UserId: 1, Assigning: AssigningBuy, Price: number(99.91), Value: number(1)})
+
+	asks := b.Depth(AssigningSell, 0, 1)
+	if len(asks) != 2 || asks[0].Price.Float() != 100.2 || asks[0].Quantity.Float() != 3 || asks[0].Count != 2 || asks[1].Price.Float() != 100.3 {
+		t.Errorf("Depth() asks = %v, %v", asks[0], asks[len(asks)-1])
+	}
+
+	bids := b.Depth(AssigningBuy, 1, 1)
+	if len(bids) != 1 || bids[0].Price.Float() != 99.9 || bids[0].Quantity.Float() != 2 {
+		t.Errorf("Depth() bids = %v", bids[0])
+	}
+}
+
+func TestBook_Flush(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number(100), Value: number(1)})
+	b.Add(&Order{Id: 2, UserId: 1, Assigning: AssigningSell, Price: number(101), Value: number(1)})
+
+	if diff := b.Flush(); diff == nil || diff.Sequence != 1 || len(diff.Asks) != 2 {
+		t.Fatalf("Flush() = %v, want sequence 1 with 2 asks", diff)
+	}
+
+	if diff := b.Flush(); diff != nil {
+		t.Errorf("Flush() = %v, want nil", diff)
+	}
+
+	b.Match(&Order{Id: 3, UserId: 2, Assigning: AssigningBuy, Price: number(100), Value: number(1)})
+
+	diff := b.Flush()
+	if diff == nil || diff.Sequence != 2 || len(diff.Asks) != 1 || diff.Asks[0].Price.Float() != 100 || !diff.Asks[0].Quantity.IsZero() {
+		t.Errorf("Flush() = %v, want the removed level 100", diff)
+	}
+
+	if snapshot := b.Snapshot(); snapshot.Sequence != 2 || len(snapshot.Asks) != 1 || snapshot.Asks[0].Price.Float() != 101 {
+		t.Errorf("Snapshot() = %v, want sequence 2 with the level 101", snapshot)
+	}
+}
+
+func TestBook_Exact(t *testing.T) {
+	b := New()
+	b.Add(&Order{Id: 1, UserId: 1, Assigning: AssigningSell, Price: number("0.3"), Value: number("1")})
+
+	for i := int64(0); i < 10; i++ {
+		if fills, _ := b.Match(&Order{Id: 2 + i, UserId: 2, Assigning: AssigningBuy, Price: number("0.3"), Value: number("0.1")}); len(fills) != 1 || fills[0].Quantity.String() != "0.1" {
+			t.Fatalf("Match() = %v, want a fill of 0.1", fills)
+		}
+	}
+
+	if _, ok := b.Get(1); ok {
+		t.Errorf("Get() = %v, want the order filled without a rest", ok)
+	}
+}
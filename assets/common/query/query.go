@@ -284,6 +284,18 @@ func (m *Migrate) SendMail(userId int64, name string, params ...interface{}) {
 		response.Subject = "Reset password"
 		response.Text = fmt.Sprintf("Your new password <b>%v</b>", params[0].(string))
 		break
+	case "margin_call":
+		response.Subject = "Margin call"
+		response.Text = fmt.Sprintf("The margin level of your <b>%v</b> margin account fell to <b>%.4f</b>, please repay your loans or transfer more collateral to the account.", params[0].(string), params[1].(float64))
+		break
+	case "margin_liquidation":
+		response.Subject = "Margin liquidation"
+		response.Text = fmt.Sprintf("Your <b>%v</b> margin account was liquidated at the margin level <b>%.4f</b>, its open orders were cancelled and its loans were repaid from its collateral.", params[0].(string), params[1].(float64))
+		break
+	case "future_liquidation":
+		response.Subject = "Futures liquidation"
+		response.Text = fmt.Sprintf("Your <b>%v</b> position on <b>%v/%v</b> fell below its maintenance margin at the mark price <b>%v</b> and was liquidated, its open orders were cancelled.", params[0].(string), strings.ToUpper(params[1].(string)), strings.ToUpper(params[2].(string)), params[3].(float64))
+		break
 	}
 
 	// The code is likely part of a program that generates an HTML response to a client. The first line executes a template
@@ -295,10 +307,12 @@ func (m *Migrate) SendMail(userId int64, name string, params ...interface{}) {
 		return
 	}
 
-	// This if statement is checking if the response.Sample, name, "secure", and "new_password" parameters are comparable.
-	// If they are comparable, the statement will evaluate to true and the code inside the block will be executed. If not,
-	// the statement will evaluate to false and the code inside the block will not be executed.
-	if help.Comparable(response.Sample, name, "secure", "new_password") {
+	// This if statement is checking if the response.Sample, name, "secure", "new_password", "margin_call",
+	// "margin_liquidation" and "future_liquidation" parameters are comparable. If they are comparable, the statement will
+	// evaluate to true and the code inside the block will be executed. If not, the statement will evaluate to false and
+	// the code inside the block will not be executed. The margin and liquidation mails are always sent, like the security
+	// mails.
+	if help.Comparable(response.Sample, name, "secure", "new_password", "margin_call", "margin_liquidation", "future_liquidation") {
 
 		// The purpose of the line of code "g := gomail.NewMessage()" is to create a new instance of a gomail message, which is
 		// used to send emails. The "g" is a variable that holds the reference to the newly created message.
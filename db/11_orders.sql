@@ -16,6 +16,13 @@ create table if not exists public.orders
     type       varchar                  default 'spot'::character varying    not null,
     trading    varchar                  default 'limit'::character varying   not null,
     status     varchar                  default 'pending'::character varying not null,
+    trigger_price numeric(20, 8)        default 0.00000000                   not null,
+    time_in_force varchar               default 'gtc'::character varying     not null,
+    post_only  boolean                  default false                        not null,
+    display    numeric(32, 18)          default 0.000000000000000000         not null,
+    client_id  varchar,
+    prevention varchar                  default 'cancel_newest'::character varying not null,
+    expire_at  timestamp with time zone,
     create_at  timestamp with time zone default CURRENT_TIMESTAMP
 );
 
@@ -26,4 +33,7 @@ alter table public.orders
     add unique (id);
 
 create unique index if not exists orders_id_uindex
-    on public.orders (id);
\ No newline at end of file
+    on public.orders (id);
+
+create unique index if not exists orders_client_id_uindex
+    on public.orders (user_id, client_id);
\ No newline at end of file
@@ -10,6 +10,18 @@ create table if not exists public.pairs
     price         numeric(20, 8) default 0.00000000                not null,
     base_decimal  integer        default 2                         not null,
     quote_decimal integer        default 8                         not null,
+    tick_size     numeric(20, 8)  default 0.00000000                not null,
+    step_size     numeric(32, 18) default 0.000000000000000000      not null,
+    min_notional  numeric(32, 18) default 0.000000000000000000      not null,
+    max_notional  numeric(32, 18) default 0.000000000000000000      not null,
+    price_band    numeric(8, 4)  default 0.0000                    not null,
+    halt_change   numeric(8, 4)  default 0.0000                    not null,
+    halt_window   integer        default 0                         not null,
+    halt_cooldown integer        default 0                         not null,
+    halted        boolean        default false                     not null,
+    resume_at     timestamp with time zone,
+    auction       integer        default 0                         not null,
+    auction_end   timestamp with time zone,
     type          varchar        default 'spot'::character varying not null,
     status        boolean        default false                     not null
 );
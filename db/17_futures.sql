@@ -17,11 +17,12 @@ CREATE TABLE public.futures (
 	stop_loss numeric(4, 4) NULL,
 	status varchar(8) NULL,
 	create_at timestamptz NULL DEFAULT CURRENT_TIMESTAMP,
-	leverage numeric(4) NULL DEFAULT 1,
+	leverage numeric(8, 4) NULL DEFAULT 1,
 	user_id numeric(8) NOT NULL,
 	fees numeric(16, 8) NULL,
 	"mode" varchar(8) NULL DEFAULT 'cross'::character varying,
 	value numeric(16, 8) NOT NULL DEFAULT 0,
+	margin numeric(32, 18) NOT NULL DEFAULT 0,
 	CONSTRAINT futures_pkey PRIMARY KEY (id)
 );
 
@@ -0,0 +1,26 @@
+create table if not exists public.fees
+(
+    id         serial
+        constraint fees_pk
+            primary key,
+    level      integer                  default 0                             not null,
+    volume     numeric(32, 18)          default 0.000000000000000000          not null,
+    maker      numeric(6, 4)            default 0.1000                        not null,
+    taker      numeric(6, 4)            default 0.1000                        not null,
+    base_unit  varchar                  default ''::character varying         not null,
+    quote_unit varchar                  default ''::character varying         not null,
+    create_at  timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.fees
+    owner to envoys;
+
+create unique index if not exists fees_level_uindex
+    on public.fees (level, base_unit, quote_unit);
+
+insert into public.fees (level, volume, maker, taker)
+values  (0, 0, 0.1000, 0.1000),
+        (1, 50000, 0.0800, 0.1000),
+        (2, 500000, 0.0600, 0.0800),
+        (3, 5000000, 0.0400, 0.0600),
+        (4, 50000000, 0.0200, 0.0400);
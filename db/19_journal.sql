@@ -0,0 +1,50 @@
+create table if not exists public.journal
+(
+    id         bigserial
+        constraint journal_pk
+            primary key,
+    sequence   bigint                                                         not null,
+    event      varchar                                                        not null,
+    order_id   bigint                                                         not null,
+    maker_id   bigint                   default 0                             not null,
+    user_id    integer                                                        not null,
+    assigning  varchar                  default ''::character varying         not null,
+    base_unit  varchar                                                        not null,
+    quote_unit varchar                                                        not null,
+    type       varchar                                                        not null,
+    price      numeric(32, 18)          default 0.000000000000000000          not null,
+    quantity   numeric(32, 18)          default 0.000000000000000000          not null,
+    create_at  timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.journal
+    owner to envoys;
+
+create unique index if not exists journal_sequence_uindex
+    on public.journal (base_unit, quote_unit, type, sequence);
+
+create index if not exists journal_order_id_index
+    on public.journal (order_id);
+
+create table if not exists public.journal_balances
+(
+    id         bigserial
+        constraint journal_balances_pk
+            primary key,
+    journal_id bigint                                                         not null
+        constraint journal_balances_journal_id_fk
+            references public.journal
+            on delete cascade,
+    user_id    integer                                                        not null,
+    symbol     varchar                                                        not null,
+    type       varchar                                                        not null,
+    quantity   numeric(32, 18)                                                not null,
+    balance    numeric(32, 18)                                                not null,
+    create_at  timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.journal_balances
+    owner to envoys;
+
+create index if not exists journal_balances_user_id_index
+    on public.journal_balances (user_id, symbol, type);
@@ -0,0 +1,16 @@
+create table if not exists public.links
+(
+    id         serial
+        constraint links_pk
+            primary key,
+    user_id    integer                                                        not null,
+    owner_id   integer                  default 0                             not null,
+    prevention varchar                  default ''::character varying         not null,
+    create_at  timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.links
+    owner to envoys;
+
+create unique index if not exists links_user_id_uindex
+    on public.links (user_id);
@@ -0,0 +1,62 @@
+create table if not exists public.loans
+(
+    id        serial
+        constraint loans_pk
+            primary key,
+    user_id   integer                                                        not null,
+    symbol    varchar                                                        not null,
+    type      varchar                  default 'cross'::character varying    not null,
+    principal numeric(32, 18)          default 0.000000000000000000          not null,
+    interest  numeric(32, 18)          default 0.000000000000000000          not null,
+    reserve   numeric(32, 18)          default 0.000000000000000000          not null,
+    accrue_at timestamp with time zone default CURRENT_TIMESTAMP             not null,
+    call_at   timestamp with time zone,
+    create_at timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.loans
+    owner to envoys;
+
+create unique index if not exists loans_user_id_symbol_type_uindex
+    on public.loans (user_id, symbol, type);
+
+create table if not exists public.interests
+(
+    id        bigserial
+        constraint interests_pk
+            primary key,
+    loan_id   integer
+        constraint interests_loan_id_fk
+            references public.loans
+            on delete set null,
+    user_id   integer                                                        not null,
+    symbol    varchar                                                        not null,
+    type      varchar                  default 'cross'::character varying    not null,
+    quantity  numeric(32, 18)          default 0.000000000000000000          not null,
+    rate      numeric(8, 4)            default 0.0000                        not null,
+    create_at timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.interests
+    owner to envoys;
+
+create index if not exists interests_user_id_index
+    on public.interests (user_id);
+
+create table if not exists public.yields
+(
+    id        bigserial
+        constraint yields_pk
+            primary key,
+    user_id   integer                                                        not null,
+    symbol    varchar                                                        not null,
+    quantity  numeric(32, 18)          default 0.000000000000000000          not null,
+    rate      numeric(8, 4)            default 0.0000                        not null,
+    create_at timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.yields
+    owner to envoys;
+
+create index if not exists yields_user_id_index
+    on public.yields (user_id);
@@ -0,0 +1,23 @@
+create table if not exists public.positions
+(
+    id          serial
+        constraint positions_pk
+            primary key,
+    user_id     integer                                                        not null,
+    base_unit   varchar                                                        not null,
+    quote_unit  varchar                                                        not null,
+    position    varchar                  default 'long'::character varying     not null,
+    size        numeric(32, 18)          default 0.000000000000000000          not null,
+    entry_price numeric(32, 18)          default 0.000000000000000000          not null,
+    leverage    numeric(8, 4)            default 1.0000                        not null,
+    margin      numeric(32, 18)          default 0.000000000000000000          not null,
+    realized    numeric(32, 18)          default 0.000000000000000000          not null,
+    update_at   timestamp with time zone default CURRENT_TIMESTAMP             not null,
+    create_at   timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.positions
+    owner to envoys;
+
+create unique index if not exists positions_user_id_base_unit_quote_unit_position_uindex
+    on public.positions (user_id, base_unit, quote_unit, position);
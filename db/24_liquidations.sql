@@ -0,0 +1,31 @@
+alter table public.futures
+    alter column trading type varchar(16);
+
+create table if not exists public.liquidations
+(
+    id               serial
+        constraint liquidations_pk
+            primary key,
+    user_id          integer                                                        not null,
+    position_id      integer
+        constraint liquidations_position_id_fk
+            references public.positions
+            on delete set null,
+    base_unit        varchar                                                        not null,
+    quote_unit       varchar                                                        not null,
+    position         varchar                                                        not null,
+    size             numeric(32, 18)          default 0.000000000000000000          not null,
+    entry_price      numeric(32, 18)          default 0.000000000000000000          not null,
+    mark_price       numeric(32, 18)          default 0.000000000000000000          not null,
+    bankruptcy_price numeric(32, 18)          default 0.000000000000000000          not null,
+    margin           numeric(32, 18)          default 0.000000000000000000          not null,
+    closed           numeric(32, 18)          default 0.000000000000000000          not null,
+    taken            numeric(32, 18)          default 0.000000000000000000          not null,
+    create_at        timestamp with time zone default CURRENT_TIMESTAMP             not null
+);
+
+alter table public.liquidations
+    owner to envoys;
+
+create index if not exists liquidations_user_id_index
+    on public.liquidations (user_id);
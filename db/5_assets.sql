@@ -16,6 +16,12 @@ create table if not exists public.assets
     fees_charges  numeric(32, 18)          default 0.000000000000000000        not null,
     fees_costs    numeric(32, 18)          default 0.000000000000000000        not null,
     marker        boolean                  default false                       not null,
+    margin        boolean                  default false                       not null,
+    borrow_rate   numeric(8, 4)            default 0.0000                      not null,
+    borrow_slope  numeric(8, 4)            default 0.0000                      not null,
+    borrow_jump   numeric(8, 4)            default 0.0000                      not null,
+    borrow_kink   numeric(4, 4)            default 0.8000                      not null,
+    reserve_rate  numeric(4, 4)            default 0.1000                      not null,
     chains        jsonb                    default '[]'::jsonb                 not null,
     status        boolean                  default false                       not null,
     "group"       varchar                  default 'crypto'::character varying not null,
@@ -16,6 +16,7 @@ create table if not exists public.trades
     assigning  varchar                  default 'buy'::character varying not null,
     fees       double precision,
     maker      boolean                  default false             not null,
+    type       varchar                  default 'spot'::character varying not null,
     create_at  timestamp with time zone default CURRENT_TIMESTAMP not null
 );
 
@@ -26,4 +27,7 @@ alter table public.trades
     add unique (id);
 
 create unique index if not exists trades_id_uindex
-    on public.trades (id);
\ No newline at end of file
+    on public.trades (id);
+
+create index if not exists trades_user_id_create_at_index
+    on public.trades (user_id, create_at);
@@ -209,8 +209,9 @@ func Register(option *assets.Context) {
 		pbaccount.RegisterApiServer(srv, &account.Service{Context: option})
 		pbads.RegisterApiServer(srv, &ads.Service{Context: option})
 		pbkyc.RegisterApiServer(srv, &kyc.Service{Context: option})
-		// serviceFuture := future.Service{Context: option}
-		pbfuture.RegisterApiServer(srv, &future.Service{Context: option})
+		serviceFuture := future.Service{Context: option}
+		serviceFuture.Initialization()
+		pbfuture.RegisterApiServer(srv, &serviceFuture)
 
 		admin_pbaccount.RegisterApiServer(srv, &admin_account.Service{Context: option})
 		admin_pbads.RegisterApiServer(srv, &admin_ads.Service{Context: option})
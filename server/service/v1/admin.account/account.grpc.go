@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/cryptogateway/backend-envoys/assets/common/query"
 	"github.com/cryptogateway/backend-envoys/server/proto/v1/admin.pbaccount"
+	"github.com/cryptogateway/backend-envoys/server/service/v2/provider"
 	"github.com/cryptogateway/backend-envoys/server/types"
 	"google.golang.org/grpc/status"
 	"strings"
@@ -244,3 +245,179 @@ func (a *Service) SetAccount(ctx context.Context, req *admin_pbaccount.SetReques
 
 	return &response, nil
 }
+
+// GetLinks - This function returns the links between accounts used by the self-trade prevention, page by page. The links of a
+// single owner are returned when an owner is given in the request.
+func (a *Service) GetLinks(ctx context.Context, req *admin_pbaccount.GetRequestLinks) (*admin_pbaccount.ResponseLink, error) {
+
+	var (
+		response admin_pbaccount.ResponseLink
+		migrate  = query.Migrate{
+			Context: a.Context,
+		}
+	)
+
+	// The purpose of this code is to check if the value of the variable req.GetLimit() is equal to 0, and if it is, set the
+	// value of the variable req.Limit to 30.
+	if req.GetLimit() == 0 {
+		req.Limit = 30
+	}
+
+	// This code snippet is used to authenticate a user in a given context. It assigns the authentication details to the
+	// variable auth, and if an error is encountered, it returns an error response and the corresponding error.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking if a user has the correct permissions to read the data. If they do not have the correct
+	// permissions, an error is returned with the message "you do not have rules for writing and editing data".
+	if !migrate.Rules(auth, "accounts", query.RoleDefault) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	// The links are counted first, the rows are only read when there is something to return.
+	if _ = a.Context.Db.QueryRow(`select count(*) as count from links where $1 = 0 or owner_id = $1`, req.GetOwnerId()).Scan(&response.Count); response.GetCount() > 0 {
+
+		// The offset of the page is calculated from the limit and the page number, pages start with one.
+		offset := req.GetLimit() * req.GetPage()
+		if req.GetPage() > 0 {
+			offset = req.GetLimit() * (req.GetPage() - 1)
+		}
+
+		rows, err := a.Context.Db.Query(`select id, user_id, owner_id, prevention, create_at from links where $1 = 0 or owner_id = $1 order by id desc limit $2 offset $3`, req.GetOwnerId(), req.GetLimit(), offset)
+		if err != nil {
+			return &response, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+
+			var (
+				item types.Link
+			)
+
+			if err = rows.Scan(&item.Id, &item.UserId, &item.OwnerId, &item.Prevention, &item.CreateAt); err != nil {
+				return &response, err
+			}
+
+			response.Fields = append(response.Fields, &item)
+		}
+
+		// The purpose of this code is to check for any errors that occurred while operating on the rows, and to return an
+		// error response if there is an issue.
+		if err = rows.Err(); err != nil {
+			return &response, err
+		}
+	}
+
+	return &response, nil
+}
+
+// SetLink - This function links the user to the owner account, the orders of both accounts are then treated as orders of the same
+// owner and never trade with each other. A link without an owner only sets the default self-trade prevention mode of the
+// account. When the owner is a broker, the agents of the broker can be linked to it in the same request. The order books
+// are loaded again, so that the resting orders follow the new links.
+func (a *Service) SetLink(ctx context.Context, req *admin_pbaccount.SetRequestLink) (*admin_pbaccount.ResponseLink, error) {
+
+	var (
+		response admin_pbaccount.ResponseLink
+		migrate  = query.Migrate{
+			Context: a.Context,
+		}
+	)
+
+	// This code snippet is used to authenticate a user in a given context. It assigns the authentication details to the
+	// variable auth, and if an error is encountered, it returns an error response and the corresponding error.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking if a user has the correct permissions to write and edit data. If they do not have the correct
+	// permissions, an error is returned with the message "you do not have rules for writing and editing data".
+	if !migrate.Rules(auth, "accounts", query.RoleDefault) || migrate.Rules(auth, "deny-record", query.RoleDefault) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	if req.Link.GetUserId() == 0 || req.Link.GetUserId() == req.Link.GetOwnerId() {
+		return &response, status.Error(31790, "an account can only be linked to another account")
+	}
+
+	if len(req.Link.GetPrevention()) > 0 {
+		if err := types.Prevention(req.Link.GetPrevention()); err != nil {
+			return &response, err
+		}
+	}
+
+	// The links are only one level deep, the owner of an order is resolved with a single lookup. So the owner can not be
+	// linked to an owner of its own, and an account that other accounts are linked to can not be linked to an owner.
+	if req.Link.GetOwnerId() > 0 {
+
+		var (
+			exist bool
+		)
+
+		if _ = a.Context.Db.QueryRow("select exists(select id from links where (user_id = $1 and owner_id > 0) or owner_id = $2)::bool", req.Link.GetOwnerId(), req.Link.GetUserId()).Scan(&exist); exist {
+			return &response, status.Error(31791, "an account can not be linked to a linked account, nor can an owner of linked accounts be linked")
+		}
+	}
+
+	if _, err := a.Context.Db.Exec("insert into links (user_id, owner_id, prevention) values ($1, $2, $3) on conflict (user_id) do update set owner_id = excluded.owner_id, prevention = excluded.prevention", req.Link.GetUserId(), req.Link.GetOwnerId(), req.Link.GetPrevention()); err != nil {
+		return &response, err
+	}
+
+	// The agents of the broker are linked to the account of the broker, their own default modes are kept. An agent that other
+	// accounts are linked to is left out, the links stay one level deep.
+	if req.GetAgents() && req.Link.GetOwnerId() > 0 {
+		if _, err := a.Context.Db.Exec("insert into links (user_id, owner_id) select a.user_id, b.user_id from agents a, agents b where b.user_id = $1 and b.type = $2 and a.broker_id = b.id and a.user_id != b.user_id and not exists(select id from links l where l.owner_id = a.user_id) on conflict (user_id) do update set owner_id = excluded.owner_id", req.Link.GetOwnerId(), types.UserTypeBroker); err != nil {
+			return &response, err
+		}
+	}
+
+	// Provider is used to create a Service instance with the given context.
+	_provider := provider.Service{
+		Context: a.Context,
+	}
+	_provider.ResetBooks()
+	response.Success = true
+
+	return &response, nil
+}
+
+// DeleteLink - This function removes the link of the user, the orders of the user trade as orders of the user itself again.
+func (a *Service) DeleteLink(ctx context.Context, req *admin_pbaccount.DeleteRequestLink) (*admin_pbaccount.ResponseLink, error) {
+
+	var (
+		response admin_pbaccount.ResponseLink
+		migrate  = query.Migrate{
+			Context: a.Context,
+		}
+	)
+
+	// This code snippet is used to authenticate a user in a given context. It assigns the authentication details to the
+	// variable auth, and if an error is encountered, it returns an error response and the corresponding error.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking if a user has the correct permissions to write and edit data. If they do not have the correct
+	// permissions, an error is returned with the message "you do not have rules for writing and editing data".
+	if !migrate.Rules(auth, "accounts", query.RoleDefault) || migrate.Rules(auth, "deny-record", query.RoleDefault) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	if _, err := a.Context.Db.Exec("delete from links where user_id = $1", req.GetUserId()); err != nil {
+		return &response, err
+	}
+
+	// Provider is used to create a Service instance with the given context.
+	_provider := provider.Service{
+		Context: a.Context,
+	}
+	_provider.ResetBooks()
+	response.Success = true
+
+	return &response, nil
+}
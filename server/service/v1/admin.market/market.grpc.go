@@ -128,7 +128,7 @@ func (e *Service) SetAsset(ctx context.Context, req *admin_pbmarket.SetRequestAs
 		// database. This statement is written in the Go programming language, and it uses the Exec method to execute a SQL
 		// query that updates the asset's name, symbol, min/max withdraw/deposit/trade, fees, marker, status, type, and
 		// chains based on the parameters passed in through the req object. The last parameter, req.GetSymbol(), is used to identify which record should be updated.
-		if _, err := e.Context.Db.Exec(`update assets set name = $1, symbol = $2, min_withdraw = $3, max_withdraw = $4, min_trade = $5, max_trade = $6, fees_trade = $7, fees_discount = $8, marker = $9, status = $10, "group" = $11, chains = $12 where symbol = $13;`,
+		if _, err := e.Context.Db.Exec(`update assets set name = $1, symbol = $2, min_withdraw = $3, max_withdraw = $4, min_trade = $5, max_trade = $6, fees_trade = $7, fees_discount = $8, marker = $9, status = $10, "group" = $11, chains = $12, margin = $14, borrow_rate = $15, borrow_slope = $16, borrow_jump = $17, borrow_kink = $18, reserve_rate = $19 where symbol = $13;`,
 			req.Asset.GetName(),
 			req.Asset.GetSymbol(),
 			req.Asset.GetMinWithdraw(),
@@ -142,6 +142,12 @@ func (e *Service) SetAsset(ctx context.Context, req *admin_pbmarket.SetRequestAs
 			req.Asset.GetGroup(),
 			serialize,
 			req.GetSymbol(),
+			req.Asset.GetMargin(),
+			req.Asset.GetBorrowRate(),
+			req.Asset.GetBorrowSlope(),
+			req.Asset.GetBorrowJump(),
+			req.Asset.GetBorrowKink(),
+			req.Asset.GetReserveRate(),
 		); err != nil {
 			return &response, err
 		}
@@ -156,6 +162,9 @@ func (e *Service) SetAsset(ctx context.Context, req *admin_pbmarket.SetRequestAs
 			_, _ = e.Context.Db.Exec("update orders set base_unit = coalesce(nullif(base_unit, $1), $2), quote_unit = coalesce(nullif(quote_unit, $1), $2) where base_unit = $1 and type = $3 or quote_unit = $1 and type = $3", req.GetSymbol(), req.Asset.GetSymbol(), asset.GetType())
 			_, _ = e.Context.Db.Exec("update reserves set symbol = $2 where symbol = $1", req.GetSymbol(), req.Asset.GetSymbol())
 			_, _ = e.Context.Db.Exec("update assets set symbol = $2 where symbol = $1", req.GetSymbol(), req.Asset.GetSymbol())
+
+			// The resting orders of the renamed asset were changed in the database, the in-memory books are loaded again.
+			_provider.ResetBook(req.GetSymbol(), asset.GetType())
 		}
 
 	} else {
@@ -163,7 +172,7 @@ func (e *Service) SetAsset(ctx context.Context, req *admin_pbmarket.SetRequestAs
 		// This code is inserting new information into a table called assets. The information being inserted is coming from
 		// the req.Asset object. The information is being inserted into a specific order, corresponding to the columns of
 		// the table. The purpose is to store the information about a currency in the currencies table.
-		if _, err := e.Context.Db.Exec(`insert into assets (name, symbol, min_withdraw, max_withdraw, min_trade, max_trade, fees_trade, fees_discount, marker, "group", status, type, chains) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		if _, err := e.Context.Db.Exec(`insert into assets (name, symbol, min_withdraw, max_withdraw, min_trade, max_trade, fees_trade, fees_discount, marker, "group", status, type, chains, margin, borrow_rate, borrow_slope, borrow_jump, borrow_kink, reserve_rate) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
 			req.Asset.GetName(),
 			req.Asset.GetSymbol(),
 			req.Asset.GetMinWithdraw(),
@@ -177,6 +186,12 @@ func (e *Service) SetAsset(ctx context.Context, req *admin_pbmarket.SetRequestAs
 			req.Asset.GetStatus(),
 			req.Asset.GetType(),
 			serialize,
+			req.Asset.GetMargin(),
+			req.Asset.GetBorrowRate(),
+			req.Asset.GetBorrowSlope(),
+			req.Asset.GetBorrowJump(),
+			req.Asset.GetBorrowKink(),
+			req.Asset.GetReserveRate(),
 		); err != nil {
 			return &response, err
 		}
@@ -329,7 +344,7 @@ func (e *Service) GetAssets(ctx context.Context, req *admin_pbmarket.GetRequestA
 
 		// This code is used to query the database. It builds a query with the given parameters (maps, req.GetLimit(), offset).
 		// It then attempts to execute it and, if an error is encountered, the function returns the error. Finally, it closes the rows.
-		rows, err := e.Context.Db.Query(fmt.Sprintf(`select id, name, symbol, min_withdraw, max_withdraw, min_trade, max_trade, fees_trade, fees_discount, fees_charges, fees_costs, marker, status, "group", type, create_at from assets %s order by id desc limit %d offset %d`, strings.Join(maps, " "), req.GetLimit(), offset))
+		rows, err := e.Context.Db.Query(fmt.Sprintf(`select id, name, symbol, min_withdraw, max_withdraw, min_trade, max_trade, fees_trade, fees_discount, fees_charges, fees_costs, marker, margin, borrow_rate, borrow_slope, borrow_jump, borrow_kink, reserve_rate, status, "group", type, create_at from assets %s order by id desc limit %d offset %d`, strings.Join(maps, " "), req.GetLimit(), offset))
 		if err != nil {
 			return &response, err
 		}
@@ -360,6 +375,12 @@ func (e *Service) GetAssets(ctx context.Context, req *admin_pbmarket.GetRequestA
 				&item.FeesCharges,
 				&item.FeesCosts,
 				&item.Marker,
+				&item.Margin,
+				&item.BorrowRate,
+				&item.BorrowSlope,
+				&item.BorrowJump,
+				&item.BorrowKink,
+				&item.ReserveRate,
 				&item.Status,
 				&item.Group,
 				&item.Type,
@@ -428,6 +449,7 @@ func (e *Service) DeleteAsset(ctx context.Context, req *admin_pbmarket.DeleteReq
 		_, _ = e.Context.Db.Exec("delete from orders where base_unit = $1 and type = $2 or quote_unit = $1 and type = $2", row.GetSymbol(), row.GetType())
 		_, _ = e.Context.Db.Exec("delete from reserves where symbol = $1", row.GetSymbol())
 		_, _ = e.Context.Db.Exec("delete from assets where symbol = $1", row.GetSymbol())
+		_provider.ResetBook(row.GetSymbol(), row.GetType())
 	}
 
 	// This code is used to remove a symbol from the "icon" database. It is checking for any errors that may occur while
@@ -636,6 +658,24 @@ func (e *Service) SetPair(ctx context.Context, req *admin_pbmarket.SetRequestPai
 		return &response, status.Error(46517, "the price must be set")
 	}
 
+	// The trading rules of the pair are optional, a zero disables a rule. The tick and step sizes default to the decimals of
+	// the pair, and a maximum notional must not be below the minimum one.
+	if req.Pair.GetTickSize() < 0 || req.Pair.GetStepSize() < 0 || req.Pair.GetMinNotional() < 0 || req.Pair.GetMaxNotional() < 0 || (req.Pair.GetMaxNotional() > 0 && req.Pair.GetMaxNotional() < req.Pair.GetMinNotional()) {
+		return &response, status.Error(46518, "the tick size, step size and notional limits must be positive and the maximum notional must not be below the minimum notional")
+	}
+
+	// The price band and the circuit breaker are optional as well, a zero disables them. The band and the halt change are
+	// percentages, the halt window and cool-down are seconds.
+	if req.Pair.GetPriceBand() < 0 || req.Pair.GetPriceBand() >= 100 || req.Pair.GetHaltChange() < 0 || req.Pair.GetHaltWindow() < 0 || req.Pair.GetHaltCooldown() < 0 {
+		return &response, status.Error(46520, "the price band must be between 0 and 100 percent and the halt change, window and cool-down must not be negative")
+	}
+
+	// The auction is the duration in seconds of the call auction that opens a new pair and reopens a halted one, zero
+	// starts the continuous trading right away.
+	if req.Pair.GetAuction() < 0 {
+		return &response, status.Error(46521, "the auction duration must not be negative")
+	}
+
 	// This is a conditional statement that checks if the value of req.GetId() is greater than 0. If the condition is true,
 	// then the code inside the curly braces will be executed. Otherwise, the code will be skipped. This conditional
 	// statement is usually used to determine if a certain condition is met before executing certain code.
@@ -652,7 +692,7 @@ func (e *Service) SetPair(ctx context.Context, req *admin_pbmarket.SetRequestPai
 		// the 'base_unit', 'quote_unit', 'price', 'base_decimal', 'quote_decimal' and 'status' fields of the database table,
 		// where the value of the 'id' field of the database table is equal to the value of the 'Id' field in the 'req' struct.
 		// The code also includes an if statement to check for any errors in the process.
-		if _, err := e.Context.Db.Exec("update pairs set base_unit = $1, quote_unit = $2, price = $3, base_decimal = $4, quote_decimal = $5, type = $6, status = $7 where id = $8;",
+		if _, err := e.Context.Db.Exec("update pairs set base_unit = $1, quote_unit = $2, price = $3, base_decimal = $4, quote_decimal = $5, type = $6, status = $7, tick_size = $9, step_size = $10, min_notional = $11, max_notional = $12, price_band = $13, halt_change = $14, halt_window = $15, halt_cooldown = $16, auction = $17 where id = $8;",
 			req.Pair.GetBaseUnit(),
 			req.Pair.GetQuoteUnit(),
 			req.Pair.GetPrice(),
@@ -661,6 +701,15 @@ func (e *Service) SetPair(ctx context.Context, req *admin_pbmarket.SetRequestPai
 			req.Pair.GetType(),
 			req.Pair.GetStatus(),
 			req.GetId(),
+			req.Pair.GetTickSize(),
+			req.Pair.GetStepSize(),
+			req.Pair.GetMinNotional(),
+			req.Pair.GetMaxNotional(),
+			req.Pair.GetPriceBand(),
+			req.Pair.GetHaltChange(),
+			req.Pair.GetHaltWindow(),
+			req.Pair.GetHaltCooldown(),
+			req.Pair.GetAuction(),
 		); err != nil {
 			return &response, err
 		}
@@ -676,8 +725,9 @@ func (e *Service) SetPair(ctx context.Context, req *admin_pbmarket.SetRequestPai
 		// This code is used to insert a record into a database table called 'pairs' using the values from a request object. It
 		// is using the 'Exec' function from the database context to execute an SQL statement for inserting the values into the
 		// table. The 'if _, err' statement is checking for any errors that may have occurred from the execution of the
-		// statement. If an error is detected, the code will return an error response.
-		if _, err := e.Context.Db.Exec("insert into pairs (base_unit, quote_unit, price, base_decimal, quote_decimal, type, status) values ($1, $2, $3, $4, $5, $6, $7)",
+		// statement. If an error is detected, the code will return an error response. A pair with an auction duration opens
+		// with a call auction.
+		if _, err := e.Context.Db.Exec("insert into pairs (base_unit, quote_unit, price, base_decimal, quote_decimal, type, status, tick_size, step_size, min_notional, max_notional, price_band, halt_change, halt_window, halt_cooldown, auction, auction_end) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, case when $16 > 0 then now() + make_interval(secs => $16) end)",
 			req.Pair.GetBaseUnit(),
 			req.Pair.GetQuoteUnit(),
 			req.Pair.GetPrice(),
@@ -685,6 +735,15 @@ func (e *Service) SetPair(ctx context.Context, req *admin_pbmarket.SetRequestPai
 			req.Pair.GetQuoteDecimal(),
 			req.Pair.GetType(),
 			req.Pair.GetStatus(),
+			req.Pair.GetTickSize(),
+			req.Pair.GetStepSize(),
+			req.Pair.GetMinNotional(),
+			req.Pair.GetMaxNotional(),
+			req.Pair.GetPriceBand(),
+			req.Pair.GetHaltChange(),
+			req.Pair.GetHaltWindow(),
+			req.Pair.GetHaltCooldown(),
+			req.Pair.GetAuction(),
 		); err != nil {
 			return &response, err
 		}
@@ -735,8 +794,372 @@ func (e *Service) DeletePair(ctx context.Context, req *admin_pbmarket.DeleteRequ
 		_, _ = e.Context.Db.Exec("delete from ohlcv where base_unit = $1 and quote_unit = $2", row.GetBaseUnit(), row.GetQuoteUnit())
 		_, _ = e.Context.Db.Exec("delete from trades where base_unit = $1 and quote_unit = $2", row.GetBaseUnit(), row.GetQuoteUnit())
 		_, _ = e.Context.Db.Exec("delete from orders where base_unit = $1 and quote_unit = $2 and type = $3", row.GetBaseUnit(), row.GetQuoteUnit(), row.GetType())
+		_, _ = e.Context.Db.Exec("delete from fees where base_unit = $1 and quote_unit = $2", row.GetBaseUnit(), row.GetQuoteUnit())
+		_provider.ResetBook(row.GetBaseUnit(), row.GetType())
+	}
+	response.Success = true
+
+	return &response, nil
+}
+
+// SetHalt - This function halts or resumes the trading of a pair by hand. A halt with a cool-down in seconds is resumed
+// automatically when the cool-down has passed, a halt without one lasts until the pair is resumed with this function.
+func (e *Service) SetHalt(ctx context.Context, req *admin_pbmarket.SetRequestHalt) (*admin_pbmarket.ResponsePair, error) {
+
+	var (
+		response admin_pbmarket.ResponsePair
+		migrate  = query.Migrate{
+			Context: e.Context,
+		}
+	)
+
+	// This code is part of an authentication process. The purpose of this code is to attempt to authenticate the user and
+	// retrieve the authentication data. If there is an error, it is returned to the caller.
+	auth, err := e.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking if the user has the appropriate permissions to write and edit data. If the user does not have
+	// the rules for writing and editing data, then the code will return an error message.
+	if !migrate.Rules(auth, "pairs", query.RoleMarket) || migrate.Rules(auth, "deny-record", query.RoleDefault) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	// The cool-down is given in seconds, zero keeps the pair halted until it is resumed.
+	if req.GetCooldown() < 0 {
+		return &response, status.Error(46520, "the halt cool-down must not be negative")
+	}
+
+	// Provider is used to create a Service instance with the given context.
+	_provider := provider.Service{
+		Context: e.Context,
+	}
+
+	row, err := _provider.QueryPair(req.GetId(), types.TypeZero, false)
+	if err != nil {
+		return &response, status.Error(11585, "the pair does not exist")
+	}
+
+	// The change of the state is published by the provider, so the subscribers learn about a manual halt in the same way as
+	// about one of the circuit breaker.
+	if err := _provider.WriteHalt(row.GetBaseUnit(), row.GetQuoteUnit(), row.GetType(), req.GetHalted(), req.GetCooldown()); err != nil {
+		return &response, err
+	}
+	response.Success = true
+
+	return &response, nil
+}
+
+// GetFees - This function returns the tiers of the fee schedule, page by page. The global tiers are returned when no pair is given
+// in the request, otherwise the tiers that override them for the pair. The user has to have the rules for the pairs.
+func (e *Service) GetFees(ctx context.Context, req *admin_pbmarket.GetRequestFees) (*admin_pbmarket.ResponseFee, error) {
+
+	var (
+		response admin_pbmarket.ResponseFee
+		migrate  = query.Migrate{
+			Context: e.Context,
+		}
+	)
+
+	// The purpose of this code is to check if the value of the variable req.GetLimit() is equal to 0, and if it is, set the
+	// value of the variable req.Limit to 30.
+	if req.GetLimit() == 0 {
+		req.Limit = 30
+	}
+
+	// This code is part of an authentication process. The purpose of this code is to attempt to authenticate the user and
+	// retrieve the authentication data. If there is an error, it is returned to the caller.
+	auth, err := e.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking if a user has permissions to perform a certain action. The migrate.Rules() function is used to
+	// check if the user has the necessary authorization to write and edit data, and if they do not, an error is returned
+	// indicating that they do not have the necessary permissions.
+	if !migrate.Rules(auth, "pairs", query.RoleMarket) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	// The tiers are counted first, the rows are only read when there is something to return.
+	if _ = e.Context.Db.QueryRow(`select count(*) as count from fees where base_unit = $1 and quote_unit = $2`, req.GetBaseUnit(), req.GetQuoteUnit()).Scan(&response.Count); response.GetCount() > 0 {
+
+		// The offset of the page is calculated from the limit and the page number, pages start with one.
+		offset := req.GetLimit() * req.GetPage()
+		if req.GetPage() > 0 {
+			offset = req.GetLimit() * (req.GetPage() - 1)
+		}
+
+		rows, err := e.Context.Db.Query(`select id, level, volume, maker, taker, base_unit, quote_unit, create_at from fees where base_unit = $1 and quote_unit = $2 order by level limit $3 offset $4`, req.GetBaseUnit(), req.GetQuoteUnit(), req.GetLimit(), offset)
+		if err != nil {
+			return &response, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+
+			var (
+				item types.Fee
+			)
+
+			if err = rows.Scan(&item.Id, &item.Level, &item.Volume, &item.Maker, &item.Taker, &item.BaseUnit, &item.QuoteUnit, &item.CreateAt); err != nil {
+				return &response, err
+			}
+
+			response.Fields = append(response.Fields, &item)
+		}
+
+		// The purpose of this code is to check for any errors that occurred while operating on the rows, and to return an
+		// error response if there is an issue.
+		if err = rows.Err(); err != nil {
+			return &response, err
+		}
+	}
+
+	return &response, nil
+}
+
+// SetFee - This function creates a tier of the fee schedule or updates the tier with the id given in the request. A tier without a
+// base and quote unit is global, a tier with both units belongs to that pair and the tiers of a pair replace the global
+// tiers for it. The maker and taker rates are percentages of the traded value, like the fees of the assets.
+func (e *Service) SetFee(ctx context.Context, req *admin_pbmarket.SetRequestFee) (*admin_pbmarket.ResponseFee, error) {
+
+	var (
+		response admin_pbmarket.ResponseFee
+		migrate  = query.Migrate{
+			Context: e.Context,
+		}
+		q query.Query
+	)
+
+	// This code is part of an authentication process. The purpose of this code is to attempt to authenticate the user and
+	// retrieve the authentication data. If there is an error, it is returned to the caller.
+	auth, err := e.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking if the user has the appropriate permissions to write and edit data. If the user does not have
+	// the rules for writing and editing data, then the code will return an error message.
+	if !migrate.Rules(auth, "pairs", query.RoleMarket) || migrate.Rules(auth, "deny-record", query.RoleDefault) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	// A tier is either global or belongs to a pair, so the base and quote units are set together or not at all.
+	if (len(req.Fee.GetBaseUnit()) == 0) != (len(req.Fee.GetQuoteUnit()) == 0) {
+		return &response, status.Error(55615, "base currency and quote currency must be set")
+	}
+
+	// The pair of a tier has to exist.
+	if len(req.Fee.GetBaseUnit()) > 0 {
+		if _ = e.Context.Db.QueryRow("select id from pairs where base_unit = $1 and quote_unit = $2", req.Fee.GetBaseUnit(), req.Fee.GetQuoteUnit()).Scan(&q.Id); q.Id == 0 {
+			return &response, status.Errorf(11585, "this pair %v-%v does not exist", req.Fee.GetBaseUnit(), req.Fee.GetQuoteUnit())
+		}
+	}
+
+	// The level and the volume threshold can not be negative, and the rates are percentages below one hundred.
+	if req.Fee.GetLevel() < 0 || req.Fee.GetVolume() < 0 || req.Fee.GetMaker() < 0 || req.Fee.GetMaker() >= 100 || req.Fee.GetTaker() < 0 || req.Fee.GetTaker() >= 100 {
+		return &response, status.Error(46519, "the level and the volume must not be negative and the maker and taker fees must be between 0 and 100 percent")
+	}
+
+	// Every level exists once per pair and once in the global schedule.
+	if _ = e.Context.Db.QueryRow("select id from fees where level = $1 and base_unit = $2 and quote_unit = $3 and id <> $4", req.Fee.GetLevel(), req.Fee.GetBaseUnit(), req.Fee.GetQuoteUnit(), req.GetId()).Scan(&q.Id); q.Id > 0 {
+		return &response, status.Errorf(50606, "the fee level %v already exists", req.Fee.GetLevel())
+	}
+
+	if req.GetId() > 0 {
+
+		if _, err := e.Context.Db.Exec("update fees set level = $1, volume = $2, maker = $3, taker = $4, base_unit = $5, quote_unit = $6 where id = $7;",
+			req.Fee.GetLevel(),
+			req.Fee.GetVolume(),
+			req.Fee.GetMaker(),
+			req.Fee.GetTaker(),
+			req.Fee.GetBaseUnit(),
+			req.Fee.GetQuoteUnit(),
+			req.GetId(),
+		); err != nil {
+			return &response, err
+		}
+
+	} else {
+
+		if _, err := e.Context.Db.Exec("insert into fees (level, volume, maker, taker, base_unit, quote_unit) values ($1, $2, $3, $4, $5, $6)",
+			req.Fee.GetLevel(),
+			req.Fee.GetVolume(),
+			req.Fee.GetMaker(),
+			req.Fee.GetTaker(),
+			req.Fee.GetBaseUnit(),
+			req.Fee.GetQuoteUnit(),
+		); err != nil {
+			return &response, err
+		}
+
 	}
+
+	// The cached fee tiers of the users follow the changed schedule right away.
+	_provider := provider.Service{
+		Context: e.Context,
+	}
+	_provider.ResetTiers()
+
 	response.Success = true
 
 	return &response, nil
 }
+
+// DeleteFee - This function deletes a tier of the fee schedule. When the last tier of a pair is deleted, the global tiers apply
+// to the pair again, and when no tier is left at all the fees of the assets are charged.
+func (e *Service) DeleteFee(ctx context.Context, req *admin_pbmarket.DeleteRequestFee) (*admin_pbmarket.ResponseFee, error) {
+
+	var (
+		response admin_pbmarket.ResponseFee
+		migrate  = query.Migrate{
+			Context: e.Context,
+		}
+	)
+
+	// This code is part of an authentication process. The purpose of this code is to attempt to authenticate the user and
+	// retrieve the authentication data. If there is an error, it is returned to the caller.
+	auth, err := e.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking to see if the user has the necessary authorization to perform a write or edit operation on the
+	// data. If the user does not have the correct authorization to do so, an error is returned with a status code of 12011.
+	if !migrate.Rules(auth, "pairs", query.RoleMarket) || migrate.Rules(auth, "deny-record", query.RoleDefault) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	if _, err := e.Context.Db.Exec("delete from fees where id = $1", req.GetId()); err != nil {
+		return &response, err
+	}
+
+	// The cached fee tiers of the users follow the changed schedule right away.
+	_provider := provider.Service{
+		Context: e.Context,
+	}
+	_provider.ResetTiers()
+
+	response.Success = true
+
+	return &response, nil
+}
+
+// GetJournal - This function returns the events of the matching engine from the journal, page by page and the latest events first.
+// The events can be narrowed to a pair and to a single order, which is what an investigation of a balance usually
+// starts with. The user has to have the rules for the pairs.
+func (e *Service) GetJournal(ctx context.Context, req *admin_pbmarket.GetRequestJournal) (*admin_pbmarket.ResponseJournal, error) {
+
+	var (
+		response admin_pbmarket.ResponseJournal
+		migrate  = query.Migrate{
+			Context: e.Context,
+		}
+	)
+
+	// The purpose of this code is to check if the value of the variable req.GetLimit() is equal to 0, and if it is, set the
+	// value of the variable req.Limit to 30.
+	if req.GetLimit() == 0 {
+		req.Limit = 30
+	}
+
+	// This code is part of an authentication process. The purpose of this code is to attempt to authenticate the user and
+	// retrieve the authentication data. If there is an error, it is returned to the caller.
+	auth, err := e.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking if a user has permissions to perform a certain action. The migrate.Rules() function is used to
+	// check if the user has the necessary authorization to write and edit data, and if they do not, an error is returned
+	// indicating that they do not have the necessary permissions.
+	if !migrate.Rules(auth, "pairs", query.RoleMarket) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	// An empty unit, type or a zero order id does not narrow the events.
+	where := `($1 = '' or base_unit = $1) and ($2 = '' or quote_unit = $2) and ($3 = '' or type = $3) and ($4 = 0 or order_id = $4 or maker_id = $4)`
+
+	// The events are counted first, the rows are only read when there is something to return.
+	if _ = e.Context.Db.QueryRow(fmt.Sprintf(`select count(*) as count from journal where %v`, where), req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType(), req.GetOrderId()).Scan(&response.Count); response.GetCount() > 0 {
+
+		// The offset of the page is calculated from the limit and the page number, pages start with one.
+		offset := req.GetLimit() * req.GetPage()
+		if req.GetPage() > 0 {
+			offset = req.GetLimit() * (req.GetPage() - 1)
+		}
+
+		rows, err := e.Context.Db.Query(fmt.Sprintf(`select id, sequence, event, order_id, maker_id, user_id, assigning, base_unit, quote_unit, type, price, quantity, create_at from journal where %v order by id desc limit $5 offset $6`, where), req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType(), req.GetOrderId(), req.GetLimit(), offset)
+		if err != nil {
+			return &response, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+
+			var (
+				item types.Journal
+			)
+
+			if err = rows.Scan(&item.Id, &item.Sequence, &item.Event, &item.OrderId, &item.MakerId, &item.UserId, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.Type, &item.Price, &item.Quantity, &item.CreateAt); err != nil {
+				return &response, err
+			}
+
+			response.Fields = append(response.Fields, &item)
+		}
+
+		// The purpose of this code is to check for any errors that occurred while operating on the rows, and to return an
+		// error response if there is an issue.
+		if err = rows.Err(); err != nil {
+			return &response, err
+		}
+	}
+
+	return &response, nil
+}
+
+// GetReplay - This function rebuilds the orders of a pair and the balances changed by its events from the journal, and returns the
+// differences to the current tables. An empty list means that the state of the pair is explained completely by the
+// journal. The user has to have the rules for the pairs.
+func (e *Service) GetReplay(ctx context.Context, req *admin_pbmarket.GetRequestReplay) (*admin_pbmarket.ResponseReplay, error) {
+
+	var (
+		response admin_pbmarket.ResponseReplay
+		migrate  = query.Migrate{
+			Context: e.Context,
+		}
+	)
+
+	// This code is part of an authentication process. The purpose of this code is to attempt to authenticate the user and
+	// retrieve the authentication data. If there is an error, it is returned to the caller.
+	auth, err := e.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// This code is checking if a user has permissions to perform a certain action. The migrate.Rules() function is used to
+	// check if the user has the necessary authorization to write and edit data, and if they do not, an error is returned
+	// indicating that they do not have the necessary permissions.
+	if !migrate.Rules(auth, "pairs", query.RoleMarket) {
+		return &response, status.Error(12011, "you do not have rules for writing and editing data")
+	}
+
+	if len(req.GetBaseUnit()) == 0 || len(req.GetQuoteUnit()) == 0 || len(req.GetType()) == 0 {
+		return &response, status.Error(46522, "the base unit, the quote unit and the type of the pair are required")
+	}
+
+	// Provider is used to create a Service instance with the given context.
+	_provider := provider.Service{
+		Context: e.Context,
+	}
+
+	response.Fields, response.Events, err = _provider.Replay(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType())
+	if err != nil {
+		return &response, err
+	}
+	response.Count = int32(len(response.Fields))
+
+	return &response, nil
+}
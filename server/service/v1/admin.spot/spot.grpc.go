@@ -711,8 +711,10 @@ func (e *Service) GetTransactions(ctx context.Context, req *admin_pbspot.GetRequ
 		// code inside the loop is executed. If there are no more rows, then the loop ends.
 		for rows.Next() {
 
+			// The amounts are scanned as exact decimals, the item carries them both as doubles and as decimal strings.
 			var (
-				item types.Transaction
+				item               types.Transaction
+				value, price, fees decimal.Float
 			)
 
 			// The purpose of this code is to scan the rows of the database for fields related to a particular item. This code is
@@ -723,9 +725,9 @@ func (e *Service) GetTransactions(ctx context.Context, req *admin_pbspot.GetRequ
 				&item.Id,
 				&item.Symbol,
 				&item.Hash,
-				&item.Value,
-				&item.Price,
-				&item.Fees,
+				&value,
+				&price,
+				&fees,
 				&item.ChainId,
 				&item.Confirmation,
 				&item.To,
@@ -752,9 +754,14 @@ func (e *Service) GetTransactions(ctx context.Context, req *admin_pbspot.GetRequ
 			// The purpose of this code is to adjust the fees of an item based on the protocol it is using. If the item is not
 			// using the mainnet protocol, the fees will be multiplied by the price of the item.
 			if item.GetProtocol() != types.ProtocolMainnet {
-				item.Fees = decimal.New(item.GetFees()).Mul(item.GetPrice()).Float()
+				fees = *fees.Mul(&price)
 			}
 
+			// The amounts are sent both as doubles and as exact decimal strings.
+			item.Value, item.ValueDecimal = value.Float(), value.String()
+			item.Price, item.PriceDecimal = price.Float(), price.String()
+			item.Fees, item.FeesDecimal = fees.Float(), fees.String()
+
 			// This statement is appending the item to the response.Fields slice. The purpose of this statement is to add the item
 			// to the existing list of items stored in the response.Fields slice.
 			response.Fields = append(response.Fields, &item)
@@ -951,7 +958,12 @@ func (e *Service) GetBalances(ctx context.Context, req *admin_pbspot.GetRequestB
 		return &response, status.Error(12011, "you do not have rules for writing and editing data")
 	}
 
-	if _ = e.Context.Db.QueryRow("select count(*) as count from balances where user_id = $1 and type = $2", req.GetId(), types.TypeSpot).Scan(&response.Count); response.GetCount() > 0 {
+	// The balances of a type other than spot are the margin wallets and the lending supply of the user.
+	if len(req.GetType()) == 0 {
+		req.Type = types.TypeSpot
+	}
+
+	if _ = e.Context.Db.QueryRow("select count(*) as count from balances where user_id = $1 and type = $2", req.GetId(), req.GetType()).Scan(&response.Count); response.GetCount() > 0 {
 
 		// This code is setting an offset for a Paginated request. The offset is used to determine the index of the first item
 		// that should be returned. This code is calculating the offset by multiplying the limit (the number of items per page)
@@ -961,7 +973,7 @@ func (e *Service) GetBalances(ctx context.Context, req *admin_pbspot.GetRequestB
 			offset = req.GetLimit() * (req.GetPage() - 1)
 		}
 
-		rows, err := e.Context.Db.Query("select id, value, symbol from balances where type = $1 and user_id = $2 order by id desc limit $3 offset $4", types.TypeSpot, req.GetId(), req.GetLimit(), offset)
+		rows, err := e.Context.Db.Query("select id, value, symbol, type from balances where type = $1 and user_id = $2 order by id desc limit $3 offset $4", req.GetType(), req.GetId(), req.GetLimit(), offset)
 		if err != nil {
 			return &response, err
 		}
@@ -981,7 +993,7 @@ func (e *Service) GetBalances(ctx context.Context, req *admin_pbspot.GetRequestB
 			// This code is part of a larger program, and its purpose is to scan the rows in a database for a particular asset and
 			// assign the corresponding id, value, and symbol to the asset variable. If an error occurs at any point during the
 			// rows.Scan, the code returns an error response and passes the error to the context.
-			if err := rows.Scan(&asset.Id, &asset.Value, &asset.Symbol); err != nil {
+			if err := rows.Scan(&asset.Id, &asset.Value, &asset.Symbol, &asset.Type); err != nil {
 				return &response, err
 			}
 
@@ -1155,12 +1167,13 @@ func (e *Service) SetRepayments(ctx context.Context, req *admin_pbspot.SetReques
 		// The line of code above declares a variable, item, of type types.Transaction. This is a type of data structure that
 		// is used to store information about a transaction made using the types service, such as the amount, date, and other details.
 		var (
-			item types.Transaction
+			item          types.Transaction
+			fees, charges decimal.Float
 		)
 
 		// The purpose of this if statement is to scan each row of the database table and store the values from the row into
 		// the item.Fees and item.ChainId variables. If the scan is unsuccessful, the error is returned in the response.
-		if err := row.Scan(&item.Id, &item.Fees, &item.ChainId); err != nil {
+		if err := row.Scan(&item.Id, &fees, &item.ChainId); err != nil {
 			return &response, err
 		}
 
@@ -1177,14 +1190,15 @@ func (e *Service) SetRepayments(ctx context.Context, req *admin_pbspot.SetReques
 		}
 
 		// This code checks if the exchange fees are sufficient to cover the deficit. If not, it returns an error.
-		if _ = e.Context.Db.QueryRow("select fees_charges from assets where symbol = $1", chain.GetParentSymbol()).Scan(&item.Value); item.GetFees() > item.GetValue() {
+		// The fees and the charges are compared as exact decimals.
+		if _ = e.Context.Db.QueryRow("select fees_charges from assets where symbol = $1", chain.GetParentSymbol()).Scan(&charges); fees.GreaterThan(charges.Decimal) {
 			return &response, status.Error(521233, "exchange fees are insufficient to cover the deficit")
 		}
 
 		// This code is updating the fees_charges and fees_costs of a currency in a database using the Exec() method. The code
 		// is using $1 and $2, which are placeholder variables for the first and second parameters given to the Exec() method.
 		// The first parameter is the symbol of the parent chain and the second parameter is the fees associated with the item. If an error occurs, the code will return an error response.
-		if _, err := e.Context.Db.Exec("update assets set fees_charges = fees_charges - $2, fees_costs = fees_costs + $2 where symbol = $1;", chain.GetParentSymbol(), item.GetFees()); err != nil {
+		if _, err := e.Context.Db.Exec("update assets set fees_charges = fees_charges - $2, fees_costs = fees_costs + $2 where symbol = $1;", chain.GetParentSymbol(), fees.String()); err != nil {
 			return &response, err
 		}
 
@@ -1,8 +1,11 @@
 package future
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 
 	"github.com/cryptogateway/backend-envoys/assets"
 	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
@@ -14,8 +17,39 @@ type Service struct {
 	Context *assets.Context
 }
 
-func (a *Service) Initialization() {
+// pairs - serializes the trading of every futures pair: the orders of a pair are validated, written and matched one at a time,
+// and a liquidation on the pair runs between two of them, never in the middle of a match.
+type pairs struct {
+	mutex sync.Mutex
+	items map[string]*sync.Mutex
+}
+
+var (
+	// books - the locks of the futures pairs, shared by every Service value of the package.
+	books = &pairs{
+		items: make(map[string]*sync.Mutex),
+	}
+)
+
+// lock - locks the pair and returns its lock, the caller unlocks it once the pair is consistent again.
+func (p *pairs) lock(base, quote string) *sync.Mutex {
+
+	p.mutex.Lock()
+	key := fmt.Sprintf("%v/%v", base, quote)
+	if p.items[key] == nil {
+		p.items[key] = new(sync.Mutex)
+	}
+	mutex := p.items[key]
+	p.mutex.Unlock()
 
+	mutex.Lock()
+
+	return mutex
+}
+
+func (a *Service) Initialization() {
+	go a.mark()
+	go a.liquidation()
 }
 
 func (a *Service) queryValidatePair(base, quote, _type string) error {
@@ -67,6 +101,15 @@ func (a *Service) queryValidateOrder(order *types.Future) (summary float64, err
 		return 0, status.Errorf(65790, "impossible price %v", order.GetPrice())
 	}
 
+	// The leverage is stored with every order, a close order is checked as well so that no order carries a leverage of zero.
+	if order.GetLeverage() <= 0 {
+		return 0, status.Errorf(11597, "impossible leverage %v", order.GetLeverage())
+	}
+
+	if order.GetLeverage() > leverageLimit {
+		return 0, status.Errorf(11598, "the leverage %v is larger than the maximum leverage %v", order.GetLeverage(), leverageLimit)
+	}
+
 	switch order.GetAssigning() {
 	case types.AssigningOpen:
 
@@ -76,45 +119,103 @@ func (a *Service) queryValidateOrder(order *types.Future) (summary float64, err
 			return 0, status.Errorf(11623, "[quote]: minimum trading amount: %v~%v, maximum trading amount: %v", min, strconv.FormatFloat(decimal.New(min).Mul(2).Float(), 'f', -1, 64), strconv.FormatFloat(max, 'f', -1, 64))
 		}
 
-		balance := a.QueryBalance(order.GetQuoteUnit(), "future", order.GetUserId())
+		balance := a.QueryBalance(order.GetQuoteUnit(), types.TypeFuture, order.GetUserId())
 
-		if decimal.New(quantity).Div(order.GetLeverage()).Float() > balance || order.GetQuantity() == 0 {
+		margin := decimal.New(quantity).Div(order.GetLeverage()).Float()
+		if margin > balance || order.GetQuantity() == 0 {
 			return 0, status.Error(11586, "[quote]: there is not enough funds on your asset balance to place an order")
 		}
 
-		return quantity, nil
+		return margin, nil
 
 	case types.AssigningClose:
 
-		quantity := order.GetQuantity()
-
-		_, openQuantity := a.queryOrders(order.UserId, types.AssigningOpen, order.GetPosition(), order.GetBaseUnit(), order.GetQuoteUnit())
-
-		fmt.Println("TOTAL OPEN QUANTITY IS ", openQuantity)
 		if min, max, ok := a.queryRange(order.GetBaseUnit(), order.GetQuantity()); !ok {
 			return 0, status.Errorf(11587, "[base]: minimum trading amount: %v~%v, maximum trading amount: %v", min, strconv.FormatFloat(decimal.New(min).Mul(2).Float(), 'f', -1, 64), strconv.FormatFloat(max, 'f', -1, 64))
 		}
 
-		// balance := a.QueryBalance(order.GetBaseUnit(), "future", order.GetUserId())
+		position, err := a.queryPosition(order)
+		if err != nil {
+			return 0, err
+		}
+
+		// The size that the pending close orders of the position already close can not be closed again.
+		var pending float64
+		_ = a.Context.Db.QueryRow("select coalesce(sum(value), 0) from futures where user_id = $1 and base_unit = $2 and quote_unit = $3 and position = $4 and assigning = $5 and status = $6", order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetPosition(), types.AssigningClose, types.StatusPending).Scan(&pending)
 
-		if quantity > openQuantity || order.GetQuantity() == 0 {
-			return 0, status.Error(11624, "[base]: there is not enough funds locking in your orders")
+		if order.GetQuantity() > decimal.New(position.GetSize()).Sub(pending).Float() || order.GetQuantity() == 0 {
+			return 0, status.Error(11624, "[base]: the quantity is larger than the open size of your position")
 		}
 
-		return quantity, nil
+		return order.GetQuantity(), nil
 	}
 
 	return 0, status.Error(11596, "invalid input parameter")
 }
 
-func (a *Service) writeOrder(order *types.Future) (id int64, err error) {
+// writeOrder - writes the order with the margin that it reserves inside the transaction, the fills release exactly that margin
+// and a cancelled order refunds what is left of it.
+func (a *Service) writeOrder(tx *sql.Tx, order *types.Future, margin *decimal.Float) (id int64, err error) {
 
-	if err := a.Context.Db.QueryRow("insert into futures (position, trading, base_unit, quote_unit, price, quantity, leverage, take_profit, stop_loss, fees, status, user_id, assigning, value) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) returning id", order.GetPosition(), order.GetOrderType(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetPrice(), order.GetQuantity(), order.GetLeverage(), order.GetTakeProfit(), order.GetStopLoss(), order.GetFees(), types.StatusPending, order.GetUserId(), order.GetAssigning(), order.GetValue()).Scan(&id); err != nil {
+	if err := tx.QueryRow("insert into futures (position, trading, base_unit, quote_unit, price, quantity, leverage, take_profit, stop_loss, fees, status, user_id, assigning, value, margin) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) returning id", order.GetPosition(), order.GetOrderType(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetPrice(), order.GetQuantity(), order.GetLeverage(), order.GetTakeProfit(), order.GetStopLoss(), order.GetFees(), types.StatusPending, order.GetUserId(), order.GetAssigning(), order.GetValue(), margin.String()).Scan(&id); err != nil {
 		return id, err
 	}
 
 	return id, nil
 }
+
+// placeOrder - validates the order, writes it together with the margin that an opening order reserves in one transaction and
+// matches it. The pair stays locked from the validation to the end of the match, so the position and the pending orders
+// that the validation reads do not change before the order is on the book.
+func (a *Service) placeOrder(order *types.Future) error {
+
+	mutex := books.lock(order.GetBaseUnit(), order.GetQuoteUnit())
+	defer mutex.Unlock()
+
+	if _, err := a.queryValidateOrder(order); err != nil {
+		return err
+	}
+
+	// The value is the open quantity of the order in the base unit, the fills decrease it down to zero.
+	order.Value = order.GetQuantity()
+
+	// An opening order reserves its margin, the margin moves to the position when the order is filled.
+	margin := decimal.New(0)
+	switch order.GetAssigning() {
+	case types.AssigningOpen:
+		margin = decimal.New(order.GetQuantity()).Mul(order.GetPrice()).Div(order.GetLeverage())
+		break
+	case types.AssigningClose:
+		break
+	default:
+		return status.Error(11588, "invalid assigning trade position")
+	}
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if order.Id, err = a.writeOrder(tx, order, margin); err != nil {
+		return err
+	}
+
+	if margin.IsPositive() {
+		if err := a.writeBalance(tx, order.GetQuoteUnit(), types.TypeFuture, order.GetUserId(), margin, types.BalanceMinus); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	a.match(order)
+
+	return nil
+}
+
 func (a *Service) writeAsset(symbol, _type string, userId int64, error bool) error {
 
 	row, err := a.Context.Db.Query(`select id from balances where symbol = $1 and user_id = $2 and type = $3`, symbol, userId, _type)
@@ -140,16 +241,48 @@ func (a *Service) writeAsset(symbol, _type string, userId int64, error bool) err
 }
 func (a *Service) WriteBalance(symbol, _type string, userId int64, quantity float64, cross string) error {
 
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := a.writeBalance(tx, symbol, _type, userId, decimal.New(quantity), cross); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeBalance - changes the balance of the user inside the transaction. The balance row is locked first, so two orders of the
+// same user can not both spend the same funds: the second one waits for the lock and sees the balance left by the first.
+func (a *Service) writeBalance(tx *sql.Tx, symbol, _type string, userId int64, quantity *decimal.Float, cross string) error {
+
+	var (
+		balance decimal.Float
+	)
+
+	if err := tx.QueryRow("select value from balances where symbol = $1 and user_id = $2 and type = $3 for update;", symbol, userId, _type).Scan(&balance); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return status.Errorf(11630, "the %v asset balance does not exist", symbol)
+		}
+		return err
+	}
+
 	switch cross {
 	case types.BalancePlus:
 
-		if _, err := a.Context.Db.Exec("update balances set value = value + $2 where symbol = $1 and user_id = $3 and type = $4;", symbol, quantity, userId, _type); err != nil {
+		if _, err := tx.Exec("update balances set value = value + $2 where symbol = $1 and user_id = $3 and type = $4;", symbol, quantity.String(), userId, _type); err != nil {
 			return err
 		}
 		break
 	case types.BalanceMinus:
 
-		if _, err := a.Context.Db.Exec("update balances set value = value - $2 where symbol = $1 and user_id = $3 and type = $4;", symbol, quantity, userId, _type); err != nil {
+		if quantity.GreaterThan(balance.Decimal) {
+			return status.Error(11586, "[quote]: there is not enough funds on your asset balance to place an order")
+		}
+
+		if _, err := tx.Exec("update balances set value = value - $2 where symbol = $1 and user_id = $3 and type = $4;", symbol, quantity.String(), userId, _type); err != nil {
 			return err
 		}
 		break
@@ -172,49 +305,35 @@ func (a *Service) queryRange(symbol string, value float64) (min, max float64, ok
 }
 func (a *Service) QueryBalance(symbol, _type string, userId int64) (balance float64) {
 
-	// _ = a.Context.Db.QueryRow("select value as balance from balances where symbol = $1 and user_id = $2 and type = $3", symbol, userId, _type).Scan(&balance)
-	_ = a.Context.Db.QueryRow("select value as balance from balances where symbol = $1 and user_id = $2", symbol, userId).Scan(&balance)
+	_ = a.Context.Db.QueryRow("select value as balance from balances where symbol = $1 and user_id = $2 and type = $3", symbol, userId, _type).Scan(&balance)
 
 	return balance
 }
 
-func (a *Service) writeTrade(id int64, symbol string, value, price float64, convert bool) (float64, error) {
-
-	order := a.queryOrder(id)
-	// order.Value = value
+// writeTrade - records the fill of the order as a trade and collects its fee inside the transaction of the fill, the fee is
+// charged on the notional value of the fill in the quote unit and returned to be paid from the position.
+func (a *Service) writeTrade(tx *sql.Tx, order *types.Future, quantity, price float64, maker bool) (float64, error) {
 
-	if convert {
-		value = decimal.New(value).Mul(price).Float()
-	}
-
-	s, f, maker, err := a.querySum(id, symbol, value)
+	fees, err := a.queryFees(order.GetQuoteUnit(), decimal.New(quantity).Mul(price).Float(), maker)
 	if err != nil {
 		return 0, err
 	}
 
-	if order.GetPosition() == types.PositionLong {
-		order.Fees = decimal.New(f).Div(price).Float()
-	} else {
-		order.Fees = f
-	}
-
-	if _, err := a.Context.Db.Exec(`insert into trades (order_id, assigning, user_id, base_unit, quote_unit, quantity, fees, price, maker) values ($1, $2, $3, $4, $5, $6, $7, $8)`, order.GetId(), order.GetAssigning(), order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetQuantity(), order.GetFees(), price, maker); err != nil {
+	// The trade is marked as a futures trade, the ids of the futures orders are not the ids of the orders table.
+	if _, err := tx.Exec(`insert into trades (order_id, assigning, user_id, base_unit, quote_unit, quantity, fees, price, maker, type) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, order.GetId(), a.queryDirection(order), order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), decimal.New(quantity).String(), decimal.New(fees).String(), price, maker, types.TypeFuture); err != nil {
 		return 0, err
 	}
 
-	if f > 0 {
+	if fees > 0 {
 
-		if _, err := a.Context.Db.Exec("update assets set fees_charges = fees_charges + $2 where symbol = $1;", symbol, f); err != nil {
+		if _, err := tx.Exec("update assets set fees_charges = fees_charges + $2 where symbol = $1;", order.GetQuoteUnit(), decimal.New(fees).String()); err != nil {
 			return 0, err
 		}
 	}
 
-	if err := a.Context.Publish(a.queryOrder(order.GetId()), "exchange", "future/status"); err != nil {
-		return 0, err
-	}
-
-	return s, nil
+	return fees, nil
 }
+
 func (a *Service) queryOrder(id int64) *types.Future {
 
 	var (
@@ -224,77 +343,3 @@ func (a *Service) queryOrder(id int64) *types.Future {
 	_ = a.Context.Db.QueryRow("select id, quantity, price, assigning, user_id, base_unit, quote_unit, status, create_at from futures where id = $1", id).Scan(&order.Id, &order.Quantity, &order.Price, &order.Assigning, &order.UserId, &order.BaseUnit, &order.QuoteUnit, &order.Status, &order.CreateAt)
 	return &order
 }
-func (a *Service) queryOrders(userId int64, assigning string, position string, baseUnit string, quoteUnit string) ([]*types.Future, float64) {
-	var (
-		orders        []*types.Future
-		totalQuantity float64 = 0.0
-	)
-	rows, err := a.Context.Db.Query("select id, quantity, price, assigning, user_id, base_unit, quote_unit, status, position, fees, create_at, leverage from futures where user_id = $1 and assigning = $2 and position = $3 and base_unit = $4 and quote_unit = $5", userId, assigning, position, baseUnit, quoteUnit)
-
-	if err != nil {
-		return nil, 0
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var (
-			order types.Future
-		)
-		if err := rows.Scan(&order.Id, &order.Quantity, &order.Price, &order.Assigning, &order.UserId, &order.BaseUnit, &order.QuoteUnit, &order.Status, &order.Position, &order.Fees, &order.CreateAt, &order.Leverage); err != nil {
-			fmt.Println("Error occured", err)
-			return nil, 0
-		}
-		totalQuantity += decimal.New(totalQuantity).Add(decimal.New(order.Quantity).Mul(order.Price).Div(order.Leverage).Float()).Float()
-		// totalQuantity = totalQuantity + order.Quantity*order.Price/order.Leverage
-		orders = append(orders, &order)
-	}
-
-	return orders, totalQuantity
-}
-
-func (a *Service) querySum(id int64, symbol string, value float64) (b, f float64, m bool, err error) {
-
-	var (
-		d float64
-		s string
-	)
-
-	if err := a.Context.Db.QueryRow("select fees_trade, fees_discount from assets where symbol = $1", symbol).Scan(&f, &d); err != nil {
-		return b, f, m, err
-	}
-
-	if err := a.Context.Db.QueryRow("select status from futures where id = $1;", id).Scan(&s); err != nil {
-		return b, f, m, err
-	}
-
-	if s == types.StatusPending {
-		m = true
-	}
-
-	if m {
-		f = decimal.New(f).Sub(d).Float()
-	}
-
-	return decimal.New(value).Sub(decimal.New(decimal.New(value).Mul(f).Float()).Div(100).Float()).Float(), decimal.New(value).Sub(decimal.New(value).Sub(decimal.New(decimal.New(value).Mul(f).Float()).Div(100).Float()).Float()).Float(), m, nil
-}
-
-func (a *Service) queryQuantity(assigning string, position string, quantity, price float64, cross bool) float64 {
-
-	if cross {
-
-		switch assigning {
-		case types.AssigningBuy:
-			quantity = decimal.New(quantity).Div(price).Float()
-		}
-
-		return quantity
-
-	} else {
-
-		switch assigning {
-		case types.AssigningSell:
-			quantity = decimal.New(quantity).Mul(price).Float()
-		}
-
-		return quantity
-	}
-}
@@ -6,7 +6,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
 	"github.com/cryptogateway/backend-envoys/assets/common/help"
 	"github.com/cryptogateway/backend-envoys/server/proto/v2/pbfuture"
 	"github.com/cryptogateway/backend-envoys/server/service/v2/account"
@@ -167,37 +166,9 @@ func (a *Service) SetOrder(ctx context.Context, req *pbfuture.SetRequestOrder) (
 	order.Status = types.StatusPending
 	order.CreateAt = time.Now().UTC().Format(time.RFC3339)
 
-	margin, err := a.queryValidateOrder(&order)
-	if err != nil {
-		return &response, err
-	}
-	order.Value = decimal.New(req.GetQuantity()).Mul(req.GetPrice()).Float()
-
-	if order.Id, err = a.writeOrder(&order); err != nil {
+	if err := a.placeOrder(&order); err != nil {
 		return &response, err
 	}
-	fmt.Println("order saved id ", order.Id)
-
-	switch order.GetAssigning() {
-	case types.AssigningOpen:
-
-		if err := a.WriteBalance(order.GetQuoteUnit(), order.GetAssigning(), order.GetUserId(), margin, types.BalanceMinus); err != nil {
-			return &response, err
-		}
-
-		break
-	case types.AssigningClose:
-
-		if err := a.WriteBalance(order.GetBaseUnit(), order.GetAssigning(), order.GetUserId(), margin, types.BalancePlus); err != nil {
-			return &response, err
-		}
-
-		a.closePosition(&order)
-
-		break
-	default:
-		return &response, status.Error(11588, "invalid assigning trade position")
-	}
 
 	response.Fields = append(response.Fields, &order)
 	return &response, nil
@@ -286,3 +257,119 @@ func (a *Service) GetTicker(_ context.Context, req *pbfuture.GetRequestTicker) (
 
 	return &response, nil
 }
+
+// GetPositions - returns the positions of the authenticated user valued at the mark price, optionally of a pair and a side. The
+// closed positions are only returned when asked for.
+func (a *Service) GetPositions(ctx context.Context, req *pbfuture.GetRequestPositions) (*pbfuture.ResponsePosition, error) {
+
+	var (
+		response pbfuture.ResponsePosition
+	)
+
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	if len(req.GetPosition()) > 0 {
+		if err := types.Position(req.GetPosition()); err != nil {
+			return &response, err
+		}
+	}
+
+	response.Fields, err = a.queryPositions(auth, req.GetBaseUnit(), req.GetQuoteUnit(), req.GetPosition(), req.GetClosed())
+	if err != nil {
+		return &response, err
+	}
+
+	return &response, nil
+}
+
+// GetLiquidations - returns the liquidations of the positions of the authenticated user, the latest first.
+func (a *Service) GetLiquidations(ctx context.Context, req *pbfuture.GetRequestLiquidations) (*pbfuture.ResponseLiquidation, error) {
+
+	var (
+		response pbfuture.ResponseLiquidation
+	)
+
+	if req.GetLimit() == 0 {
+		req.Limit = 30
+	}
+
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	_ = a.Context.Db.QueryRow("select count(*) as count from liquidations where user_id = $1", auth).Scan(&response.Count)
+
+	if response.GetCount() > 0 {
+
+		offset := req.GetLimit() * req.GetPage()
+		if req.GetPage() > 0 {
+			offset = req.GetLimit() * (req.GetPage() - 1)
+		}
+
+		rows, err := a.Context.Db.Query("select id, user_id, base_unit, quote_unit, position, size, entry_price, mark_price, bankruptcy_price, margin, closed, taken, create_at from liquidations where user_id = $1 order by id desc limit $2 offset $3", auth, req.GetLimit(), offset)
+		if err != nil {
+			return &response, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+
+			var (
+				item types.Liquidation
+			)
+
+			if err := rows.Scan(&item.Id, &item.UserId, &item.BaseUnit, &item.QuoteUnit, &item.Position, &item.Size, &item.EntryPrice, &item.MarkPrice, &item.BankruptcyPrice, &item.Margin, &item.Closed, &item.Taken, &item.CreateAt); err != nil {
+				return &response, err
+			}
+
+			response.Fields = append(response.Fields, &item)
+		}
+
+		if err = rows.Err(); err != nil {
+			return &response, err
+		}
+	}
+
+	return &response, nil
+}
+
+// GetMarks - returns the series of the index prices and the mark prices of the futures pair, the latest first.
+func (a *Service) GetMarks(_ context.Context, req *pbfuture.GetRequestMarks) (*pbfuture.ResponseMark, error) {
+
+	var (
+		response pbfuture.ResponseMark
+	)
+
+	if req.GetLimit() == 0 {
+		req.Limit = 60
+	}
+
+	rows, err := a.Context.Db.Query("select base_unit, quote_unit, index_price, mark_price, basis, sources, create_at from marks where base_unit = $1 and quote_unit = $2 order by create_at desc limit $3", req.GetBaseUnit(), req.GetQuoteUnit(), req.GetLimit())
+	if err != nil {
+		return &response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			item types.Mark
+		)
+
+		if err := rows.Scan(&item.BaseUnit, &item.QuoteUnit, &item.IndexPrice, &item.MarkPrice, &item.Basis, &item.Sources, &item.CreateAt); err != nil {
+			return &response, err
+		}
+
+		response.Fields = append(response.Fields, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return &response, err
+	}
+
+	return &response, nil
+}
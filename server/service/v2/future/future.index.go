@@ -0,0 +1,166 @@
+package future
+
+import (
+	"math"
+	"time"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/assets/common/marketplace"
+	"github.com/cryptogateway/backend-envoys/server/types"
+)
+
+var (
+	// smoothing - the number of marks that the basis of the mark price is averaged over, a mark is written every minute.
+	smoothing = 30.0
+
+	// basisLimit - the largest basis of the mark price as a share of the index price, a thin or manipulated futures book can not
+	// move the mark price further away from the spot markets.
+	basisLimit = 0.05
+)
+
+// mark - writes the index price and the mark price of every futures pair at a specific time interval.
+func (a *Service) mark() {
+
+	ticker := time.NewTicker(time.Minute * 1)
+	for range ticker.C {
+		a.Context.Debug(a.writeMarks())
+	}
+}
+
+// writeMarks - writes the index price and the mark price of every futures pair, a pair without any spot source keeps its last
+// mark.
+func (a *Service) writeMarks() error {
+
+	var (
+		pairs []*types.Pair
+	)
+
+	rows, err := a.Context.Db.Query("select base_unit, quote_unit from pairs where type = $1 and status = $2 order by id", types.TypeFuture, true)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			pair types.Pair
+		)
+
+		if err := rows.Scan(&pair.BaseUnit, &pair.QuoteUnit); err != nil {
+			return err
+		}
+
+		pairs = append(pairs, &pair)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	for _, pair := range pairs {
+
+		mark, err := a.queryIndex(pair.GetBaseUnit(), pair.GetQuoteUnit())
+		if err != nil {
+			return err
+		}
+
+		if mark.GetIndexPrice() == 0 {
+			continue
+		}
+
+		if err := a.writeMark(mark); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryIndex - returns the index price of the pair: the median of the prices of the pair on the exchanges of the marketplace and
+// of the average price of the spot trades of the last five minutes on our own market. The median keeps a single source
+// that reports a wrong price from moving the index.
+func (a *Service) queryIndex(base, quote string) (*types.Mark, error) {
+
+	var (
+		mark = types.Mark{
+			BaseUnit:  base,
+			QuoteUnit: quote,
+		}
+		spot float64
+	)
+
+	sources := marketplace.Price().Scale(base, quote)
+
+	// The trades of the margin orders are spot trades as well, they trade in the books of the spot pairs. The type of the
+	// trade tells them apart from the futures fills, whose order ids come from another sequence.
+	if err := a.Context.Db.QueryRow(`select coalesce(avg(price), 0) from trades where base_unit = $1 and quote_unit = $2 and type in ($3, $4, $5) and create_at > now() - interval '5 minutes'`, base, quote, types.TypeSpot, types.TypeCross, types.TypeIsolated).Scan(&spot); err != nil {
+		return &mark, err
+	}
+
+	if spot > 0 {
+		sources = append(sources, spot)
+	}
+
+	mark.IndexPrice, mark.Sources = marketplace.Median(sources), int32(len(sources))
+
+	return &mark, nil
+}
+
+// queryBasis - returns the smoothed basis of the futures pair against the index price. The premium is the distance of the
+// middle of the futures book from the index price, limited to the basis limit; a book without both sides has no premium.
+// The basis moves towards the premium as an exponential average of the last marks, so a single order can not jump the
+// mark price.
+func (a *Service) queryBasis(base, quote string, index float64) (float64, error) {
+
+	var (
+		previous, bid, ask, premium float64
+	)
+
+	_ = a.Context.Db.QueryRow("select basis from marks where base_unit = $1 and quote_unit = $2 order by create_at desc limit 1", base, quote).Scan(&previous)
+
+	// The buying orders open a long or close a short, the selling orders open a short or close a long.
+	if err := a.Context.Db.QueryRow("select coalesce(max(price) filter (where (assigning = $4 and position = $5) or (assigning = $6 and position = $7)), 0), coalesce(min(price) filter (where (assigning = $4 and position = $7) or (assigning = $6 and position = $5)), 0) from futures where base_unit = $1 and quote_unit = $2 and status = $3", base, quote, types.StatusPending, types.AssigningOpen, types.PositionLong, types.AssigningClose, types.PositionShort).Scan(&bid, &ask); err != nil {
+		return previous, err
+	}
+
+	if bid > 0 && ask > 0 {
+		limit := index * basisLimit
+		premium = math.Max(-limit, math.Min(limit, (bid+ask)/2-index))
+	}
+
+	return decimal.New(premium).Sub(previous).Mul(2).Div(smoothing + 1).Add(previous).Float(), nil
+}
+
+// writeMark - completes the mark of the pair with its basis and its mark price, writes it to the series of the marks and to the
+// price of the futures pair, and publishes it to the future/mark channel.
+func (a *Service) writeMark(mark *types.Mark) (err error) {
+
+	mark.Basis, err = a.queryBasis(mark.GetBaseUnit(), mark.GetQuoteUnit(), mark.GetIndexPrice())
+	if err != nil {
+		return err
+	}
+	mark.MarkPrice = decimal.New(mark.GetIndexPrice()).Add(mark.GetBasis()).Float()
+
+	if err := a.Context.Db.QueryRow("insert into marks (base_unit, quote_unit, index_price, mark_price, basis, sources) values ($1, $2, $3, $4, $5, $6) returning create_at", mark.GetBaseUnit(), mark.GetQuoteUnit(), mark.GetIndexPrice(), mark.GetMarkPrice(), mark.GetBasis(), mark.GetSources()).Scan(&mark.CreateAt); err != nil {
+		return err
+	}
+
+	if _, err := a.Context.Db.Exec("update pairs set price = $3 where base_unit = $1 and quote_unit = $2 and type = $4;", mark.GetBaseUnit(), mark.GetQuoteUnit(), mark.GetMarkPrice(), types.TypeFuture); err != nil {
+		return err
+	}
+
+	return a.Context.Publish(mark, "exchange", "future/mark")
+}
+
+// queryMark - returns the last mark price of the pair, the open positions are valued and liquidated at the mark price and never
+// at the price of the last trade. The boolean reports whether the pair has a mark price yet.
+func (a *Service) queryMark(base, quote string) (price float64, ok bool) {
+
+	if err := a.Context.Db.QueryRow("select mark_price from marks where base_unit = $1 and quote_unit = $2 order by create_at desc limit 1", base, quote).Scan(&price); err != nil {
+		return price, ok
+	}
+
+	return price, true
+}
@@ -0,0 +1,319 @@
+package future
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/assets/common/query"
+	"github.com/cryptogateway/backend-envoys/server/types"
+)
+
+var (
+	// maintenance - the maintenance margin of a position as a share of its value at the mark price, a position whose margin and
+	// unrealized pnl fall to it is liquidated.
+	maintenance = 0.005
+
+	// leverageLimit - the largest leverage of a futures order, a higher leverage would open positions whose margin is below
+	// the maintenance margin from the start.
+	leverageLimit = 100.0
+
+	// fund - the account of the insurance fund, it receives the margin left over by the liquidations and takes over the
+	// positions that the book can not close. The fund is never liquidated itself.
+	fund int64 = 0
+)
+
+// liquidation - checks the open positions against their maintenance margin at a specific time interval.
+func (a *Service) liquidation() {
+
+	ticker := time.NewTicker(time.Second * 10)
+	for range ticker.C {
+
+		positions, err := a.queryUnderMargin()
+		if a.Context.Debug(err) {
+			continue
+		}
+
+		for _, position := range positions {
+			a.Context.Debug(a.liquidate(position))
+		}
+	}
+}
+
+// queryUnderMargin - returns the open positions whose equity, the margin together with the unrealized pnl at the mark price, fell
+// to the maintenance margin. A pair without a mark price is not checked, the price of the last trade is never used.
+func (a *Service) queryUnderMargin() ([]*types.FuturePosition, error) {
+
+	var (
+		positions []*types.FuturePosition
+	)
+
+	rows, err := a.Context.Db.Query("select id, user_id, base_unit, quote_unit, position, size, entry_price, leverage, margin from positions where size > 0 and user_id != $1 order by id", fund)
+	if err != nil {
+		return positions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			item types.FuturePosition
+		)
+
+		if err := rows.Scan(&item.Id, &item.UserId, &item.BaseUnit, &item.QuoteUnit, &item.Position, &item.Size, &item.EntryPrice, &item.Leverage, &item.Margin); err != nil {
+			return positions, err
+		}
+
+		price, ok := a.queryMark(item.GetBaseUnit(), item.GetQuoteUnit())
+		if !ok {
+			continue
+		}
+		item.MarkPrice = price
+		item.UnrealizedPnl = a.queryPnl(item.GetPosition(), item.GetSize(), item.GetEntryPrice(), price)
+
+		if a.queryUnder(item.GetPosition(), decimal.New(item.GetSize()), decimal.New(item.GetEntryPrice()), decimal.New(item.GetMargin()), decimal.New(price)) {
+			positions = append(positions, &item)
+		}
+	}
+
+	return positions, rows.Err()
+}
+
+// queryUnder - reports whether the equity of a position, its margin together with its unrealized pnl at the price, fell to the
+// maintenance margin of its value at the price.
+func (a *Service) queryUnder(position string, size, entry, margin, price *decimal.Float) bool {
+
+	if !size.IsPositive() {
+		return false
+	}
+
+	_, pnl := a.queryClose(position, size, entry, margin, size, price)
+
+	return !margin.Add(pnl).GreaterThan(size.Mul(price).Mul(maintenance).Decimal)
+}
+
+// queryBankruptcy - returns the bankruptcy price of a position, the price at which the loss of the position takes its whole
+// margin. The bankruptcy price of a long position whose margin exceeds its value is not positive.
+func (a *Service) queryBankruptcy(position string, size, entry, margin *decimal.Float) *decimal.Float {
+
+	if !size.IsPositive() {
+		return decimal.New(0)
+	}
+
+	loss := margin.Div(size)
+	if position == types.PositionLong {
+		return entry.Sub(loss)
+	}
+
+	return entry.Add(loss)
+}
+
+// liquidate - liquidates the position. The pair is locked for the whole liquidation and the position is read again and locked,
+// a position that is no longer under its maintenance margin at the current mark price is left alone. The open futures
+// orders of the user on the pair are cancelled first, then a liquidation order closes the size on the book at prices no
+// worse than the bankruptcy price of the locked position, and the margin that is left over goes to the insurance fund.
+// The size that the book can not close is taken over by the insurance fund together with its margin. The liquidation is
+// recorded, the user receives a mail and the record is published to the future/liquidation channel.
+func (a *Service) liquidate(position *types.FuturePosition) error {
+
+	var (
+		size, entry, margin = decimal.New(0), decimal.New(0), decimal.New(0)
+		migrate             = query.Migrate{
+			Context: a.Context,
+		}
+	)
+
+	// No order of the pair is matched while the position is closed.
+	mutex := books.lock(position.GetBaseUnit(), position.GetQuoteUnit())
+	defer mutex.Unlock()
+
+	price, ok := a.queryMark(position.GetBaseUnit(), position.GetQuoteUnit())
+	if !ok {
+		return nil
+	}
+
+	if err := a.writeAsset(position.GetQuoteUnit(), types.TypeFuture, fund, false); err != nil {
+		return err
+	}
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow("select size, entry_price, margin, leverage from positions where id = $1 for update", position.GetId()).Scan(size, entry, margin, &position.Leverage); err != nil {
+		return err
+	}
+
+	// The position may have been closed or topped up since it was selected, it is only liquidated if it is still under margin.
+	if !a.queryUnder(position.GetPosition(), size, entry, margin, decimal.New(price)) {
+		return nil
+	}
+
+	position.Size, position.EntryPrice, position.Margin, position.MarkPrice = size.Float(), entry.Float(), margin.Float(), price
+
+	liquidation := types.Liquidation{
+		UserId:          position.GetUserId(),
+		BaseUnit:        position.GetBaseUnit(),
+		QuoteUnit:       position.GetQuoteUnit(),
+		Position:        position.GetPosition(),
+		Size:            position.GetSize(),
+		EntryPrice:      position.GetEntryPrice(),
+		MarkPrice:       position.GetMarkPrice(),
+		Margin:          position.GetMargin(),
+		BankruptcyPrice: a.queryBankruptcy(position.GetPosition(), size, entry, margin).Float(),
+	}
+
+	a.Context.Logger.Infof("[LIQUIDATION]: user ID: %v position: %v %v/%v, size: %v, mark price: %v", position.GetUserId(), position.GetPosition(), position.GetBaseUnit(), position.GetQuoteUnit(), position.GetSize(), position.GetMarkPrice())
+
+	if err := a.writeLiquidateOrders(tx, position.GetUserId(), position.GetBaseUnit(), position.GetQuoteUnit()); err != nil {
+		return err
+	}
+
+	order := types.Future{
+		UserId:    position.GetUserId(),
+		BaseUnit:  position.GetBaseUnit(),
+		QuoteUnit: position.GetQuoteUnit(),
+		Position:  position.GetPosition(),
+		Assigning: types.AssigningClose,
+		OrderType: types.TradingLiquidate,
+		Price:     liquidation.GetBankruptcyPrice(),
+		Quantity:  position.GetSize(),
+		Value:     position.GetSize(),
+		Leverage:  position.GetLeverage(),
+		Status:    types.StatusPending,
+	}
+
+	// A long position whose loss exceeds its entry price can not be sold at any price, the whole size goes to the fund.
+	if order.GetPrice() > 0 {
+		if order.Id, err = a.writeOrder(tx, &order, decimal.New(0)); err != nil {
+			return err
+		}
+	}
+
+	// The position stays as it was read until the match, the pair lock keeps every other fill of the pair out.
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if order.GetId() > 0 {
+
+		a.match(&order)
+
+		// The liquidation order never rests on the book, the size that it did not close is taken over by the fund.
+		if order.GetValue() > 0 {
+			if _, err := a.Context.Db.Exec("update futures set status = $2 where id = $1 and status = $3", order.GetId(), types.StatusCancel, types.StatusPending); err != nil {
+				return err
+			}
+		}
+	}
+
+	current, err := a.queryPosition(&order)
+	if err != nil {
+		return err
+	}
+
+	liquidation.Taken = current.GetSize()
+	liquidation.Closed = size.Sub(liquidation.GetTaken()).Float()
+
+	if liquidation.GetTaken() > 0 {
+		if err := a.writeTakeover(current); err != nil {
+			return err
+		}
+	}
+
+	if err := a.Context.Db.QueryRow("insert into liquidations (user_id, position_id, base_unit, quote_unit, position, size, entry_price, mark_price, bankruptcy_price, margin, closed, taken) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id, create_at", liquidation.GetUserId(), position.GetId(), liquidation.GetBaseUnit(), liquidation.GetQuoteUnit(), liquidation.GetPosition(), liquidation.GetSize(), liquidation.GetEntryPrice(), liquidation.GetMarkPrice(), liquidation.GetBankruptcyPrice(), liquidation.GetMargin(), liquidation.GetClosed(), liquidation.GetTaken()).Scan(&liquidation.Id, &liquidation.CreateAt); err != nil {
+		return err
+	}
+
+	go migrate.SendMail(position.GetUserId(), "future_liquidation", position.GetPosition(), position.GetBaseUnit(), position.GetQuoteUnit(), position.GetMarkPrice())
+
+	return a.Context.Publish(&liquidation, "exchange", "future/liquidation")
+}
+
+// writeLiquidateOrders - cancels the pending futures orders of the user on the pair inside the transaction of the liquidation, an
+// opening order returns the margin still reserved on it to the futures balance of the quote unit.
+func (a *Service) writeLiquidateOrders(tx *sql.Tx, userId int64, base, quote string) error {
+
+	// Only an opening order reserved margin, the refund is the margin left on the order and no leverage is divided by.
+	rows, err := tx.Query("update futures set status = $4 where user_id = $1 and base_unit = $2 and quote_unit = $3 and status = $5 returning assigning, margin", userId, base, quote, types.StatusCancel, types.StatusPending)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	refund := decimal.New(0)
+	for rows.Next() {
+
+		var (
+			assigning string
+			reserved  decimal.Float
+		)
+
+		if err := rows.Scan(&assigning, &reserved); err != nil {
+			return err
+		}
+
+		if assigning == types.AssigningOpen {
+			refund = refund.Add(&reserved)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	if refund.IsPositive() {
+		if err := a.writeBalance(tx, quote, types.TypeFuture, userId, refund, types.BalancePlus); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeTakeover - moves the open size of the position with its margin to the position of the insurance fund on the same pair and
+// side, the fund takes it over at the entry price of the user. The user loses the margin of the size.
+func (a *Service) writeTakeover(position *types.FuturePosition) error {
+
+	var (
+		size, entry, margin   = decimal.New(0), decimal.New(0), decimal.New(0)
+		total, average, stake = decimal.New(0), decimal.New(0), decimal.New(0)
+	)
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow("select size, entry_price, margin from positions where id = $1 for update", position.GetId()).Scan(size, entry, margin); err != nil {
+		return err
+	}
+
+	if !size.IsPositive() {
+		return nil
+	}
+
+	if _, err := tx.Exec("update positions set size = 0, margin = 0, realized = realized - $2, leverage = 1, update_at = now() where id = $1", position.GetId(), margin.String()); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("insert into positions (user_id, base_unit, quote_unit, position) values ($1, $2, $3, $4) on conflict (user_id, base_unit, quote_unit, position) do nothing", fund, position.GetBaseUnit(), position.GetQuoteUnit(), position.GetPosition()); err != nil {
+		return err
+	}
+
+	if err := tx.QueryRow("select size, entry_price, margin from positions where user_id = $1 and base_unit = $2 and quote_unit = $3 and position = $4 for update", fund, position.GetBaseUnit(), position.GetQuoteUnit(), position.GetPosition()).Scan(total, average, stake); err != nil {
+		return err
+	}
+
+	total, average, stake = a.queryOpen(total, average, stake, size, entry, margin)
+
+	if _, err := tx.Exec("update positions set size = $5, entry_price = $6, margin = $7, leverage = $8, update_at = now() where user_id = $1 and base_unit = $2 and quote_unit = $3 and position = $4", fund, position.GetBaseUnit(), position.GetQuoteUnit(), position.GetPosition(), total.String(), average.String(), stake.String(), a.queryLeverage(total, average, stake).String()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
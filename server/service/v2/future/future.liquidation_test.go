@@ -0,0 +1,156 @@
+package future
+
+import (
+	"testing"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/server/types"
+)
+
+func TestService_QueryUnder(t *testing.T) {
+	tests := []struct {
+		name                       string
+		position                   string
+		size, entry, margin, price string
+		want                       bool
+	}{
+		{
+			name:     t.Name(),
+			position: types.PositionLong,
+			size:     "1",
+			entry:    "100",
+			margin:   "10",
+			price:    "90.5",
+			want:     false,
+		},
+		{
+			name:     t.Name(),
+			position: types.PositionLong,
+			size:     "1",
+			entry:    "100",
+			margin:   "10",
+			price:    "90.4",
+			want:     true,
+		},
+		{
+			name:     t.Name(),
+			position: types.PositionShort,
+			size:     "2",
+			entry:    "100",
+			margin:   "20",
+			price:    "109.5",
+			want:     true,
+		},
+		{
+			name:     t.Name(),
+			position: types.PositionShort,
+			size:     "0",
+			entry:    "100",
+			margin:   "0",
+			price:    "200",
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := new(Service).queryUnder(tt.position, decimal.New(tt.size), decimal.New(tt.entry), decimal.New(tt.margin), decimal.New(tt.price)); got != tt.want {
+				t.Errorf("queryUnder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_QueryBankruptcy(t *testing.T) {
+	tests := []struct {
+		name                string
+		position            string
+		size, entry, margin string
+		want                string
+	}{
+		{
+			name:     t.Name(),
+			position: types.PositionLong,
+			size:     "1",
+			entry:    "100",
+			margin:   "10",
+			want:     "90",
+		},
+		{
+			name:     t.Name(),
+			position: types.PositionShort,
+			size:     "2",
+			entry:    "100",
+			margin:   "20",
+			want:     "110",
+		},
+		{
+			name:     t.Name(),
+			position: types.PositionLong,
+			size:     "1",
+			entry:    "100",
+			margin:   "150",
+			want:     "-50",
+		},
+		{
+			name:     t.Name(),
+			position: types.PositionLong,
+			size:     "0",
+			entry:    "100",
+			margin:   "0",
+			want:     "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := new(Service).queryBankruptcy(tt.position, decimal.New(tt.size), decimal.New(tt.entry), decimal.New(tt.margin)).String(); got != tt.want {
+				t.Errorf("queryBankruptcy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_Takeover(t *testing.T) {
+	tests := []struct {
+		name                                          string
+		total, average, stake                         string
+		size, entry, margin                           string
+		wantSize, wantEntry, wantMargin, wantLeverage string
+	}{
+		{
+			name:         t.Name(),
+			total:        "0",
+			average:      "0",
+			stake:        "0",
+			size:         "2",
+			entry:        "50",
+			margin:       "5",
+			wantSize:     "2",
+			wantEntry:    "50",
+			wantMargin:   "5",
+			wantLeverage: "20",
+		},
+		{
+			name:         t.Name(),
+			total:        "1",
+			average:      "100",
+			stake:        "10",
+			size:         "3",
+			entry:        "120",
+			margin:       "36",
+			wantSize:     "4",
+			wantEntry:    "115",
+			wantMargin:   "46",
+			wantLeverage: "10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := new(Service)
+			size, entry, margin := a.queryOpen(decimal.New(tt.total), decimal.New(tt.average), decimal.New(tt.stake), decimal.New(tt.size), decimal.New(tt.entry), decimal.New(tt.margin))
+			leverage := a.queryLeverage(size, entry, margin)
+			if size.String() != tt.wantSize || entry.String() != tt.wantEntry || margin.String() != tt.wantMargin || leverage.String() != tt.wantLeverage {
+				t.Errorf("takeover = %v, %v, %v, %v, want %v, %v, %v, %v", size, entry, margin, leverage, tt.wantSize, tt.wantEntry, tt.wantMargin, tt.wantLeverage)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package future
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/server/types"
+	"google.golang.org/grpc/status"
+)
+
+// queryDirection - returns the side of the book that the order trades on: opening a long and closing a short buy the contract,
+// opening a short and closing a long sell it.
+func (a *Service) queryDirection(order *types.Future) string {
+
+	if (order.GetAssigning() == types.AssigningOpen) == (order.GetPosition() == types.PositionLong) {
+		return types.AssigningBuy
+	}
+
+	return types.AssigningSell
+}
+
+// queryPnl - returns the profit of the quantity of a position entered at the entry price and valued at the price, a long
+// position gains when the price rises and a short position when it falls.
+func (a *Service) queryPnl(position string, quantity, entry, price float64) float64 {
+
+	pnl := decimal.New(price).Sub(entry).Mul(quantity)
+	if position == types.PositionShort {
+		pnl = pnl.Mul(-1)
+	}
+
+	return pnl.Float()
+}
+
+// queryPositions - returns the positions of the user, optionally of a pair and a side, valued at the mark price of their pair.
+// The closed positions, whose size went down to zero, are kept for their realized pnl and only returned when asked for.
+func (a *Service) queryPositions(userId int64, base, quote, position string, closed bool) ([]*types.FuturePosition, error) {
+
+	var (
+		positions []*types.FuturePosition
+		maps      []string
+		args      = []interface{}{userId}
+	)
+
+	maps = append(maps, "where user_id = $1")
+
+	if len(base) > 0 && len(quote) > 0 {
+		args = append(args, base, quote)
+		maps = append(maps, fmt.Sprintf("and base_unit = $%d and quote_unit = $%d", len(args)-1, len(args)))
+	}
+
+	if len(position) > 0 {
+		args = append(args, position)
+		maps = append(maps, fmt.Sprintf("and position = $%d", len(args)))
+	}
+
+	if !closed {
+		maps = append(maps, "and size > 0")
+	}
+
+	rows, err := a.Context.Db.Query(fmt.Sprintf("select id, user_id, base_unit, quote_unit, position, size, entry_price, leverage, margin, realized, update_at, create_at from positions %s order by id", strings.Join(maps, " ")), args...)
+	if err != nil {
+		return positions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			item types.FuturePosition
+		)
+
+		if err := rows.Scan(&item.Id, &item.UserId, &item.BaseUnit, &item.QuoteUnit, &item.Position, &item.Size, &item.EntryPrice, &item.Leverage, &item.Margin, &item.RealizedPnl, &item.UpdateAt, &item.CreateAt); err != nil {
+			return positions, err
+		}
+
+		// A pair without a mark price yet has no unrealized pnl, the price of the last trade is never used instead.
+		if price, ok := a.queryMark(item.GetBaseUnit(), item.GetQuoteUnit()); ok && item.GetSize() > 0 {
+			item.MarkPrice = price
+			item.UnrealizedPnl = a.queryPnl(item.GetPosition(), item.GetSize(), item.GetEntryPrice(), item.GetMarkPrice())
+		}
+
+		positions = append(positions, &item)
+	}
+
+	return positions, rows.Err()
+}
+
+// queryPosition - returns the position of the user on the pair and the side of the order, a position that was never opened
+// is returned empty.
+func (a *Service) queryPosition(order *types.Future) (*types.FuturePosition, error) {
+
+	positions, err := a.queryPositions(order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetPosition(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(positions) == 0 {
+		return &types.FuturePosition{UserId: order.GetUserId(), BaseUnit: order.GetBaseUnit(), QuoteUnit: order.GetQuoteUnit(), Position: order.GetPosition()}, nil
+	}
+
+	return positions[0], nil
+}
+
+// queryOpen - returns the size, the entry price and the margin of a position after the quantity is added to it at the price: the
+// entry price becomes the average price of the size and the margin grows by the stake of the quantity.
+func (a *Service) queryOpen(size, entry, margin, quantity, price, stake *decimal.Float) (*decimal.Float, *decimal.Float, *decimal.Float) {
+
+	total := size.Add(quantity)
+	if !total.IsPositive() {
+		return size, entry, margin
+	}
+
+	return total, size.Mul(entry).Add(quantity.Mul(price)).Div(total), margin.Add(stake)
+}
+
+// queryClose - returns the margin released by closing the quantity of a position at the price and the pnl realized by it, the
+// margin of the position is released in proportion to the closed share of its size.
+func (a *Service) queryClose(position string, size, entry, margin, quantity, price *decimal.Float) (released, pnl *decimal.Float) {
+
+	if !size.IsPositive() {
+		return decimal.New(0), decimal.New(0)
+	}
+
+	pnl = price.Sub(entry).Mul(quantity)
+	if position == types.PositionShort {
+		pnl = pnl.Mul(-1)
+	}
+
+	return margin.Mul(quantity).Div(size), pnl
+}
+
+// queryLeverage - returns the leverage of a position, its value at the entry price divided by its margin. An empty position or
+// a position without margin has the leverage of 1.
+func (a *Service) queryLeverage(size, entry, margin *decimal.Float) *decimal.Float {
+
+	if margin.IsPositive() && size.IsPositive() {
+		return size.Mul(entry).Div(margin).Round(4)
+	}
+
+	return decimal.New(1)
+}
+
+// writePosition - applies a fill of the order to the position of the user inside the transaction of the fill, the fee of the fill
+// is paid from the margin.
+//
+// An opening fill adds the quantity at the price: the entry price becomes the average price of the size, and the stake, the
+// margin that the order reserved for the quantity, is added to the margin of the position. A closing fill takes the quantity off the
+// size: its pnl at the price against the entry price is realized, the margin of the quantity is released, and both are
+// credited to the futures balance of the quote unit. The credit of a liquidation order goes to the insurance fund instead of
+// the user. A closing fill larger than the size is an error, the fill is capped at the size before it is written.
+func (a *Service) writePosition(tx *sql.Tx, order *types.Future, quantity, price, fees, stake *decimal.Float) error {
+
+	var (
+		size, entry, margin, realized = decimal.New(0), decimal.New(0), decimal.New(0), decimal.New(0)
+	)
+
+	if _, err := tx.Exec("insert into positions (user_id, base_unit, quote_unit, position) values ($1, $2, $3, $4) on conflict (user_id, base_unit, quote_unit, position) do nothing", order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetPosition()); err != nil {
+		return err
+	}
+
+	if err := tx.QueryRow("select size, entry_price, margin, realized from positions where user_id = $1 and base_unit = $2 and quote_unit = $3 and position = $4 for update", order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetPosition()).Scan(size, entry, margin, realized); err != nil {
+		return err
+	}
+
+	switch order.GetAssigning() {
+	case types.AssigningOpen:
+
+		size, entry, margin = a.queryOpen(size, entry, margin, quantity, price, stake)
+		margin = margin.Sub(fees)
+		realized = realized.Sub(fees)
+
+		break
+	case types.AssigningClose:
+
+		if quantity.GreaterThan(size.Decimal) {
+			return status.Errorf(11625, "the fill %v is larger than the open size %v of the position", quantity.String(), size.String())
+		}
+
+		released, pnl := a.queryClose(order.GetPosition(), size, entry, margin, quantity, price)
+
+		// A loss larger than the margin of the quantity is not taken from the balance, it is covered by the liquidation.
+		if credit := released.Add(pnl).Sub(fees); credit.IsPositive() {
+
+			holder := order.GetUserId()
+			if order.GetOrderType() == types.TradingLiquidate {
+				holder = fund
+			}
+
+			if err := a.writeBalance(tx, order.GetQuoteUnit(), types.TypeFuture, holder, credit, types.BalancePlus); err != nil {
+				return err
+			}
+		}
+
+		margin = margin.Sub(released)
+		realized = realized.Add(pnl).Sub(fees)
+		size = size.Sub(quantity)
+
+		break
+	default:
+		return errors.New("invalid assigning trade position")
+	}
+
+	if _, err := tx.Exec("update positions set size = $5, entry_price = $6, margin = $7, realized = $8, leverage = $9, update_at = now() where user_id = $1 and base_unit = $2 and quote_unit = $3 and position = $4", order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetPosition(), size.String(), entry.String(), margin.String(), realized.String(), a.queryLeverage(size, entry, margin).String()); err != nil {
+		return err
+	}
+
+	return nil
+}
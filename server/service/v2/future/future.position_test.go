@@ -0,0 +1,194 @@
+package future
+
+import (
+	"testing"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/server/types"
+)
+
+func TestService_QueryPnl(t *testing.T) {
+	tests := []struct {
+		name     string
+		position string
+		quantity float64
+		entry    float64
+		price    float64
+		want     float64
+	}{
+		{
+			name:     t.Name(),
+			position: types.PositionLong,
+			quantity: 2,
+			entry:    100,
+			price:    110.5,
+			want:     21,
+		},
+		{
+			name:     t.Name(),
+			position: types.PositionShort,
+			quantity: 2,
+			entry:    100,
+			price:    110.5,
+			want:     -21,
+		},
+		{
+			name:     t.Name(),
+			position: types.PositionShort,
+			quantity: 0.1,
+			entry:    0.3,
+			price:    0.1,
+			want:     0.02,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := new(Service).queryPnl(tt.position, tt.quantity, tt.entry, tt.price); got != tt.want {
+				t.Errorf("queryPnl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_QueryOpen(t *testing.T) {
+	tests := []struct {
+		name                            string
+		size, entry, margin             string
+		quantity, price, stake          string
+		wantSize, wantEntry, wantMargin string
+	}{
+		{
+			name:       t.Name(),
+			size:       "0",
+			entry:      "0",
+			margin:     "0",
+			quantity:   "0.5",
+			price:      "20000",
+			stake:      "1000",
+			wantSize:   "0.5",
+			wantEntry:  "20000",
+			wantMargin: "1000",
+		},
+		{
+			name:       t.Name(),
+			size:       "1",
+			entry:      "100",
+			margin:     "10",
+			quantity:   "3",
+			price:      "120",
+			stake:      "36",
+			wantSize:   "4",
+			wantEntry:  "115",
+			wantMargin: "46",
+		},
+		{
+			name:       t.Name(),
+			size:       "1",
+			entry:      "100",
+			margin:     "10",
+			quantity:   "0",
+			price:      "120",
+			stake:      "0",
+			wantSize:   "1",
+			wantEntry:  "100",
+			wantMargin: "10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, entry, margin := new(Service).queryOpen(decimal.New(tt.size), decimal.New(tt.entry), decimal.New(tt.margin), decimal.New(tt.quantity), decimal.New(tt.price), decimal.New(tt.stake))
+			if size.String() != tt.wantSize || entry.String() != tt.wantEntry || margin.String() != tt.wantMargin {
+				t.Errorf("queryOpen() = %v, %v, %v, want %v, %v, %v", size, entry, margin, tt.wantSize, tt.wantEntry, tt.wantMargin)
+			}
+		})
+	}
+}
+
+func TestService_QueryClose(t *testing.T) {
+	tests := []struct {
+		name                  string
+		position              string
+		size, entry, margin   string
+		quantity, price       string
+		wantReleased, wantPnl string
+	}{
+		{
+			name:         t.Name(),
+			position:     types.PositionLong,
+			size:         "4",
+			entry:        "115",
+			margin:       "46",
+			quantity:     "1",
+			price:        "125",
+			wantReleased: "11.5",
+			wantPnl:      "10",
+		},
+		{
+			name:         t.Name(),
+			position:     types.PositionShort,
+			size:         "2",
+			entry:        "100",
+			margin:       "20",
+			quantity:     "2",
+			price:        "104",
+			wantReleased: "20",
+			wantPnl:      "-8",
+		},
+		{
+			name:         t.Name(),
+			position:     types.PositionLong,
+			size:         "0",
+			entry:        "100",
+			margin:       "0",
+			quantity:     "1",
+			price:        "104",
+			wantReleased: "0",
+			wantPnl:      "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			released, pnl := new(Service).queryClose(tt.position, decimal.New(tt.size), decimal.New(tt.entry), decimal.New(tt.margin), decimal.New(tt.quantity), decimal.New(tt.price))
+			if released.String() != tt.wantReleased || pnl.String() != tt.wantPnl {
+				t.Errorf("queryClose() = %v, %v, want %v, %v", released, pnl, tt.wantReleased, tt.wantPnl)
+			}
+		})
+	}
+}
+
+func TestService_QueryLeverage(t *testing.T) {
+	tests := []struct {
+		name                string
+		size, entry, margin string
+		want                string
+	}{
+		{
+			name:   t.Name(),
+			size:   "4",
+			entry:  "115",
+			margin: "46",
+			want:   "10",
+		},
+		{
+			name:   t.Name(),
+			size:   "3",
+			entry:  "100",
+			margin: "70",
+			want:   "4.2857",
+		},
+		{
+			name:   t.Name(),
+			size:   "0",
+			entry:  "100",
+			margin: "0",
+			want:   "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := new(Service).queryLeverage(decimal.New(tt.size), decimal.New(tt.entry), decimal.New(tt.margin)).String(); got != tt.want {
+				t.Errorf("queryLeverage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,171 +2,266 @@ package future
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
 	"github.com/cryptogateway/backend-envoys/assets/common/query"
 	"github.com/cryptogateway/backend-envoys/server/proto/v2/pbfuture"
 	"github.com/cryptogateway/backend-envoys/server/types"
-	"google.golang.org/grpc/status"
+	"github.com/lib/pq"
 )
 
-func (a *Service) closePosition(order *types.Future) {
-	// publish exchange
-	fmt.Println("PUBLISH EXCHANGE")
+// match - matches the order against the pending orders of the other users on the opposite side of the book. An order that buys
+// the contract (opening a long or closing a short) trades with the orders that sell it (opening a short or closing a
+// long) at a price at or below its own, and the other way round. The best prices trade first and, at the same price,
+// the oldest orders. Every fill trades at the price of the resting order and updates the positions of both users. The caller
+// holds the lock of the pair, so the orders of the pair are matched one at a time.
+func (a *Service) match(order *types.Future) {
+
+	var (
+		items         []*types.Future
+		opens, closes = types.PositionShort, types.PositionLong
+		compare, sort = "<=", "asc"
+	)
+
 	if err := a.Context.Publish(order, "exchange", "future/create"); a.Context.Debug(err) {
 		return
 	}
 
-	var position string
-
-	if order.Position == types.PositionLong {
-		position = types.PositionShort
-	} else {
-		position = types.PositionLong
+	if a.queryDirection(order) == types.AssigningSell {
+		opens, closes = types.PositionLong, types.PositionShort
+		compare, sort = ">=", "desc"
 	}
 
-	// get future orders by position
-	rows, err := a.Context.Db.Query(`select id, assigning, position, base_unit, quote_unit, quantity, price, user_id, status from futures where assigning = $1 and base_unit = $2 and quote_unit = $3 and user_id != $4 and status = $5 and position = $6 order by id`, "close", order.GetBaseUnit(), order.GetQuoteUnit(), order.GetUserId(), types.StatusPending, position)
-
+	rows, err := a.Context.Db.Query(fmt.Sprintf(`select id, assigning, position, trading, base_unit, quote_unit, quantity, value, price, leverage, user_id, status from futures where base_unit = $1 and quote_unit = $2 and user_id != $3 and status = $4 and ((assigning = $5 and position = $6) or (assigning = $7 and position = $8)) and price %s $9 order by price %s, id`, compare, sort), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetUserId(), types.StatusPending, types.AssigningOpen, opens, types.AssigningClose, closes, order.GetPrice())
 	if a.Context.Debug(err) {
 		return
 	}
-
 	defer rows.Close()
 
 	for rows.Next() {
+
 		var (
 			item types.Future
 		)
 
-		if err = rows.Scan(&item.Id, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.Quantity, &item.Price, &item.UserId, &item.Status); a.Context.Debug(err) {
+		if err = rows.Scan(&item.Id, &item.Assigning, &item.Position, &item.OrderType, &item.BaseUnit, &item.QuoteUnit, &item.Quantity, &item.Value, &item.Price, &item.Leverage, &item.UserId, &item.Status); a.Context.Debug(err) {
 			return
 		}
 
-		// check order status and update value
+		items = append(items, &item)
+	}
+
+	if a.Context.Debug(rows.Err()) {
+		return
+	}
+	_ = rows.Close()
 
-		// swtich position
-		switch position {
-		//case long
-		case types.PositionLong:
-			if order.GetPrice() <= item.GetPrice() {
-				a.Context.Logger.Infof("[BID]: (item [%v]) >= (order [%v]), order ID: %v", order.GetPrice(), item.GetPrice(), item.GetId())
+	for _, item := range items {
 
-				//process trade
-				a.handleFutureTrade(position, order, &item)
-			} else {
-				a.Context.Logger.Infof("[BID]: no matches found: (item [%v]) >= (order [%v])", order.GetPrice(), item.GetPrice())
-			}
-			break
-			//case short
-		case types.PositionShort:
-			if order.GetPrice() >= item.GetPrice() {
-				a.Context.Logger.Infof("[ASK]: (order [%v]) <= (item [%v]), order ID: %v", order.GetPrice(), item.GetPrice(), item.GetId())
-				//process trade
-
-				a.handleFutureTrade(position, order, &item)
-			} else {
-				a.Context.Logger.Infof("[ASK]: no matches found: (order [%v]) <= (item [%v])", order.GetPrice(), item.GetPrice())
-			}
+		if order.GetValue() <= 0 || order.GetStatus() != types.StatusPending {
 			break
-		default:
-			if err := a.Context.Debug(status.Error(11589, "invalid assigning trade position")); err {
-				return
-			}
+		}
+
+		quantity, err := a.writeFill(order, item, item.GetPrice())
+		if a.Context.Debug(err) {
+			return
+		}
+
+		if quantity == 0 {
+			continue
+		}
+
+		a.Context.Logger.Infof("[FUTURE]: order ID: %v matched order ID: %v, quantity: %v, price: %v", order.GetId(), item.GetId(), quantity, item.GetPrice())
+
+		if _, err := a.SetTicker(context.Background(), &pbfuture.SetRequestTicker{Key: a.Context.Secrets[2], Price: item.GetPrice(), Value: quantity, BaseUnit: order.GetBaseUnit(), QuoteUnit: order.GetQuoteUnit(), Assigning: a.queryDirection(order)}); a.Context.Debug(err) {
+			return
 		}
 	}
 }
 
-func (a *Service) handleFutureTrade(position string, params ...*types.Future) {
+// writeFill - settles one fill between the order and the resting maker order at the price in a single transaction, and returns
+// the quantity filled. The caller holds the lock of the pair.
+//
+// Both orders are locked and read again, a fill is only made while both are pending. The quantity is the smaller open
+// quantity of the two and never more than the size left in the position of a closing side, a close order whose position is
+// already closed is cancelled instead. The open quantities of both orders decrease, an order is filled once nothing is left,
+// and the trades and the positions of both users are written before anything is committed; the rest of a close order whose
+// position the fill closed is cancelled. The positions and the orders are published after the commit.
+func (a *Service) writeFill(order, maker *types.Future, price float64) (float64, error) {
 
 	var (
-		price    float64
-		instance int
+		sides    = []*types.Future{maker, order}
+		sizes    = make(map[int64]*decimal.Float)
+		margins  = make(map[int64]*decimal.Float)
+		quantity *decimal.Float
 		migrate  = query.Migrate{
 			Context: a.Context,
 		}
 	)
 
-	if params[0].GetValue() >= params[1].GetValue() {
-		instance = 1
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	switch position {
-	case types.PositionLong:
-		price = params[1].GetPrice()
-	case types.PositionShort:
-		price = params[0].GetPrice()
+	rows, err := tx.Query("select id, value, status, margin from futures where id = any($1) order by id for update", pq.Array([]int64{order.GetId(), maker.GetId()}))
+	if err != nil {
+		return 0, err
 	}
+	defer rows.Close()
 
-	if params[instance].GetValue() > 0 {
+	for rows.Next() {
 
-		for i := 0; i < 2; i++ {
+		var (
+			id     int64
+			value  float64
+			status string
+			margin = decimal.New(0)
+		)
 
-			var (
-				value float64
-			)
+		if err := rows.Scan(&id, &value, &status, margin); err != nil {
+			return 0, err
+		}
 
-			if err := a.Context.Db.QueryRow("update futures set value = value - $2 where id = $1 and status = $3 returning value;", params[i].GetId(), params[instance].GetValue(), types.StatusPending).Scan(&value); a.Context.Debug(err) {
-				return
+		for _, side := range sides {
+			if side.GetId() == id {
+				side.Value, side.Status = value, status
+				margins[id] = margin
 			}
+		}
+	}
 
-			if value == 0 {
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	_ = rows.Close()
 
-				if _, err := a.Context.Db.Exec("update futures set status = $2 where id = $1", params[i].GetId(), types.StatusFilled); a.Context.Debug(err) {
-					return
-				}
+	for _, side := range sides {
 
-				go migrate.SendMail(params[i].GetUserId(), "order_filled", params[i].GetId(), a.queryQuantity(params[i].GetAssigning(), params[i].GetPosition(), params[i].GetQuantity(), price, false), params[i].GetBaseUnit(), params[i].GetQuoteUnit(), params[i].GetAssigning())
-			}
+		if side.GetStatus() != types.StatusPending || side.GetValue() <= 0 {
+			return 0, tx.Commit()
+		}
+
+		if quantity == nil || decimal.New(side.GetValue()).LessThan(quantity.Decimal) {
+			quantity = decimal.New(side.GetValue())
 		}
+	}
 
-		switch params[1].GetPosition() {
-		case types.PositionLong:
+	for _, side := range sides {
 
-			quantity, err := a.writeTrade(params[0].GetId(), params[0].GetQuoteUnit(), params[instance].GetValue(), price, true)
-			if a.Context.Debug(err) {
-				return
-			}
+		if side.GetAssigning() != types.AssigningClose {
+			continue
+		}
 
-			if err := a.WriteBalance(params[0].GetQuoteUnit(), "future", params[0].GetUserId(), quantity, types.BalancePlus); a.Context.Debug(err) {
-				return
-			}
+		size := decimal.New(0)
+		if err := tx.QueryRow("select size from positions where user_id = $1 and base_unit = $2 and quote_unit = $3 and position = $4 for update", side.GetUserId(), side.GetBaseUnit(), side.GetQuoteUnit(), side.GetPosition()).Scan(size); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+
+		// A close order can not close more than is left of its position, a position that is already closed cancels the order.
+		if !size.IsPositive() {
 
-			quantity, err = a.writeTrade(params[1].GetId(), params[0].GetBaseUnit(), params[instance].GetValue(), price, false)
-			if a.Context.Debug(err) {
-				return
+			if _, err := tx.Exec("update futures set status = $2 where id = $1", side.GetId(), types.StatusCancel); err != nil {
+				return 0, err
 			}
+			side.Status = types.StatusCancel
 
-			if err := a.WriteBalance(params[0].GetBaseUnit(), "future", params[1].GetUserId(), quantity, types.BalancePlus); err != nil {
-				return
+			if err := tx.Commit(); err != nil {
+				return 0, err
 			}
 
-			break
-		case types.PositionShort:
+			return 0, a.Context.Publish(side, "exchange", "future/status")
+		}
 
-			quantity, err := a.writeTrade(params[0].GetId(), params[0].GetBaseUnit(), params[instance].GetValue(), price, false)
-			if a.Context.Debug(err) {
-				return
-			}
+		if quantity.GreaterThan(size.Decimal) {
+			quantity = size
+		}
+		sizes[side.GetId()] = size
+	}
 
-			if err := a.WriteBalance(params[0].GetBaseUnit(), "future", params[0].GetUserId(), quantity, types.BalancePlus); a.Context.Debug(err) {
-				return
-			}
+	for _, side := range sides {
+
+		// The fill releases its share of the margin reserved on the order, the last fill releases all that is left of it.
+		stake := margins[side.GetId()]
+		if quantity.LessThan(decimal.New(side.GetValue()).Decimal) {
+			stake = stake.Mul(quantity).Div(side.GetValue())
+		}
 
-			quantity, err = a.writeTrade(params[1].GetId(), params[0].GetQuoteUnit(), params[instance].GetValue(), price, true)
-			if a.Context.Debug(err) {
-				return
+		if err := tx.QueryRow("update futures set value = value - $2, margin = margin - $4 where id = $1 and status = $3 and value >= $2 returning value;", side.GetId(), quantity.String(), types.StatusPending, stake.String()).Scan(&side.Value); err != nil {
+			return 0, err
+		}
+
+		if side.GetValue() <= 0 {
+
+			if _, err := tx.Exec("update futures set status = $2 where id = $1", side.GetId(), types.StatusFilled); err != nil {
+				return 0, err
 			}
+			side.Status = types.StatusFilled
 
-			if err := a.WriteBalance(params[0].GetQuoteUnit(), "future", params[1].GetUserId(), quantity, types.BalancePlus); a.Context.Debug(err) {
-				return
+		} else if size, ok := sizes[side.GetId()]; ok && !size.GreaterThan(quantity.Decimal) {
+
+			// The fill closed the whole position, the rest of the close order has nothing left to close.
+			if _, err := tx.Exec("update futures set status = $2 where id = $1", side.GetId(), types.StatusCancel); err != nil {
+				return 0, err
 			}
+			side.Status = types.StatusCancel
+		}
 
-			break
+		fees, err := a.writeTrade(tx, side, quantity.Float(), price, side == maker)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := a.writePosition(tx, side, quantity, decimal.New(price), decimal.New(fees), stake); err != nil {
+			return 0, err
 		}
 	}
 
-	if _, err := a.SetTicker(context.Background(), &pbfuture.SetRequestTicker{Key: a.Context.Secrets[2], Price: params[0].GetPrice(), Value: params[0].GetValue(), BaseUnit: params[0].GetBaseUnit(), QuoteUnit: params[0].GetQuoteUnit(), Assigning: params[0].GetAssigning()}); a.Context.Debug(err) {
-		return
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, side := range sides {
+
+		if side.GetStatus() == types.StatusFilled {
+			go migrate.SendMail(side.GetUserId(), "order_filled", side.GetId(), side.GetQuantity(), side.GetBaseUnit(), side.GetQuoteUnit(), a.queryDirection(side))
+		}
+
+		position, err := a.queryPosition(side)
+		if err != nil {
+			return quantity.Float(), err
+		}
+
+		if err := a.Context.Publish(position, "exchange", "future/position"); err != nil {
+			return quantity.Float(), err
+		}
+
+		if err := a.Context.Publish(side, "exchange", "future/status"); err != nil {
+			return quantity.Float(), err
+		}
+	}
+
+	return quantity.Float(), nil
+}
+
+// queryFees - returns the fee of the notional value of a fill in the quote unit, the maker of the fill pays the discounted fee.
+func (a *Service) queryFees(symbol string, value float64, maker bool) (float64, error) {
+
+	var (
+		fees, discount float64
+	)
+
+	if err := a.Context.Db.QueryRow("select fees_trade, fees_discount from assets where symbol = $1", symbol).Scan(&fees, &discount); err != nil {
+		return 0, err
 	}
+
+	if maker {
+		fees = decimal.New(fees).Sub(discount).Float()
+	}
+
+	return decimal.New(value).Mul(fees).Div(100).Float(), nil
 }
@@ -2,30 +2,93 @@ package provider
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"github.com/cryptogateway/backend-envoys/assets"
 	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/assets/common/orderbook"
 	"github.com/cryptogateway/backend-envoys/server/proto/v2/pbprovider"
 	"github.com/cryptogateway/backend-envoys/server/types"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 	"google.golang.org/grpc/status"
+	"math"
 	"os"
 	"path/filepath"
-	"strconv"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Service struct {
 	Context *assets.Context
 }
 
-// Initialization - The code initializes a Service object and runs six concurrent functions: chain(), price(), market().
+// tier - The fee tier of a user on a pair as it was last resolved, it is resolved again once it expires. The maker and taker
+// rates of the tier are kept as exact decimals for the settlement, the fee is nil when no tier applies.
+type tier struct {
+	fee          *types.Fee
+	maker, taker decimal.Float
+	expire       time.Time
+}
+
+// tierCache - The fee tiers resolved per user and pair. The tier depends on the trading volume of the trailing 30 days, whose sum
+// is too heavy to run for every fill inside the settlement, so the fills read the cached tier. An expired tier is never
+// served, it is resolved again, and the expired tiers of the other users are dropped whenever a tier is stored. The
+// cache is cleared by ResetTiers when the fee schedule changes, the generation tells a resolution that started before the
+// reset not to store its tier.
+type tierCache struct {
+	mutex      sync.Mutex
+	items      map[string]*tier
+	generation int64
+}
+
+// books - The in-memory order books of all pairs. The books are shared by every Service value of the package, because the
+// service is constructed in several places (gRPC server, admin services), while the matching state must exist only once.
+var (
+	books = orderbook.NewMarket()
+
+	// batch - the maximum number of orders that can be placed or cancelled with one batch request.
+	batch = 50
+
+	// clientIdPattern - the client order ids accepted by the placement, cancellation and lookup of orders.
+	clientIdPattern = regexp.MustCompile(`^[a-zA-Z0-9_.:\-]{1,64}$`)
+
+	// tiers - the fee tiers of the users, shared by every Service value of the package like the order books.
+	tiers = &tierCache{
+		items: make(map[string]*tier),
+	}
+
+	// tierTimeout - how long a resolved fee tier is used before it is resolved again.
+	tierTimeout = time.Minute
+
+	// reference - the unit in which the trading volume of the fee tiers and the margin accounts are measured.
+	reference = "usd"
+
+	// leverage - the maximum leverage of a margin account, the liabilities of the account may reach (leverage - 1) times its equity.
+	leverage = 3.0
+
+	// callLevel - the margin level below which the user of a margin account receives a margin call.
+	callLevel = 1.3
+
+	// liquidationLevel - the margin level below which an isolated margin wallet is liquidated.
+	liquidationLevel = 1.1
+)
+
+// Initialization - The code initializes a Service object and runs its concurrent functions: chain(), price(), market(), stop(), expire(), resume(), auction(), interest(), margin().
 func (a *Service) Initialization() {
 	go a.chain()
 	go a.price()
 	go a.market()
+	go a.stop()
+	go a.expire()
+	go a.resume()
+	go a.auction()
+	go a.interest()
+	go a.margin()
 }
 
 // queryRatio - This function is used to calculate the ratio of a given base and quote. It takes in two strings, base and quote, as
@@ -67,90 +130,170 @@ func (a *Service) queryPrice(base, quote string) (price float64, ok bool) {
 	return price, true
 }
 
-// querySum - The purpose of this code is to calculate the final value of a given value after subtracting fees. It queries the
-// database for the corresponding currency's fees_trade and fees_discount columns, and checks the status of an order
-// based on an id. If the order is a maker order, the discount is subtracted from the fees. Finally, the actual value
-// after subtracting fees and the rounded value after subtracting fees are returned.
-func (a *Service) querySum(id int64, symbol string, value float64) (b, f float64, m bool, err error) {
+// querySum - The purpose of this code is to calculate the final value of a given value after subtracting fees. The maker or
+// taker rate of the cached fee tier of the user on the pair of the order is applied, depending on whether the order was
+// resting in the book. When no fee schedule is configured, the fees_trade and fees_discount columns of the asset are used instead, the
+// discount being subtracted for a maker. Finally, the value after subtracting fees and the fees themselves are returned,
+// both computed as exact decimals.
+func (a *Service) querySum(order *types.Order, symbol string, value *decimal.Float, maker bool) (b, f *decimal.Float, err error) {
 
-	// The purpose of this code is to declare three variables of different types: d is a float64, m is a boolean, and s is a
-	// types.Status. This can be used to assign values to these variables and use them in your program.
+	// The purpose of this code is to declare the fee rate in percent r, and d, which stores the maker discount of the asset.
 	var (
-		d float64
-		s string
+		r, d decimal.Float
 	)
 
-	// This code is used to query a database for a particular record associated with the given symbol. It then scans the
-	// result and stores the values of the fees_trade and fees_discount columns in the variables fees and discount
-	// respectively. If an error occurs during the query, it returns the balance and fees variables.
-	if err := a.Context.Db.QueryRow("select fees_trade, fees_discount from assets where symbol = $1", symbol).Scan(&f, &d); err != nil {
-		return b, f, m, err
+	// The fee tier of the user is looked up first, its maker rate applies to the resting order of the trade and its
+	// taker rate to the incoming one.
+	item, err := a.queryTier(order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit())
+	if err != nil {
+		return b, f, err
+	}
+
+	if item.fee != nil {
+
+		r = item.taker
+		if maker {
+			r = item.maker
+		}
+
+	} else {
+
+		// This code is used to query a database for a particular record associated with the given symbol. It then scans the
+		// result and stores the values of the fees_trade and fees_discount columns in the variables fees and discount
+		// respectively. If an error occurs during the query, it returns the balance and fees variables.
+		if err := a.Context.Db.QueryRow("select fees_trade, fees_discount from assets where symbol = $1", symbol).Scan(&r, &d); err != nil {
+			return b, f, err
+		}
+
+		// This code is checking if the variable "maker" is true, and if it is, it is subtracting the value of "discount" from
+		// "fees" and storing the result in "fees" as a float. This is likely being done to calculate a discounted fee for a maker order.
+		if maker {
+			r = *r.Sub(&d)
+		}
+	}
+
+	// The fees are the rate in percent of the value, the rest of the value is what the user receives.
+	f = value.Mul(&r).Div(100)
+
+	return value.Sub(f), f, nil
+}
+
+// queryVolume - This function returns the trading volume of the user over the trailing 30 days, measured in the reference unit.
+// The value of every trade is its quantity multiplied by its price in the quote unit, converted with the price of the pair
+// of the quote unit against the reference unit. Trades whose quote unit has no such pair are not counted.
+func (a *Service) queryVolume(userId int64) (volume float64, err error) {
+
+	if err := a.Context.Db.QueryRow(`select coalesce(sum(t.quantity * t.price * case when t.quote_unit = $2 then 1 else coalesce((select p.price from pairs p where p.base_unit = t.quote_unit and p.quote_unit = $2 limit 1), 0) end), 0) from trades t where t.user_id = $1 and t.create_at > now() - interval '30 days'`, userId, reference).Scan(&volume); err != nil {
+		return volume, err
+	}
+
+	return volume, nil
+}
+
+// queryFee - This function returns the fee tier of the user on the pair together with the next tier and the trading volume of
+// the user. The tier is the one with the highest volume threshold that the volume of the user has reached. The tiers of the
+// pair override the global tiers (those without a base and quote unit) as a whole: when the pair has tiers of its own, the
+// global tiers are not used for it. The fee is nil when no tier applies.
+func (a *Service) queryFee(userId int64, base, quote string) (fee, next *types.Fee, volume float64, err error) {
+
+	volume, err = a.queryVolume(userId)
+	if err != nil {
+		return fee, next, volume, err
 	}
 
-	// The purpose of this code is to query a database for the status of an order based on the id and store the result in a
-	// variable. If there is an error with the query, an error is returned.
-	if err := a.Context.Db.QueryRow("select status from orders where id = $1;", id).Scan(&s); err != nil {
-		return b, f, m, err
+	// The tiers are read in the ascending order of their volume threshold, so the last tier reached is the tier of the
+	// user and the first one not reached is the next one.
+	rows, err := a.Context.Db.Query(`select id, level, volume, maker, taker, base_unit, quote_unit, create_at from fees where (base_unit = $1 and quote_unit = $2) or (base_unit = '' and quote_unit = '' and not exists (select id from fees where base_unit = $1 and quote_unit = $2)) order by volume`, base, quote)
+	if err != nil {
+		return fee, next, volume, err
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			item types.Fee
+		)
+
+		if err := rows.Scan(&item.Id, &item.Level, &item.Volume, &item.Maker, &item.Taker, &item.BaseUnit, &item.QuoteUnit, &item.CreateAt); err != nil {
+			return fee, next, volume, err
+		}
 
-	// This if statement assigns the boolean value true to the variable m if the variable s is equal to the constant
-	// types.Status_PENDING. This can be used to evaluate a condition or determine if a specific value is present in a given set.
-	if s == types.StatusPending {
-		m = true
+		if item.GetVolume() <= volume {
+			fee = &item
+		} else if next == nil {
+			next = &item
+		}
 	}
 
-	// This code is checking if the variable "maker" is true, and if it is, it is subtracting the value of "discount" from
-	// "fees" and storing the result in "fees" as a float. This is likely being done to calculate a discounted fee for a maker order.
-	if m {
-		f = decimal.New(f).Sub(d).Float()
+	if err := rows.Err(); err != nil {
+		return fee, next, volume, err
 	}
 
-	// This code is used to calculate the final value of a given value after subtracting fees. The two return values
-	// represent the actual value after subtracting fees and the rounded value after subtracting fees.
-	return decimal.New(value).Sub(decimal.New(decimal.New(value).Mul(f).Float()).Div(100).Float()).Float(), decimal.New(value).Sub(decimal.New(value).Sub(decimal.New(decimal.New(value).Mul(f).Float()).Div(100).Float()).Float()).Float(), m, nil
+	return fee, next, volume, nil
 }
 
-// queryMarket - This function is used to get the market price for a given base and quote currency. It takes in the base, quote,
-// assigning (buy/sell), and current price as parameters. It then gets the current price from the getPrice() function
-// and, depending on the assigning, queries the database for either the minimum or maximum price that is greater than or
-// less than the current price and is in the pending status. Finally, it returns the market price.
-func (a *Service) queryMarket(base, quote, _type string, assigning string, price float64) float64 {
+// queryTier - This function returns the cached fee tier of the user on the pair. A tier that was never resolved, or whose time
+// has expired, is resolved right away. The placement of an order resolves the tier of its user before the book is locked,
+// so the trading volume of the user is usually not summed while a fill holds the book and the balances locked.
+func (a *Service) queryTier(userId int64, base, quote string) (*tier, error) {
 
-	var (
-		ok bool
-	)
+	key := fmt.Sprintf("%v:%v:%v", userId, base, quote)
 
-	// This code is checking for the existence of a price by attempting to get it from e.queryPrice(), which takes in two
-	// parameters, base and quote. If the price exists (indicated by the ok return value), then it will be returned. If the
-	// price does not exist (indicated by the !ok return value), then it will not be returned.
-	if price, ok = a.queryPrice(base, quote); !ok {
-		return price
+	tiers.mutex.Lock()
+	item, ok := tiers.items[key]
+	if ok && time.Now().After(item.expire) {
+		delete(tiers.items, key)
+		ok = false
 	}
+	tiers.mutex.Unlock()
 
-	// The switch statement is used to evaluate an expression and determine which statement should be executed based on the
-	// value of the expression. The switch statement assigns the expression to a variable called assigning, which is then
-	// used to make the determination of which statement to execute.
-	switch assigning {
-	case types.AssigningBuy:
+	if ok {
+		return item, nil
+	}
 
-		// The purpose of this code is to query the database for the minimum price of a particular order that has a specific
-		// assigning, base unit, quote unit, price, and status. The result is then stored in the variable 'price'.
-		_ = a.Context.Db.QueryRow("select min(price) as price from orders where assigning = $1 and base_unit = $2 and quote_unit = $3 and price >= $4 and status = $5 and type = $6", types.AssigningSell, base, quote, price, types.StatusPending, _type).Scan(&price)
+	return a.writeTier(userId, base, quote)
+}
 
-	case types.AssigningSell:
+// writeTier - This function resolves the fee tier of the user on the pair with queryFee and stores it in the cache of the tiers.
+func (a *Service) writeTier(userId int64, base, quote string) (*tier, error) {
+
+	tiers.mutex.Lock()
+	generation := tiers.generation
+	tiers.mutex.Unlock()
+
+	fee, _, _, err := a.queryFee(userId, base, quote)
+	if err != nil {
+		return nil, err
+	}
+
+	item := tier{fee: fee, expire: time.Now().Add(tierTimeout)}
+
+	// The rates of the tier are read again as exact decimals, the fee carries them only as doubles.
+	if fee != nil {
+		if err := a.Context.Db.QueryRow("select maker, taker from fees where id = $1", fee.GetId()).Scan(&item.maker, &item.taker); err != nil {
+			return nil, err
+		}
+	}
 
-		// The purpose of this code is to query a database for the maximum price from orders that meet certain criteria
-		// (assigning, base unit, quote unit, price and status) and scan the result into the variable "price".
-		_ = a.Context.Db.QueryRow("select max(price) as price from orders where assigning = $1 and base_unit = $2 and quote_unit = $3 and price <= $4 and status = $5 and type = $6", types.AssigningBuy, base, quote, price, types.StatusPending, _type).Scan(&price)
+	tiers.mutex.Lock()
+	for key, expired := range tiers.items {
+		if time.Now().After(expired.expire) {
+			delete(tiers.items, key)
+		}
+	}
+	if generation == tiers.generation {
+		tiers.items[fmt.Sprintf("%v:%v:%v", userId, base, quote)] = &item
 	}
+	tiers.mutex.Unlock()
 
-	return price
+	return &item, nil
 }
 
 // queryRange - This function is used to retrieve the minimum and maximum trade value of a given currency symbol from a database and
 // to check if a given value is within the range. If the given value is within the range, it will return the min and max
 // trade values, as well as a boolean value indicating whether the given value is within the range.
-func (a *Service) queryRange(symbol string, value float64) (min, max float64, ok bool) {
+func (a *Service) queryRange(symbol string, value *decimal.Float) (min, max decimal.Float, ok bool) {
 
 	// This if statement is used to query a database for a row containing the min_trade and max_trade columns for the
 	// currency with the symbol given as an argument. If the query is successful, the values for min_trade and max_trade are
@@ -160,8 +303,9 @@ func (a *Service) queryRange(symbol string, value float64) (min, max float64, ok
 	}
 
 	// This statement is checking to see if a given value is within a minimum and maximum range. If the value is between the
-	// min and max values, then the function returns the min and max values, along with a boolean value of true.
-	if value >= min && value <= max {
+	// min and max values, then the function returns the min and max values, along with a boolean value of true. The
+	// comparison is made between exact decimals.
+	if !value.LessThan(min.Decimal) && !value.GreaterThan(max.Decimal) {
 		return min, max, true
 	}
 
@@ -204,13 +348,23 @@ func (a *Service) queryQuantity(assigning string, quantity, price float64, cross
 // before it is submitted, such as checking the price is not 0, checking the user has enough funds to cover the order,
 // and ensuring the quantity of the order is within the predetermined range. If any of these checks fail, an error is
 // returned. Otherwise, the order is accepted and the quantity is returned.
-func (a *Service) queryValidateOrder(order *types.Order) (summary float64, err error) {
+func (a *Service) queryValidateOrder(order *types.Order) (summary *decimal.Float, err error) {
 
 	// This code checks if the order's price is 0, and if it is, it returns an error message (65790) with the impossible
 	// price that is being requested. This helps to identify errors in the order's price and allows for more accurate
 	// debugging of the program.
 	if order.GetPrice() == 0 {
-		return 0, status.Errorf(65790, "impossible price %v", order.GetPrice())
+		return nil, status.Errorf(65790, "impossible price %v", order.GetPrice())
+	}
+
+	// The price, the quantity and the notional value of the order have to follow the trading rules of the pair.
+	if err := a.queryValidateRules(order); err != nil {
+		return nil, err
+	}
+
+	// No order is accepted while the pair is halted, and the price has to be within the price band of the pair.
+	if err := a.queryValidateBand(order); err != nil {
+		return nil, err
 	}
 
 	// This switch statement is used to check the value of the GetAssigning() method on the order object. Depending on the
@@ -221,27 +375,27 @@ func (a *Service) queryValidateOrder(order *types.Order) (summary float64, err e
 		// The purpose of this code is to calculate the total cost of an order, given the quantity and price of a product. The
 		// code uses the decimal library to get the quantity and price of the order, and then multiplies them together to
 		// calculate the total cost.
-		quantity := decimal.New(order.GetQuantity()).Mul(order.GetPrice()).Float()
+		quantity := a.queryExact(order.GetQuantityDecimal(), order.GetQuantity()).Mul(a.queryExact(order.GetPriceDecimal(), order.GetPrice()))
 
 		// This code is checking the range of a given quantity, and returning an error if the quantity is not within the
 		// specified range. The min and max variables represent the minimum and maximum values of the quantity, while the ok
 		// variable indicates whether the range is valid. If the range is invalid, the code will return an error with a message
 		// containing the minimum and maximum values.
 		if min, max, ok := a.queryRange(order.GetQuoteUnit(), quantity); !ok {
-			return 0, status.Errorf(11623, "[quote]: minimum trading amount: %v~%v, maximum trading amount: %v", min, strconv.FormatFloat(decimal.New(min).Mul(2).Float(), 'f', -1, 64), strconv.FormatFloat(max, 'f', -1, 64))
+			return nil, status.Errorf(11623, "[quote]: minimum trading amount: %v~%v, maximum trading amount: %v", min.String(), min.Mul(2).String(), max.String())
 		}
 
 		// This line of code is used to get the balance of the user in a given quote unit. It is used to determine the amount
 		// of funds available to the user for a particular order. The getBalance() method takes in two parameters, the quote
 		// unit and the user id, and returns the balance for the user in the specified quote unit.
-		balance := a.QueryBalance(order.GetQuoteUnit(), order.GetType(), order.GetUserId())
+		balance := a.queryBalance(order.GetQuoteUnit(), a.queryWallet(order), order.GetUserId())
 
 		// This statement is an if-statement that is used to check if the quantity is greater than the balance or if the
 		// order's quantity is equal to 0. If either of these conditions are true, then the statement will return a value of 0,
 		// along with an error message. The purpose of this statement is to ensure that a user does not place an order with
 		// insufficient funds and to inform them if they have attempted to do so.
-		if quantity > balance || order.GetQuantity() == 0 {
-			return 0, status.Error(11586, "[quote]: there is not enough funds on your asset balance to place an order")
+		if quantity.GreaterThan(balance.Decimal) || !quantity.IsPositive() {
+			return nil, status.Error(11586, "[quote]: there is not enough funds on your asset balance to place an order")
 		}
 
 		return quantity, nil
@@ -249,30 +403,201 @@ func (a *Service) queryValidateOrder(order *types.Order) (summary float64, err e
 	case types.AssigningSell:
 
 		// This statement retrieves the quantity of an order from the order object and assigns it to the variable "quantity".
-		quantity := order.GetQuantity()
+		quantity := a.queryExact(order.GetQuantityDecimal(), order.GetQuantity())
 
 		// This code is checking to see if the order's base unit and quantity meet a certain minimum and maximum trading
 		// amount. If the order does not meet the requirements, an error is returned with a message that states the minimum and
 		// maximum trading amounts.
-		if min, max, ok := a.queryRange(order.GetBaseUnit(), order.GetQuantity()); !ok {
-			return 0, status.Errorf(11587, "[base]: minimum trading amount: %v~%v, maximum trading amount: %v", min, strconv.FormatFloat(decimal.New(min).Mul(2).Float(), 'f', -1, 64), strconv.FormatFloat(max, 'f', -1, 64))
+		if min, max, ok := a.queryRange(order.GetBaseUnit(), quantity); !ok {
+			return nil, status.Errorf(11587, "[base]: minimum trading amount: %v~%v, maximum trading amount: %v", min.String(), min.Mul(2).String(), max.String())
 		}
 
 		// The purpose of this code is to get the balance of the user from the order object. The order object contains the base
 		// unit and the userId of the user, which are used to call the getBalance() method of the e object to get the balance.
-		balance := a.QueryBalance(order.GetBaseUnit(), order.GetType(), order.GetUserId())
+		balance := a.queryBalance(order.GetBaseUnit(), a.queryWallet(order), order.GetUserId())
 
 		// This code is testing whether the quantity of an order is greater than the balance of the asset used to place the
 		// order. If the quantity is greater than the balance or the order quantity is 0, it will return an error message
 		// indicating that there is not enough funds on the asset balance to place the order.
-		if quantity > balance || order.GetQuantity() == 0 {
-			return 0, status.Error(11624, "[base]: there is not enough funds on your asset balance to place an order")
+		if quantity.GreaterThan(balance.Decimal) || !quantity.IsPositive() {
+			return nil, status.Error(11624, "[base]: there is not enough funds on your asset balance to place an order")
 		}
 
 		return quantity, nil
 	}
 
-	return 0, status.Error(11596, "invalid input parameter")
+	return nil, status.Error(11596, "invalid input parameter")
+}
+
+// queryRules - This function returns the trading rules of the pair of the order: its tick size, its step size and its minimum
+// and maximum notional. A tick or step size of zero falls back to the decimals of the pair.
+func (a *Service) queryRules(order *types.Order) (tick, step, min, max float64, err error) {
+
+	var (
+		baseDecimal, quoteDecimal int32
+		_type                     = order.GetType()
+	)
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	if err := a.Context.Db.QueryRow("select tick_size, step_size, min_notional, max_notional, base_decimal, quote_decimal from pairs where base_unit = $1 and quote_unit = $2 and type = $3", order.GetBaseUnit(), order.GetQuoteUnit(), _type).Scan(&tick, &step, &min, &max, &baseDecimal, &quoteDecimal); err != nil {
+		return tick, step, min, max, status.Errorf(11585, "this pair %v-%v does not exist", order.GetBaseUnit(), order.GetQuoteUnit())
+	}
+
+	if tick == 0 {
+		tick = math.Pow10(-int(quoteDecimal))
+	}
+
+	if step == 0 {
+		step = math.Pow10(-int(baseDecimal))
+	}
+
+	return tick, step, min, max, nil
+}
+
+// queryValidateRules - This function checks the order against the trading rules of its pair: the price has to be a multiple of
+// the tick size, the quantity a multiple of the step size, and the notional value (price multiplied by quantity) has to be
+// within the minimum and maximum notional. A notional limit of zero is not checked. The price of a market order is the
+// average price of the levels that sweep walked, which is not a price of the book, so it is not checked against the tick
+// size. The quantity of a market buy order was converted from its budget into base units and rounded down to the step
+// size by sweep, so the step rule applies to every order.
+func (a *Service) queryValidateRules(order *types.Order) error {
+
+	tick, step, min, max, err := a.queryRules(order)
+	if err != nil {
+		return err
+	}
+
+	// The exact price and quantity of the order are checked, the doubles of the order are only used when no decimal string was given.
+	price, quantity := a.queryExact(order.GetPriceDecimal(), order.GetPrice()), a.queryExact(order.GetQuantityDecimal(), order.GetQuantity())
+
+	if order.GetTrading() != types.TradingMarket && !price.Mod(decimal.New(tick).Decimal).IsZero() {
+		return status.Errorf(11657, "the price %v must be a multiple of the tick size %v", price.String(), tick)
+	}
+
+	if !quantity.Mod(decimal.New(step).Decimal).IsZero() {
+		return status.Errorf(11658, "the quantity %v must be a multiple of the step size %v", quantity.String(), step)
+	}
+
+	notional := quantity.Mul(price)
+	if min > 0 && notional.LessThan(decimal.New(min).Decimal) {
+		return status.Errorf(11659, "the notional value %v of the order is below the minimum notional %v", notional.String(), min)
+	}
+
+	if max > 0 && notional.GreaterThan(decimal.New(max).Decimal) {
+		return status.Errorf(11660, "the notional value %v of the order is above the maximum notional %v", notional.String(), max)
+	}
+
+	return nil
+}
+
+// queryBand - This function returns the reference price of the pair, the width of its price band in percent and whether the
+// trading of the pair is halted.
+func (a *Service) queryBand(base, quote, _type string) (price, band float64, halted bool, err error) {
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	if err := a.Context.Db.QueryRow("select price, price_band, halted from pairs where base_unit = $1 and quote_unit = $2 and type = $3", base, quote, _type).Scan(&price, &band, &halted); err != nil {
+		return price, band, halted, status.Errorf(11585, "this pair %v-%v does not exist", base, quote)
+	}
+
+	return price, band, halted, nil
+}
+
+// queryValidateBand - This function rejects the order when the trading of its pair is halted, or when its price deviates from
+// the reference price of the pair (pairs.price) by more than the price band. A band of zero is not checked. A market order
+// was priced by the book within the band already, so only its halt is checked.
+func (a *Service) queryValidateBand(order *types.Order) error {
+
+	price, band, halted, err := a.queryBand(order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType())
+	if err != nil {
+		return err
+	}
+
+	if halted {
+		return status.Errorf(11661, "the trading of the pair %v-%v is halted", order.GetBaseUnit(), order.GetQuoteUnit())
+	}
+
+	if band > 0 && price > 0 && order.GetTrading() != types.TradingMarket {
+		if deviation := math.Abs(decimal.New(order.GetPrice()).Sub(price).Div(price).Mul(100).Float()); deviation > band {
+			return status.Errorf(11662, "the price %v is outside the price band of %v%% around the reference price %v", order.GetPrice(), band, price)
+		}
+	}
+
+	return nil
+}
+
+// WriteHalt - This function halts or resumes the trading of the pair and publishes the change of its state to the pair/halt or
+// pair/resume channel. A halt with a cool-down is resumed automatically when the cool-down has passed, a halt without
+// one lasts until it is resumed by an administrator. A pair with an auction duration is resumed with a call auction.
+// Nothing is published when the pair already was in the requested state.
+func (a *Service) WriteHalt(base, quote, _type string, halted bool, cooldown int32) error {
+
+	var (
+		pair types.Pair
+	)
+
+	// A resumed pair with an auction duration reopens with a call auction, a halt stops a running auction.
+	if err := a.Context.Db.QueryRow("update pairs set halted = $4, resume_at = case when $4 and $5 > 0 then now() + make_interval(secs => $5) end, auction_end = case when not $4 and auction > 0 then now() + make_interval(secs => auction) end where base_unit = $1 and quote_unit = $2 and type = $3 and halted <> $4 returning id, base_unit, quote_unit, type, halted, coalesce(resume_at::text, ''), coalesce(auction_end::text, '')", base, quote, _type, halted, cooldown).Scan(&pair.Id, &pair.BaseUnit, &pair.QuoteUnit, &pair.Type, &pair.Halted, &pair.ResumeAt, &pair.AuctionEnd); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	if halted {
+		return a.Context.Publish(&pair, "exchange", "pair/halt")
+	}
+
+	return a.Context.Publish(&pair, "exchange", "pair/resume")
+}
+
+// queryAuction - reports whether the pair is in the phase of a call auction, in which the orders are only collected in the book.
+func (a *Service) queryAuction(base, quote, _type string) (auction bool) {
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	_ = a.Context.Db.QueryRow("select auction_end is not null from pairs where base_unit = $1 and quote_unit = $2 and type = $3", base, quote, _type).Scan(&auction)
+
+	return auction
+}
+
+// breaker - This function is the circuit breaker of the pair. When the trade price of the pair moved by more than the halt change
+// in percent within the halt window (the lowest and the highest trade price of the window are compared), the trading of
+// the pair is halted for the halt cool-down. A halt change or window of zero disables the breaker.
+func (a *Service) breaker(base, quote, _type string) {
+
+	var (
+		change           float64
+		window, cooldown int32
+		high, low        float64
+	)
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	if err := a.Context.Db.QueryRow("select halt_change, halt_window, halt_cooldown from pairs where base_unit = $1 and quote_unit = $2 and type = $3 and not halted", base, quote, _type).Scan(&change, &window, &cooldown); err != nil || change == 0 || window == 0 {
+		return
+	}
+
+	if err := a.Context.Db.QueryRow("select coalesce(max(price), 0), coalesce(min(price), 0) from trades where base_unit = $1 and quote_unit = $2 and create_at > now() - make_interval(secs => $3)", base, quote, window).Scan(&high, &low); a.Context.Debug(err) || low == 0 {
+		return
+	}
+
+	if decimal.New(high).Sub(low).Div(low).Mul(100).Float() > change {
+		a.Context.Debug(a.WriteHalt(base, quote, _type, true, cooldown))
+	}
 }
 
 // queryValidatePair - checks if a pair with given base and quote unit, and type exists in the DB. If not, an error is returned.
@@ -283,8 +608,8 @@ func (a *Service) queryValidatePair(base, quote, _type string) error {
 		exist bool
 	)
 
-	// Convert TypeCross to TypeSpot to ensure the correct type is used.
-	if _type == types.TypeCross {
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
 		_type = types.TypeSpot
 	}
 
@@ -296,22 +621,191 @@ func (a *Service) queryValidatePair(base, quote, _type string) error {
 	return nil
 }
 
+// queryDecimals - This function returns the number of decimal places of the base and the quote unit of the pair, the base
+// decimals are used for quantities and the quote decimals for prices. Cross pairs are stored as spot pairs.
+func (a *Service) queryDecimals(base, quote, _type string) (baseDecimal, quoteDecimal int32, err error) {
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	// This code reads the decimals of the pair, a pair that does not exist is reported with the same error as in queryValidatePair.
+	if err := a.Context.Db.QueryRow("select base_decimal, quote_decimal from pairs where base_unit = $1 and quote_unit = $2 and type = $3", base, quote, _type).Scan(&baseDecimal, &quoteDecimal); err != nil {
+		return baseDecimal, quoteDecimal, status.Errorf(11585, "this pair %v-%v does not exist", base, quote)
+	}
+
+	return baseDecimal, quoteDecimal, nil
+}
+
 // queryOrder - This function is used to retrieve an order from a database by its ID. It takes an int64 (id) as a parameter and
 // returns a pointer to a "types.Order" type. It uses the "QueryRow" method of the database to scan the selected row
 // into the "order" variable and then returns the pointer to the order.
 func (a *Service) queryOrder(id int64) *types.Order {
 
+	// The amounts are scanned as exact decimals, the order carries them both as doubles and as decimal strings.
 	var (
-		order types.Order
+		order                  types.Order
+		value, quantity, price decimal.Float
 	)
 
 	// This code is used to query a database for a single row of data matching the specified criteria (in this case, the "id
 	// = $1" condition) and then assign the returned values to the specified variables (in this case, the fields of the
 	// "order" struct). This allows the program to retrieve data from the database and store it in a convenient and organized format.
-	_ = a.Context.Db.QueryRow("select id, value, quantity, price, assigning, user_id, base_unit, quote_unit, status, create_at from orders where id = $1", id).Scan(&order.Id, &order.Value, &order.Quantity, &order.Price, &order.Assigning, &order.UserId, &order.BaseUnit, &order.QuoteUnit, &order.Status, &order.CreateAt)
+	_ = a.Context.Db.QueryRow("select id, value, quantity, price, trigger_price, assigning, user_id, base_unit, quote_unit, type, trading, status, coalesce(client_id, ''), prevention, create_at from orders where id = $1", id).Scan(&order.Id, &value, &quantity, &price, &order.TriggerPrice, &order.Assigning, &order.UserId, &order.BaseUnit, &order.QuoteUnit, &order.Type, &order.Trading, &order.Status, &order.ClientId, &order.Prevention, &order.CreateAt)
+	order.Value, order.ValueDecimal = value.Float(), value.String()
+	order.Quantity, order.QuantityDecimal = quantity.Float(), quantity.String()
+	order.Price, order.PriceDecimal = price.Float(), price.String()
+
 	return &order
 }
 
+// queryClientOrder - This function returns the order of the user with the given client order id, the boolean reports whether
+// such an order exists.
+func (a *Service) queryClientOrder(userId int64, clientId string) (*types.Order, bool) {
+
+	var (
+		id int64
+	)
+
+	if err := a.Context.Db.QueryRow("select id from orders where user_id = $1 and client_id = $2", userId, clientId).Scan(&id); err != nil {
+		return nil, false
+	}
+
+	return a.queryOrder(id), true
+}
+
+// queryValidateClientId - checks that a client order id is at most 64 letters, digits, dashes, underscores, dots or colons long.
+func (a *Service) queryValidateClientId(clientId string) error {
+	if !clientIdPattern.MatchString(clientId) {
+		return status.Error(11652, "the client order id must be 1 to 64 letters, digits or the characters _ . : -")
+	}
+	return nil
+}
+
+// queryGroup - This function returns the other legs of the order group (one-cancels-other) of the order that are still pending
+// or dormant. The group is kept in the extra column of the orders, an order that is not part of a group has no legs.
+func (a *Service) queryGroup(id int64) (orders []*types.Order) {
+
+	rows, err := a.Context.Db.Query(`select id, value, price, assigning, base_unit, quote_unit, user_id, type, status from orders where extra->>'group' = (select extra->>'group' from orders where id = $1) and id != $1 and (status = $2 or status = $3) order by id`, id, types.StatusPending, types.StatusDormant)
+	if a.Context.Debug(err) {
+		return orders
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			item types.Order
+		)
+
+		if err := rows.Scan(&item.Id, &item.Value, &item.Price, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.UserId, &item.Type, &item.Status); a.Context.Debug(err) {
+			return orders
+		}
+
+		orders = append(orders, &item)
+	}
+
+	return orders
+}
+
+// queryLastPrice - This function returns the price of the last trade of the pair recorded in the ohlcv table.
+func (a *Service) queryLastPrice(base, quote string) (price float64, ok bool) {
+
+	if err := a.Context.Db.QueryRow("select price from ohlcv where base_unit = $1 and quote_unit = $2 order by id desc limit 1", base, quote).Scan(&price); err != nil {
+		return price, false
+	}
+
+	return price, true
+}
+
+// queryBook - This function returns the in-memory order book of the pair with its mutex locked. When the book is not loaded
+// yet, all pending orders of the pair are read from the database ordered by id, so that the time priority of the resting
+// orders is the same as the order in which they were placed. The orders of cross and isolated margin accounts trade in the
+// book of the spot pair together with the spot orders. The caller must unlock the book.
+func (a *Service) queryBook(base, quote, _type string) (*orderbook.Book, error) {
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	return books.Acquire(base, quote, _type, func(book *orderbook.Book) error {
+
+		// This query selects every resting order of the pair, the orders are added to the book one by one in the order of
+		// their placement. The book splits the open value of an iceberg order into its display slice and hidden quantity.
+		// The owner of the user is read with the order, so that the orders of linked accounts are recognised by the book.
+		rows, err := a.Context.Db.Query(`select o.id, o.assigning, o.price, o.value, o.display, o.user_id, coalesce(l.owner_id, 0), o.prevention from orders o left join links l on l.user_id = o.user_id where o.base_unit = $1 and o.quote_unit = $2 and (o.type = $3 or ($3 = $5 and o.type = any($6))) and o.status = $4 order by o.id`, base, quote, _type, types.StatusPending, types.TypeSpot, pq.Array([]string{types.TypeCross, types.TypeIsolated}))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+
+			var (
+				item orderbook.Order
+			)
+
+			if err := rows.Scan(&item.Id, &item.Assigning, &item.Price, &item.Value, &item.Display, &item.UserId, &item.Owner, &item.Prevention); err != nil {
+				return err
+			}
+
+			book.Add(&item)
+		}
+
+		return rows.Err()
+	})
+}
+
+// queryOwner - This function returns the account that the orders of the user are linked to and the default self-trade prevention
+// mode of the account. The owner is zero when the user is not linked, the book then takes the user itself as the owner,
+// and an account without a mode of its own cancels the newest order.
+func (a *Service) queryOwner(userId int64) (owner int64, prevention string) {
+
+	_ = a.Context.Db.QueryRow("select owner_id, prevention from links where user_id = $1", userId).Scan(&owner, &prevention)
+	if len(prevention) == 0 {
+		prevention = types.PreventionCancelNewest
+	}
+
+	return owner, prevention
+}
+
+// queryTaker - This function converts the incoming order into its in-memory representation, the value is the quantity that is
+// still open. The owner of the user is resolved, and an order without a self-trade prevention mode of its own takes the
+// mode of the account, the mode is stored in the order as well.
+func (a *Service) queryTaker(order *types.Order) *orderbook.Order {
+
+	owner, prevention := a.queryOwner(order.GetUserId())
+	if len(order.GetPrevention()) == 0 {
+		order.Prevention = prevention
+	}
+
+	return &orderbook.Order{
+		Id:         order.GetId(),
+		UserId:     order.GetUserId(),
+		Owner:      owner,
+		Assigning:  order.GetAssigning(),
+		Price:      *a.queryExact(order.GetPriceDecimal(), order.GetPrice()),
+		Value:      *a.queryExact(order.GetValueDecimal(), order.GetValue()),
+		Display:    *decimal.New(order.GetDisplay()),
+		Prevention: order.GetPrevention(),
+	}
+}
+
+// queryExact - This function returns the exact decimal string of a field of an order, the double of the field is used when the
+// string is not set or not a decimal number, as for the orders written before the strings were kept.
+func (a *Service) queryExact(exact string, value float64) *decimal.Float {
+
+	if len(exact) > 0 {
+		if number, err := decimal.Parse(exact); err == nil {
+			return number
+		}
+	}
+
+	return decimal.New(value)
+}
+
 // writeAsset - This function is used to set a new asset for a given user. It takes in three parameters - a string symbol to identify
 // the asset, an int64 userId to identify the user, and a boolean error indicating whether an error should be returned if
 // the asset already exists. The function checks if the asset already exists in the database, and if it does not exist,
@@ -352,75 +846,133 @@ func (a *Service) writeAsset(symbol, _type string, userId int64, error bool) err
 }
 
 // writeOrder - This function is used to set an order in the database. It takes in a pointer to a types.Order which contains the
-// order's details, and inserts the data into the 'orders' table. It then returns the id of the newly created order and any potential errors.
-func (a *Service) writeOrder(order *types.Order) (id int64, err error) {
+// order's details, and inserts the data into the 'orders' table inside the given transaction. It then returns the id of
+// the newly created order and any potential errors. An order that already has an id is a triggered stop order, its
+// dormant row is activated instead of inserting a new one.
+func (a *Service) writeOrder(tx *sql.Tx, order *types.Order) (id int64, err error) {
+
+	// The dormant row is only activated while it is still dormant, a stop order that was cancelled in the meantime is not
+	// placed anymore.
+	if order.GetId() > 0 {
+		if err := tx.QueryRow("update orders set price = $2, value = $3, quantity = $4, trading = $5, status = $6 where id = $1 and status = $7 returning id", order.GetId(), a.queryExact(order.GetPriceDecimal(), order.GetPrice()).String(), a.queryExact(order.GetQuantityDecimal(), order.GetQuantity()).String(), a.queryExact(order.GetValueDecimal(), order.GetValue()).String(), order.GetTrading(), order.GetStatus(), types.StatusDormant).Scan(&id); err != nil {
+			return id, status.Error(11538, "the requested order does not exist")
+		}
+
+		return id, nil
+	}
 
-	if err := a.Context.Db.QueryRow("insert into orders (assigning, base_unit, quote_unit, price, value, quantity, user_id, type, trading) values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id", order.GetAssigning(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetPrice(), order.GetQuantity(), order.GetValue(), order.GetUserId(), order.GetType(), order.GetTrading()).Scan(&id); err != nil {
+	if err := tx.QueryRow("insert into orders (assigning, base_unit, quote_unit, price, value, quantity, user_id, type, trading, status, trigger_price, time_in_force, post_only, expire_at, display, extra, client_id, prevention) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, nullif($14, '')::timestamp with time zone, $15, jsonb_strip_nulls(jsonb_build_object('group', nullif($16, ''))), nullif($17, ''), coalesce(nullif($18, ''), $19)) returning id", order.GetAssigning(), order.GetBaseUnit(), order.GetQuoteUnit(), a.queryExact(order.GetPriceDecimal(), order.GetPrice()).String(), a.queryExact(order.GetQuantityDecimal(), order.GetQuantity()).String(), a.queryExact(order.GetValueDecimal(), order.GetValue()).String(), order.GetUserId(), order.GetType(), order.GetTrading(), order.GetStatus(), order.GetTriggerPrice(), order.GetTimeInForce(), order.GetPostOnly(), order.GetExpireAt(), order.GetDisplay(), order.GetGroup(), order.GetClientId(), order.GetPrevention(), types.PreventionCancelNewest).Scan(&id); err != nil {
 		return id, err
 	}
 
 	return id, nil
 }
 
+// writeCancel - This function sets the status of the order to cancel inside the given transaction, as long as the order still
+// has the status it was read with. The open value of the order is read back in the same statement, because a pending
+// order could have been partially filled in the meantime, and the reserved balance of the open value is returned to
+// the user. A dormant stop order has no reserved balance, only its status is changed. The event is either a cancellation
+// or an expiry, it is written to the journal first.
+func (a *Service) writeCancel(tx *sql.Tx, order *types.Order, event string) error {
+
+	// The open value is read as an exact decimal, the returned balance is computed from it without a round trip through float64.
+	var (
+		value decimal.Float
+	)
+
+	if err := tx.QueryRow("update orders set status = $2 where id = $1 and status = $3 returning value;", order.GetId(), types.StatusCancel, order.GetStatus()).Scan(&value); err != nil {
+		return status.Error(11538, "the requested order does not exist")
+	}
+	order.Value, order.ValueDecimal = value.Float(), value.String()
+
+	// The cancellation is journaled with the open value returned by the update, the order could have been read before the
+	// book was locked and partially filled since.
+	journal, err := a.writeJournal(tx, event, order, 0, decimal.New(order.GetPrice()), &value)
+	if err != nil {
+		return err
+	}
+
+	if order.GetStatus() == types.StatusPending {
+
+		// The switch statement is used to compare the value of a variable (in this case, order.Assigning) to a list of possible
+		// values. If the value matches one of the values in the list, a specific action will be executed.
+		switch order.GetAssigning() {
+		case types.AssigningBuy:
+
+			// This code is setting the balance of a user for a given order. It is using the order's quote unit, user id, value and
+			// price to calculate the returned balance and then updating the balance using the types.BalancePlus parameter.
+			if err := a.writeBalance(tx, order.GetQuoteUnit(), a.queryWallet(order), order.GetUserId(), value.Mul(order.GetPrice()), types.BalancePlus, journal); err != nil {
+				return err
+			}
+
+			break
+		case types.AssigningSell:
+
+			// This code is used to return the open value of the order to the balance of the user in the base unit.
+			if err := a.writeBalance(tx, order.GetBaseUnit(), a.queryWallet(order), order.GetUserId(), &value, types.BalancePlus, journal); err != nil {
+				return err
+			}
+
+			break
+		}
+	}
+
+	order.Status = types.StatusCancel
+
+	return nil
+}
+
 // writeTrade - The purpose of this code is to set a trade by converting a given value to a decimal number multiplied by a given
-// price, get the sum of a given order, symbol, and value, insert the data into a database, update the "fees_charges"
-// column in the "currencies" table in a database, and publish a particular order to an exchange.
-func (a *Service) writeTrade(id int64, symbol string, value, price float64, convert bool) (float64, error) {
+// price, get the sum of a given order, symbol, and value, insert the data into a database and update the "fees_charges"
+// column in the "currencies" table in a database, both inside the given transaction. The maker flag tells whether the
+// order was resting in the book when the trade happened.
+func (a *Service) writeTrade(tx *sql.Tx, id int64, symbol string, value, price *decimal.Float, convert, maker bool) (*decimal.Float, error) {
 
 	// The purpose of this code is to retrieve an order from a database, given its ID. The variable 'order' will store the
 	// order object that is returned from the queryOrder() method.
 	order := a.queryOrder(id)
-	order.Value = value
+	order.Value, order.ValueDecimal = value.Float(), value.String()
 
-	// This code is used to convert a given value to a decimal number multiplied by a given price. The result is then stored
-	// as a floating point number. This is likely used for some kind of financial calculation or to convert a given value to
-	// a currency amount.
+	// This code is used to convert a given value to a decimal number multiplied by a given price, the amount received by
+	// the seller is given in the quote unit. The amount stays an exact decimal for the rest of the settlement.
+	amount := value
 	if convert {
-		value = decimal.New(value).Mul(price).Float()
+		amount = amount.Mul(price)
 	}
 
 	// This code is attempting to get the sum of a given order, symbol and value. The variables s and f are used to store
-	// the sum and any error encountered, respectively. The if statement checks for any errors that may have occurred and
-	// returns 0 and the error if one is encountered.
-	s, f, maker, err := a.querySum(id, symbol, value)
+	// the sum and the fees, the if statement checks for any errors that may have occurred and returns the error if one is encountered.
+	s, f, err := a.querySum(order, symbol, amount, maker)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	// This code is used to calculate the fee for an order based on the assigned type. If the order is assigned to be a
 	// SELL, the fee is calculated by dividing the fee (f) by the price. If the order is assigned to be something else, the
 	// fee is simply set to be f.
+	fees := f
 	if order.GetAssigning() == types.AssigningSell {
-		order.Fees = decimal.New(f).Div(price).Float()
-	} else {
-		order.Fees = f
+		fees = f.Div(price)
 	}
+	order.Fees, order.FeesDecimal = fees.Float(), fees.String()
 
 	// This code is used to insert data into the "transfers" table in a database using the parameters provided in the array
 	// "param". The code first checks for any errors in the insertion process, and if there are any, it will return an error.
-	if _, err := a.Context.Db.Exec(`insert into trades (order_id, assigning, user_id, base_unit, quote_unit, quantity, fees, price, maker) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, order.GetId(), order.GetAssigning(), order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), order.GetValue(), order.GetFees(), price, maker); err != nil {
-		return 0, err
+	if _, err := tx.Exec(`insert into trades (order_id, assigning, user_id, base_unit, quote_unit, quantity, fees, price, maker, type) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, order.GetId(), order.GetAssigning(), order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit(), value.String(), order.GetFeesDecimal(), price.String(), maker, order.GetType()); err != nil {
+		return nil, err
 	}
 
-	// This statement is checking to see if the value of the parameter at index i in the param array is greater than 0. If
-	// it is, then the code within the if statement will be executed. This is likely being used to check if a fee is
-	// associated with the parameter at index i.
-	if f > 0 {
+	// This statement is checking to see if the fees of the trade are greater than 0, only then the fees are collected.
+	if f.IsPositive() {
 
 		// This code is updating the "fees_charges" column in the "currencies" table in a database. The "symbol" and
 		// "fee" are parameters that are passed into the statement. If an error occurs during the
 		// execution of the statement, the function will return the error.
-		if _, err := a.Context.Db.Exec("update assets set fees_charges = fees_charges + $2 where symbol = $1;", symbol, f); err != nil {
-			return 0, err
+		if _, err := tx.Exec("update assets set fees_charges = fees_charges + $2 where symbol = $1;", symbol, f.String()); err != nil {
+			return nil, err
 		}
 	}
 
-	// The purpose of the code snippet is to publish a particular order to an exchange with the routing key "order/status".
-	// The if statement checks for any errors encountered while publishing the order, and returns an error if one occurs.
-	if err := a.Context.Publish(a.queryOrder(order.GetId()), "exchange", "order/status"); err != nil {
-		return 0, err
-	}
-
 	return s, nil
 }
 
@@ -449,13 +1001,26 @@ func (a *Service) QueryPair(id int64, _type string, status bool) (*types.Pair, e
 	// This code is used to query a database and retrieve information about a pair with a specified id. The query is formed
 	// using the fmt.Sprintf() function, and it is a combination of a string and the id parameter. The retrieved information
 	// is then assigned to the chain struct. Finally, the code returns the chain struct and an error if it fails.
-	if err := a.Context.Db.QueryRow(fmt.Sprintf("select id, base_unit, quote_unit, price, base_decimal, quote_decimal, status from pairs where id = %[1]d %[2]s", id, strings.Join(maps, " "))).Scan(
+	if err := a.Context.Db.QueryRow(fmt.Sprintf("select id, base_unit, quote_unit, price, base_decimal, quote_decimal, tick_size, step_size, min_notional, max_notional, price_band, halt_change, halt_window, halt_cooldown, halted, coalesce(resume_at::text, ''), auction, coalesce(auction_end::text, ''), type, status from pairs where id = %[1]d %[2]s", id, strings.Join(maps, " "))).Scan(
 		&chain.Id,
 		&chain.BaseUnit,
 		&chain.QuoteUnit,
 		&chain.Price,
 		&chain.BaseDecimal,
 		&chain.QuoteDecimal,
+		&chain.TickSize,
+		&chain.StepSize,
+		&chain.MinNotional,
+		&chain.MaxNotional,
+		&chain.PriceBand,
+		&chain.HaltChange,
+		&chain.HaltWindow,
+		&chain.HaltCooldown,
+		&chain.Halted,
+		&chain.ResumeAt,
+		&chain.Auction,
+		&chain.AuctionEnd,
+		&chain.Type,
 		&chain.Status,
 	); err != nil {
 		return &chain, err
@@ -479,11 +1044,20 @@ func (a *Service) QueryAddress(userId int64, platform string) (address string) {
 // QueryBalance - This function is used to query the balance of a user's assets by symbol. It takes a symbol and userID as parameters
 // and queries the assets table in the database for the balance associated with that symbol and userID, then returns the balance.
 func (a *Service) QueryBalance(symbol, _type string, userId int64) (balance float64) {
+	return a.queryBalance(symbol, _type, userId).Float()
+}
+
+// queryBalance - This function returns the balance of the user in the symbol as an exact decimal, a balance that does not exist is zero.
+func (a *Service) queryBalance(symbol, _type string, userId int64) *decimal.Float {
+
+	var (
+		balance decimal.Float
+	)
 
 	// This line of code is used to retrieve the balance from the assets table in a database. It takes in two parameters
 	// (symbol and userId) and uses them to query the database. The result is then stored in the variable balance.
 	_ = a.Context.Db.QueryRow("select value as balance from balances where symbol = $1 and user_id = $2 and type = $3", symbol, userId, _type).Scan(&balance)
-	return balance
+	return &balance
 }
 
 // QueryAsset - This function is used to retrieve asset information from a database. It takes a currency symbol and a status
@@ -508,7 +1082,7 @@ func (a *Service) QueryAsset(symbol string, status bool) (*types.Asset, error) {
 	// This code is performing a query of a database table called "currencies" and scanning the results into a response
 	// object. The query is using the symbol parameter to filter the results and strings.Join(maps, " ") to join any
 	// additional parameters. If the query fails, an error is returned.
-	if err := a.Context.Db.QueryRow(fmt.Sprintf(`select id, name, symbol, min_withdraw, max_withdraw, min_trade, max_trade, fees_trade, fees_discount, fees_charges, fees_costs, marker, status, "group", type, create_at, chains from assets where symbol = '%v' %s`, symbol, strings.Join(maps, " "))).Scan(
+	if err := a.Context.Db.QueryRow(fmt.Sprintf(`select id, name, symbol, min_withdraw, max_withdraw, min_trade, max_trade, fees_trade, fees_discount, fees_charges, fees_costs, marker, margin, borrow_rate, borrow_slope, borrow_jump, borrow_kink, reserve_rate, status, "group", type, create_at, chains from assets where symbol = '%v' %s`, symbol, strings.Join(maps, " "))).Scan(
 		&response.Id,
 		&response.Name,
 		&response.Symbol,
@@ -521,6 +1095,12 @@ func (a *Service) QueryAsset(symbol string, status bool) (*types.Asset, error) {
 		&response.FeesCharges,
 		&response.FeesCosts,
 		&response.Marker,
+		&response.Margin,
+		&response.BorrowRate,
+		&response.BorrowSlope,
+		&response.BorrowJump,
+		&response.BorrowKink,
+		&response.ReserveRate,
 		&response.Status,
 		&response.Group,
 		&response.Type,
@@ -655,27 +1235,112 @@ func (a *Service) QueryReverse(userId int64, address, symbol, platform string) (
 	return reverse
 }
 
+// ResetBook - This function drops the in-memory order books of the given type that contain the symbol, they are loaded again from
+// the database on the next access. It must be called after orders were changed or removed outside the matching engine.
+func (a *Service) ResetBook(symbol, _type string) {
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	books.Reset(symbol, _type)
+}
+
+// ResetBooks - drops the in-memory order books of all pairs, they are loaded again from the database on the next access.
+func (a *Service) ResetBooks() {
+	books.Clear()
+}
+
+// ResetTiers - drops the cached fee tiers of all users, they are resolved again from the fee schedule on the next fill. It must
+// be called after the fee schedule was changed.
+func (a *Service) ResetTiers() {
+	tiers.mutex.Lock()
+	tiers.items = make(map[string]*tier)
+	tiers.generation++
+	tiers.mutex.Unlock()
+}
+
 // WriteBalance - This function is used to update the balance of a user in a database. Depending on the cross parameter, either the
 // balance is increased (types.Balance_PLUS) or decreased (types.Balance_MINUS) by a given quantity. The balance is
-// updated in the assets table of the database, using a query. Finally, an error is returned if an error occurred during the update.
+// updated in its own database transaction by writeBalance, so the balance row is locked for the time of the update and
+// can never become negative. Finally, an error is returned if an error occurred during the update. The float64 quantity
+// is kept for the services that were not migrated to exact decimals yet.
 func (a *Service) WriteBalance(symbol, _type string, userId int64, quantity float64, cross string) error {
 
+	// The purpose of this code is to start a new database transaction, the transaction is rolled back when the function
+	// returns without a commit.
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := a.writeBalance(tx, symbol, _type, userId, decimal.New(quantity), cross, 0); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeBalance - This function changes the balance of a user inside the given database transaction. The balance row is locked with
+// "select ... for update" first, so concurrent transactions that touch the same balance are executed one after another,
+// and a decrease that would make the balance negative is rejected instead of being written. The quantity is an exact
+// decimal and is written to the numeric column as its string, so the balance does not drift after many changes. A change
+// made by the matching engine belongs to the event of the journal given by its id, the movement is written to the
+// journal before the balance is changed; zero is given for the changes made outside the engine.
+func (a *Service) writeBalance(tx *sql.Tx, symbol, _type string, userId int64, quantity *decimal.Float, cross string, journal int64) error {
+
+	// The purpose of this code is to declare a variable named "balance", the locked value of the balance as an exact decimal.
+	var (
+		balance decimal.Float
+	)
+
+	// This code locks the balance row of the user until the end of the transaction and reads its current value. A balance
+	// that does not exist can not be changed, the error is returned so that the whole transaction is rolled back.
+	if err := tx.QueryRow("select value from balances where symbol = $1 and user_id = $2 and type = $3 for update;", symbol, userId, _type).Scan(&balance); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return status.Errorf(11630, "the %v asset balance does not exist", symbol)
+		}
+		return err
+	}
+
+	// The movement is journaled with its sign and with the balance it was applied to, so that the replay can tell the
+	// changes of the engine apart from the changes made in between by deposits, withdrawals and transfers.
+	if journal > 0 {
+
+		movement := quantity
+		if cross == types.BalanceMinus {
+			movement = quantity.Mul(-1)
+		}
+
+		if _, err := tx.Exec("insert into journal_balances (journal_id, user_id, symbol, type, quantity, balance) values ($1, $2, $3, $4, $5, $6)", journal, userId, symbol, _type, movement.String(), balance.String()); err != nil {
+			return err
+		}
+	}
+
 	switch cross {
 	case types.BalancePlus:
 
 		// The code above is an if statement that is used to update the balance of an asset with a given symbol and user_id in
 		// a database. The statement executes an update query, passing in the values of symbol, quantity, and userId as
 		// parameters to the query. If the query fails to execute, the if statement will return an error.
-		if _, err := a.Context.Db.Exec("update balances set value = value + $2 where symbol = $1 and user_id = $3 and type = $4;", symbol, quantity, userId, _type); err != nil {
+		if _, err := tx.Exec("update balances set value = value + $2 where symbol = $1 and user_id = $3 and type = $4;", symbol, quantity.String(), userId, _type); err != nil {
 			return err
 		}
 		break
 	case types.BalanceMinus:
 
+		// The locked balance is checked before the decrease, two concurrent orders of the same user can not both spend the
+		// same funds, the second one waits for the lock and sees the balance left by the first one.
+		if quantity.GreaterThan(balance.Decimal) {
+			return status.Error(11629, "there is not enough funds on your asset balance")
+		}
+
 		// This code is used to update the balance of a user's assets in a database. The code updates the user's balance by
 		// subtracting the quantity given. The values being used to update the balance are stored in variables, and are passed
 		// into the code as parameters ($1, $2, and $3). The code also checks for errors and returns an error if one is found.
-		if _, err := a.Context.Db.Exec("update balances set value = value - $2 where symbol = $1 and user_id = $3 and type = $4;", symbol, quantity, userId, _type); err != nil {
+		if _, err := tx.Exec("update balances set value = value - $2 where symbol = $1 and user_id = $3 and type = $4;", symbol, quantity.String(), userId, _type); err != nil {
 			return err
 		}
 		break
@@ -708,14 +1373,19 @@ func (a *Service) WriteTransaction(transaction *types.Transaction) (*types.Trans
 			transaction.Hash = uuid.NewV1().String()
 		}
 
+		// The amounts are written as exact decimals and returned both as doubles and as decimal strings.
+		value, fees := a.queryExact(transaction.GetValueDecimal(), transaction.GetValue()), a.queryExact(transaction.GetFeesDecimal(), transaction.GetFees())
+		transaction.Value, transaction.ValueDecimal = value.Float(), value.String()
+		transaction.Fees, transaction.FeesDecimal = fees.Float(), fees.String()
+
 		// This code is a SQL query to insert transaction information into a database table called "transactions". It is
 		// assigning values to each of the 13 columns in the table, and then returning the id, CreateAt, and Status columns in
 		// the same row. It is then using the Scan() function to assign the returned values to the transaction object.
 		if err := a.Context.Db.QueryRow(`insert into transactions (symbol, hash, value, fees, confirmation, "to", block, chain_id, user_id, assignment, "group", platform, protocol, allocation, parent) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) returning id, create_at, status;`,
 			transaction.GetSymbol(),
 			transaction.GetHash(),
-			transaction.GetValue(),
-			transaction.GetFees(),
+			value.String(),
+			fees.String(),
 			transaction.GetConfirmation(),
 			transaction.GetTo(),
 			transaction.GetBlock(),
@@ -9,9 +9,12 @@ import (
 	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
 	"github.com/cryptogateway/backend-envoys/assets/common/help"
 	"github.com/cryptogateway/backend-envoys/assets/common/keypair"
+	"github.com/cryptogateway/backend-envoys/assets/common/orderbook"
 	"github.com/cryptogateway/backend-envoys/server/proto/v2/pbprovider"
 	"github.com/cryptogateway/backend-envoys/server/service/v2/account"
 	"github.com/cryptogateway/backend-envoys/server/types"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
 	"google.golang.org/grpc/status"
 )
 
@@ -57,6 +60,132 @@ func (a *Service) GetSymbol(_ context.Context, req *pbprovider.GetRequestSymbol)
 	return &response, nil
 }
 
+// StreamDepth - This function streams the order book of a pair. The first message is a snapshot of all price levels of the book,
+// every following message is a diff of the levels changed by one operation of the matching engine, a level with a zero
+// quantity was removed. Every message carries a sequence number that increases by one, a client that sees a gap has
+// missed an update and must open the stream again to receive a new snapshot.
+func (a *Service) StreamDepth(req *pbprovider.StreamRequestDepth, stream pbprovider.Api_StreamDepthServer) error {
+
+	// Validates the type of the request and returns an error if it is invalid.
+	if err := types.Type(req.GetType()); err != nil {
+		return err
+	}
+
+	// Validate that the requested base and quote units and type are valid for the given configuration before proceeding with the request.
+	if err := a.queryValidatePair(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType()); err != nil {
+		return err
+	}
+
+	// The subscription is opened before the snapshot is taken, so no diff published after the snapshot can be missed.
+	topic := streams.topic("depth", req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType())
+	channel := streams.subscribe(topic)
+	defer streams.unsubscribe(topic, channel)
+
+	// The snapshot is taken while the book is locked, it contains every diff up to its own sequence number.
+	book, err := a.queryBook(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType())
+	if err != nil {
+		return err
+	}
+	snapshot := book.Snapshot()
+	book.Unlock()
+
+	if err := stream.Send(a.queryDepthResponse(snapshot, true)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case message, ok := <-channel:
+
+			// A closed channel means that the client did not read the stream fast enough and was dropped.
+			if !ok {
+				return status.Error(11641, "the stream was closed because the client is too slow, please subscribe again")
+			}
+
+			// Diffs that are already contained in the snapshot are skipped.
+			if diff := message.(*orderbook.Diff); diff.Sequence > snapshot.Sequence {
+				if err := stream.Send(a.queryDepthResponse(diff, false)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// StreamTrades - This function streams the trades of a pair, one message for every fill of the matching engine. The trades do
+// not contain the users of the orders, the assigning of a trade is the side of the order that took the liquidity.
+func (a *Service) StreamTrades(req *pbprovider.StreamRequestTrades, stream pbprovider.Api_StreamTradesServer) error {
+
+	// Validates the type of the request and returns an error if it is invalid.
+	if err := types.Type(req.GetType()); err != nil {
+		return err
+	}
+
+	// Validate that the requested base and quote units and type are valid for the given configuration before proceeding with the request.
+	if err := a.queryValidatePair(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType()); err != nil {
+		return err
+	}
+
+	topic := streams.topic("trade", req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType())
+	channel := streams.subscribe(topic)
+	defer streams.unsubscribe(topic, channel)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case message, ok := <-channel:
+
+			// A closed channel means that the client did not read the stream fast enough and was dropped.
+			if !ok {
+				return status.Error(11641, "the stream was closed because the client is too slow, please subscribe again")
+			}
+
+			if err := stream.Send(&pbprovider.ResponseTrade{Fields: []*types.Trade{message.(*types.Trade)}}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// StreamTicker - This function streams the ticker of a pair for the requested resolution, the same ticker that is published to
+// the "trade/ticker:<interval>" topic of the exchange after every trade.
+func (a *Service) StreamTicker(req *pbprovider.StreamRequestTicker, stream pbprovider.Api_StreamTickerServer) error {
+
+	// The resolution must be one of the intervals of the ticker.
+	if !help.IndexOf(help.Depth(), req.GetResolution()) {
+		return status.Errorf(11642, "the resolution %v is not supported", req.GetResolution())
+	}
+
+	// Validate that the requested base and quote units are valid for the given configuration before proceeding with the request.
+	if err := a.queryValidatePair(req.GetBaseUnit(), req.GetQuoteUnit(), types.TypeSpot); err != nil {
+		return err
+	}
+
+	topic := streams.topic("ticker", req.GetBaseUnit(), req.GetQuoteUnit(), req.GetResolution())
+	channel := streams.subscribe(topic)
+	defer streams.unsubscribe(topic, channel)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case message, ok := <-channel:
+
+			// A closed channel means that the client did not read the stream fast enough and was dropped.
+			if !ok {
+				return status.Error(11641, "the stream was closed because the client is too slow, please subscribe again")
+			}
+
+			if err := stream.Send(message.(*pbprovider.ResponseTicker)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 // SetOrder - This function is a method of the Service struct. It is used to set an order for a user. It checks the authentication
 // and authorization, checks the validity of the input, sets the order and sends back the response. It also handles
 // errors encountered during the process.
@@ -106,108 +235,137 @@ func (a *Service) SetOrder(ctx context.Context, req *pbprovider.SetRequestOrder)
 		return &response, status.Error(748990, "your account and assets have been blocked, please contact technical support for any questions")
 	}
 
-	// This is setting the order quantity and value based on the request quantity and price.
-	// The request quantity is used to set the order quantity, order type, and the order value is calculated by multiplying the request quantity by the request price.
-	order.Quantity = req.GetQuantity()
-	order.Value = req.GetQuantity()
-	order.Type = req.GetType()
-
-	// This is a switch statement that is used to evaluate the trade type of the request object. Depending on the trade
-	// type, different actions can be taken. For example, if the trade type is "buy", the code may execute a certain set of
-	// instructions to purchase the item, and if the trade type is "sell", the code may execute a different set of instructions to sell the item.
-	switch req.GetTrading() {
-	case types.TradingMarket:
-
-		// The purpose of this code is to set the price of the order (order.Price) to the market price of the requested base
-		// and quote units, assigning, and price, which is retrieved from the "e.getMarket" function.
-		order.Price = a.queryMarket(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType(), req.GetAssigning(), req.GetPrice())
-
-		// This if statement is checking to see if the request is to buy something. If it is, it is calculating the quantity
-		// and value of the order by dividing the quantity by the price.
-		if req.GetAssigning() == types.AssigningBuy {
-			order.Quantity, order.Value = decimal.New(req.GetQuantity()).Div(order.GetPrice()).Float(), decimal.New(req.GetQuantity()).Div(order.GetPrice()).Float()
-		}
-
-	case types.TradingLimit:
-
-		// The purpose of this code is to set the value of the order.Price variable to the value returned by the GetPrice()
-		// method of the req object.
-		order.Price = req.GetPrice()
-	default:
-		return &response, status.Error(82284, "invalid type trade position")
-	}
-
 	// The purpose of these lines of code is to assign the values of certain variables to the corresponding values from a
-	// request object.  This is typically done when creating an order object from the request information.  In this case,
-	// the values of the order object are set to the UserId, BaseUnit, QuoteUnit, Assigning, Status, and CreateAt variables
-	// in the request object.  The Status is set to PENDING and the CreateAt is set to the current time.
+	// request object.  This is typically done when creating an order object from the request information.
 	order.UserId = user.GetId()
 	order.BaseUnit = req.GetBaseUnit()
 	order.QuoteUnit = req.GetQuoteUnit()
 	order.Assigning = req.GetAssigning()
 	order.Trading = req.GetTrading()
-	order.Status = types.StatusPending
-	order.CreateAt = time.Now().UTC().Format(time.RFC3339)
+	order.Type = req.GetType()
+	order.Price = req.GetPrice()
+	order.Quantity = req.GetQuantity()
+	order.TriggerPrice = req.GetTriggerPrice()
+	order.TimeInForce = req.GetTimeInForce()
+	order.PostOnly = req.GetPostOnly()
+	order.Display = req.GetDisplay()
+	order.ClientId = req.GetClientId()
+	order.Prevention = req.GetPrevention()
+
+	// A self-trade prevention mode given with the order replaces the mode of the account for this order.
+	if len(order.GetPrevention()) > 0 {
+		if err := types.Prevention(order.GetPrevention()); err != nil {
+			return &response, err
+		}
+	}
 
-	// This code is checking for an error in the queryValidateOrder() function and if one is found, it returns an error response
-	// and calls the Context.Error() method with the error. The quantity variable is used to store the result of queryValidateOrder(), which is used to complete the order.
-	quantity, err := a.queryValidateOrder(&order)
-	if err != nil {
-		return &response, err
+	// The exact decimal strings of the request take precedence over the doubles, a string that is not a decimal number is
+	// rejected instead of being read as zero.
+	if len(req.GetPriceDecimal()) > 0 {
+		price, err := decimal.Parse(req.GetPriceDecimal())
+		if err != nil {
+			return &response, status.Errorf(11664, "the price %v is not a decimal number", req.GetPriceDecimal())
+		}
+		order.Price, order.PriceDecimal = price.Float(), price.String()
 	}
 
-	// This is a conditional statement used to set a new order and check for any errors that might occur. If an error is
-	// encountered, the statement will return a response and an Error context to indicate that an error has occurred.
-	if order.Id, err = a.writeOrder(&order); err != nil {
-		return &response, err
+	if len(req.GetQuantityDecimal()) > 0 {
+		quantity, err := decimal.Parse(req.GetQuantityDecimal())
+		if err != nil {
+			return &response, status.Errorf(11664, "the quantity %v is not a decimal number", req.GetQuantityDecimal())
+		}
+		order.Quantity, order.QuantityDecimal = quantity.Float(), quantity.String()
 	}
 
-	// The switch statement is used to evaluate the value of the expression "order.GetAssigning()" and execute the
-	// corresponding case statement. It is a type of conditional statement that allows a program to make decisions based on different conditions.
-	switch order.GetAssigning() {
-	case types.AssigningBuy:
+	// A request with a client order id is idempotent: when the user already has an order with the same client order id, the
+	// request is a retry and the existing order is returned instead of placing a new one.
+	if len(order.GetClientId()) > 0 {
 
-		// This code snippet is likely a part of a function that processes an order. The purpose of the code is to use the
-		// function "writeAsset()" to set the base unit and user ID of the order to false. If an error occurs during the process,
-		// the code will return the response and an error message.
-		if err := a.writeAsset(order.GetBaseUnit(), order.GetType(), order.GetUserId(), false); err != nil {
+		if err := a.queryValidateClientId(order.GetClientId()); err != nil {
 			return &response, err
 		}
 
-		// This code is checking the balance of a user and attempting to subtract the specified quantity from it. If the
-		// operation is successful, it will continue with the program. If an error occurs, it will return an error response.
-		if err := a.WriteBalance(order.GetQuoteUnit(), order.GetType(), order.GetUserId(), quantity, types.BalanceMinus); err != nil {
-			return &response, err
+		if item, ok := a.queryClientOrder(order.GetUserId(), order.GetClientId()); ok {
+			response.Fields = append(response.Fields, item)
+			return &response, nil
 		}
+	}
 
-		a.trade(&order, types.AssigningSell)
+	// An order without a time in force is good till cancelled. A market order has no price of its own to rest at, so it is
+	// immediate or cancel by default.
+	if len(order.GetTimeInForce()) == 0 {
+		order.TimeInForce = types.TimeInForceGtc
+		if req.GetTrading() == types.TradingMarket || req.GetTrading() == types.TradingStopMarket {
+			order.TimeInForce = types.TimeInForceIoc
+		}
+	}
 
-		break
-	case types.AssigningSell:
+	// Validates the time in force of the request and returns an error if it is invalid.
+	if err := types.TimeInForce(order.GetTimeInForce()); err != nil {
+		return &response, err
+	}
 
-		// This code snippet is likely a part of a function that processes an order. The purpose of the code is to use the
-		// function "writeAsset()" to set the base unit and user ID of the order to false. If an error occurs during the process,
-		// the code will return the response and an error message.
-		if err := a.writeAsset(order.GetQuoteUnit(), order.GetType(), order.GetUserId(), false); err != nil {
-			return &response, err
+	// A good till date order needs an expiry time in the future, the orders of any other time in force never expire.
+	if order.GetTimeInForce() == types.TimeInForceGtd {
+
+		expire, err := time.Parse(time.RFC3339, req.GetExpireAt())
+		if err != nil || !expire.After(time.Now()) {
+			return &response, status.Error(11646, "the expiry time of a good till date order must be a time in the future")
 		}
+		order.ExpireAt = expire.UTC().Format(time.RFC3339)
+	}
 
-		// This code is checking the balance of a user and attempting to subtract the specified quantity from it. If the
-		// operation is successful, it will continue with the program. If an error occurs, it will return an error response.
-		if err := a.WriteBalance(order.GetBaseUnit(), order.GetType(), order.GetUserId(), quantity, types.BalanceMinus); err != nil {
-			return &response, err
+	// The slippage of a market order is a fraction of the best price of the book, for example 0.01 for one percent.
+	if req.GetSlippage() < 0 || req.GetSlippage() >= 1 {
+		return &response, status.Errorf(11656, "impossible slippage %v, the slippage must be a fraction from 0 to 1", req.GetSlippage())
+	}
+
+	// A post-only order has to rest in the book, so it can neither be a market order nor an immediate order.
+	if order.GetPostOnly() && (req.GetTrading() == types.TradingMarket || req.GetTrading() == types.TradingStopMarket || order.GetTimeInForce() == types.TimeInForceIoc || order.GetTimeInForce() == types.TimeInForceFok) {
+		return &response, status.Error(11647, "a post-only order must be a limit order that is allowed to rest in the book")
+	}
+
+	// An iceberg order shows only its display quantity in the book, so it has to be a limit order that is allowed to rest and
+	// its display quantity has to be a part of the whole quantity.
+	if order.GetDisplay() != 0 {
+
+		if order.GetDisplay() < 0 || order.GetDisplay() >= order.GetQuantity() {
+			return &response, status.Errorf(11648, "the display quantity %v of an iceberg order must be positive and less than the quantity", order.GetDisplay())
 		}
 
-		a.trade(&order, types.AssigningBuy)
+		if (req.GetTrading() != types.TradingLimit && req.GetTrading() != types.TradingStopLimit) || order.GetTimeInForce() == types.TimeInForceIoc || order.GetTimeInForce() == types.TimeInForceFok {
+			return &response, status.Error(11649, "an iceberg order must be a limit order that is allowed to rest in the book")
+		}
+	}
 
+	// Stop orders stay dormant until the last trade price crosses their trigger price, market and limit orders are placed
+	// into the book right away.
+	switch req.GetTrading() {
+	case types.TradingStopLimit, types.TradingStopMarket:
+		err = a.placeStop(&order)
 		break
 	default:
-		return &response, status.Error(11588, "invalid assigning trade position")
+		err = a.placeOrder(&order, req.GetSlippage())
+	}
+
+	if err != nil {
+
+		// A retry that raced with the original request fails on the unique client order id, the order placed by the
+		// original request is returned.
+		if len(order.GetClientId()) > 0 {
+			if item, ok := a.queryClientOrder(order.GetUserId(), order.GetClientId()); ok {
+				response.Fields = append(response.Fields, item)
+				return &response, nil
+			}
+		}
+
+		return &response, err
 	}
 
 	// This statement is used to append an element to the "Fields" slice of the "response" struct. The element being
-	// appended is the "order" struct.
+	// appended is the "order" struct. The average price of the fills tells the user at which price a market order was executed.
 	response.Fields = append(response.Fields, &order)
+	response.AveragePrice = order.GetAveragePrice()
 
 	return &response, nil
 }
@@ -368,9 +526,11 @@ func (a *Service) GetAsset(ctx context.Context, req *pbprovider.GetRequestAsset)
 		return &response, err
 	}
 
-	// Validates the request type and returns an error if there is an issue.
-	if err := types.Type(req.GetType()); err != nil {
-		return &response, err
+	// Validates the request type and returns an error if there is an issue, the lending balance is the supply of the user.
+	if req.GetType() != types.TypeLending {
+		if err := types.Type(req.GetType()); err != nil {
+			return &response, err
+		}
 	}
 
 	// The code is checking to see if an error occurred while attempting to get a currency. If there is an error, the
@@ -497,6 +657,11 @@ func (a *Service) GetAssets(ctx context.Context, req *pbprovider.GetRequestAsset
 		if req.GetType() == types.TypeSpot || req.GetType() == types.TypeCross {
 			maps = append(maps, fmt.Sprintf(`where type = '%v'`, types.TypeSpot))
 		}
+
+		// Only the assets enabled for margin trading have a lending pool that can hold a lending balance.
+		if req.GetType() == types.TypeLending {
+			maps = append(maps, fmt.Sprintf(`where margin = %v`, true))
+		}
 	}
 
 	// This code is querying the database to select the columns id, name, symbol, and status from the table currencies. The
@@ -653,7 +818,7 @@ func (a *Service) GetPair(_ context.Context, req *pbprovider.GetRequestPair) (*p
 	// This code is querying a database for a specific row in the table. The query is looking for a row with the specified
 	// base_unit and quote_unit from the 'parameters' req.GetBaseUnit() and req.GetQuoteUnit(). If an error occurs, the error.
 	// Finally, the row is closed with the defer keyword so that it is properly released back to the server.
-	row, err := a.Context.Db.Query(`select id, base_unit, quote_unit, price, base_decimal, quote_decimal, status from pairs where base_unit = $1 and quote_unit = $2`, req.GetBaseUnit(), req.GetQuoteUnit())
+	row, err := a.Context.Db.Query(`select id, base_unit, quote_unit, price, base_decimal, quote_decimal, tick_size, step_size, min_notional, max_notional, price_band, halted, coalesce(resume_at::text, ''), coalesce(auction_end::text, ''), status from pairs where base_unit = $1 and quote_unit = $2`, req.GetBaseUnit(), req.GetQuoteUnit())
 	if err != nil {
 		return &response, err
 	}
@@ -673,7 +838,7 @@ func (a *Service) GetPair(_ context.Context, req *pbprovider.GetRequestPair) (*p
 		// scan each row of the retrieved data and store the relevant information into a structure called "pair", which likely
 		// holds data regarding currency pairs. The "if" statement is a check to make sure that the data was successfully read
 		// and stored into the structure, and if not, it will return an error.
-		if err := row.Scan(&pair.Id, &pair.BaseUnit, &pair.QuoteUnit, &pair.Price, &pair.BaseDecimal, &pair.QuoteDecimal, &pair.Status); err != nil {
+		if err := row.Scan(&pair.Id, &pair.BaseUnit, &pair.QuoteUnit, &pair.Price, &pair.BaseDecimal, &pair.QuoteDecimal, &pair.TickSize, &pair.StepSize, &pair.MinNotional, &pair.MaxNotional, &pair.PriceBand, &pair.Halted, &pair.ResumeAt, &pair.AuctionEnd, &pair.Status); err != nil {
 			return &response, err
 		}
 
@@ -703,6 +868,32 @@ func (a *Service) GetPair(_ context.Context, req *pbprovider.GetRequestPair) (*p
 	return &response, nil
 }
 
+// GetFee - This function returns the fee tier of the authenticated user on the pair given in the request, the next tier that the
+// user can reach and the trading volume of the user over the trailing 30 days in the reference unit.
+func (a *Service) GetFee(ctx context.Context, req *pbprovider.GetRequestFee) (*pbprovider.ResponseFee, error) {
+
+	var (
+		response pbprovider.ResponseFee
+	)
+
+	// This code is part of an authentication process. The purpose of this code is to attempt to authenticate the user and
+	// retrieve the authentication data. If there is an error, it is returned to the caller.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// The tier, the next tier and the 30-day volume of the user are looked up for the pair, without a pair the global
+	// tiers are returned.
+	fee, next, volume, err := a.queryFee(auth, req.GetBaseUnit(), req.GetQuoteUnit())
+	if err != nil {
+		return &response, err
+	}
+	response.Volume, response.Fee, response.Next = volume, fee, next
+
+	return &response, nil
+}
+
 // GetTicker - The purpose of this code is to create a service that retrieves OHLC (open-high-low-close) data from a database and
 // returns it in a response. It is used to set limits on the number of results returned, filter the results based on a
 // time range, perform calculations on the data, and store the results in an array.
@@ -842,6 +1033,9 @@ func (a *Service) SetTicker(_ context.Context, req *pbprovider.SetRequestTicker)
 			return &response, err
 		}
 
+		// The same ticker is sent to the subscribers of the ticker stream of the pair and the interval.
+		streams.publish(streams.topic("ticker", req.GetBaseUnit(), req.GetQuoteUnit(), interval), migrate)
+
 		// The purpose of this statement is to append the values of the migrate.Fields array to the response.Fields array. This
 		// statement essentially adds the values of migrate.Fields array to the existing values in response.Fields array.
 		response.Fields = append(response.Fields, migrate.Fields...)
@@ -957,6 +1151,8 @@ func (a *Service) GetOrders(ctx context.Context, req *pbprovider.GetRequestOrder
 		maps = append(maps, fmt.Sprintf("and status = '%v'", types.StatusPending))
 	case types.StatusCancel:
 		maps = append(maps, fmt.Sprintf("and status = '%v'", types.StatusCancel))
+	case types.StatusDormant:
+		maps = append(maps, fmt.Sprintf("and status = '%v'", types.StatusDormant))
 	}
 
 	// This code checks if the length of the base unit and the quote unit in the request are greater than 0. If they are, it
@@ -966,9 +1162,28 @@ func (a *Service) GetOrders(ctx context.Context, req *pbprovider.GetRequestOrder
 		maps = append(maps, fmt.Sprintf("and base_unit = '%v' and quote_unit = '%v'", req.GetBaseUnit(), req.GetQuoteUnit()))
 	}
 
+	// The orders can be looked up by the client order id, the id is validated first because it is written into the query.
+	if len(req.GetClientId()) > 0 {
+
+		if err := a.queryValidateClientId(req.GetClientId()); err != nil {
+			return &response, err
+		}
+
+		maps = append(maps, fmt.Sprintf("and client_id = '%v'", req.GetClientId()))
+	}
+
+	// The owner of an iceberg order sees its whole open value, anybody else only sees the display slice, at most the display
+	// quantity of the order is shown as its value and quantity and the order is not marked as an iceberg. The slice of a
+	// resting order is taken from the book below, a partial fill of the slice is not stored in the database. The order
+	// group and the client order id are only shown to the owner as well.
+	value, quantity, group, client := "value", "quantity", "coalesce(extra->>'group', '')", "coalesce(client_id, '')"
+	if !req.GetOwner() {
+		value, quantity, group, client = "least(value, nullif(display, 0))", "coalesce(nullif(display, 0), quantity)", "''", "''"
+	}
+
 	// The purpose of this code is to query the database to count the number of orders and total value of the orders in the
 	// database. It then stores the count and volume in the response variable.
-	_ = a.Context.Db.QueryRow(fmt.Sprintf("select count(*) as count, sum(value) as volume from orders %s", strings.Join(maps, " "))).Scan(&response.Count, &response.Volume)
+	_ = a.Context.Db.QueryRow(fmt.Sprintf("select count(*) as count, sum(%s) as volume from orders %s", value, strings.Join(maps, " "))).Scan(&response.Count, &response.Volume)
 
 	// This statement is testing if the response from a user has a count that is greater than 0. If the response has a count
 	// greater than 0, then something else will occur.
@@ -985,7 +1200,7 @@ func (a *Service) GetOrders(ctx context.Context, req *pbprovider.GetRequestOrder
 		// This code is used to perform a SQL query on a database. It is used to select certain columns from the orders table
 		// and to order them by the id in descending order. The limit and offset parameters are used to limit the number of
 		// rows returned and to specify where in the result set to start returning rows from. The strings.Join function is used to join the "maps" parameter which is an array of strings.
-		rows, err := a.Context.Db.Query(fmt.Sprintf("select id, assigning, price, value, quantity, base_unit, quote_unit, user_id, create_at, type, status from orders %s order by id desc limit %d offset %d", strings.Join(maps, " "), req.GetLimit(), offset))
+		rows, err := a.Context.Db.Query(fmt.Sprintf("select id, assigning, price, trigger_price, %s, %s, display, base_unit, quote_unit, user_id, create_at, type, trading, time_in_force, post_only, %s, %s, status from orders %s order by id desc limit %d offset %d", value, quantity, group, client, strings.Join(maps, " "), req.GetLimit(), offset))
 		if err != nil {
 			return &response, err
 		}
@@ -999,15 +1214,34 @@ func (a *Service) GetOrders(ctx context.Context, req *pbprovider.GetRequestOrder
 			// The purpose of the above code is to declare a variable called item with the type types.Order. This allows the
 			// program to create an object of type types.Order and assign it to the item variable.
 			var (
-				item types.Order
+				item                   types.Order
+				price, value, quantity decimal.Float
 			)
 
 			// This code is scanning the rows returned from a database query and assigning the values to the variables in the item
 			// struct. If an error is encountered during the scanning process, an error is returned.
-			if err = rows.Scan(&item.Id, &item.Assigning, &item.Price, &item.Value, &item.Quantity, &item.BaseUnit, &item.QuoteUnit, &item.UserId, &item.CreateAt, &item.Type, &item.Status); err != nil {
+			if err = rows.Scan(&item.Id, &item.Assigning, &price, &item.TriggerPrice, &value, &quantity, &item.Display, &item.BaseUnit, &item.QuoteUnit, &item.UserId, &item.CreateAt, &item.Type, &item.Trading, &item.TimeInForce, &item.PostOnly, &item.Group, &item.ClientId, &item.Status); err != nil {
 				return &response, err
 			}
 
+			// The visible slice of a resting iceberg order is the open value of the order in the book of its pair.
+			if !req.GetOwner() && item.GetDisplay() > 0 {
+				if item.GetStatus() == types.StatusPending {
+					if book, err := a.queryBook(item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType()); err == nil {
+						if resting, ok := book.Get(item.GetId()); ok {
+							value = resting.Value
+						}
+						book.Unlock()
+					}
+				}
+				item.Display = 0
+			}
+
+			// The amounts are sent both as doubles and as exact decimal strings.
+			item.Price, item.PriceDecimal = price.Float(), price.String()
+			item.Value, item.ValueDecimal = value.Float(), value.String()
+			item.Quantity, item.QuantityDecimal = quantity.Float(), quantity.String()
+
 			// The purpose of this statement is to add an item to the existing list of fields in a response object. The
 			// response.Fields list is appended with the item, which is passed as an argument to the append function.
 			response.Fields = append(response.Fields, &item)
@@ -1022,6 +1256,61 @@ func (a *Service) GetOrders(ctx context.Context, req *pbprovider.GetRequestOrder
 	return &response, nil
 }
 
+// GetDepth - This function returns the aggregated order book (L2) of a pair: the bids and the asks grouped by price level, taken
+// from the in-memory book. The number of levels is limited by req.Limit (30 by default, 100 at most) and the prices are
+// grouped to req.Precision decimal places, which can not be finer than the quote decimals of the pair. The quantities are
+// rounded to the base decimals of the pair.
+func (a *Service) GetDepth(_ context.Context, req *pbprovider.GetRequestDepth) (*pbprovider.ResponseDepth, error) {
+
+	// The purpose of this code is to declare a variable called response of type pbprovider.ResponseDepth.
+	var (
+		response pbprovider.ResponseDepth
+	)
+
+	// Validates the type of the request and returns an error if it is invalid.
+	if err := types.Type(req.GetType()); err != nil {
+		return &response, err
+	}
+
+	// This code checks the limit of the request, a request without a limit gets 30 levels, and the number of levels is
+	// never larger than 100.
+	if req.GetLimit() == 0 {
+		req.Limit = 30
+	} else if req.GetLimit() > 100 {
+		req.Limit = 100
+	}
+
+	// This code reads the decimals of the pair, it also checks that the pair exists.
+	baseDecimal, quoteDecimal, err := a.queryDecimals(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType())
+	if err != nil {
+		return &response, err
+	}
+
+	// The grouping precision is the quote decimals of the pair, a coarser precision can be requested.
+	precision := quoteDecimal
+	if req.GetPrecision() > 0 && req.GetPrecision() < quoteDecimal {
+		precision = req.GetPrecision()
+	}
+
+	// The book of the pair is locked only for the time of the aggregation.
+	book, err := a.queryBook(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType())
+	if err != nil {
+		return &response, err
+	}
+	defer book.Unlock()
+
+	// The levels of both sides of the book are converted into the response, from the best price to the worst.
+	for _, level := range book.Depth(types.AssigningBuy, int(req.GetLimit()), precision) {
+		response.Bids = append(response.Bids, &pbprovider.Depth{Price: level.Price.Float(), Quantity: level.Quantity.Round(baseDecimal).Float(), Count: int32(level.Count)})
+	}
+
+	for _, level := range book.Depth(types.AssigningSell, int(req.GetLimit()), precision) {
+		response.Asks = append(response.Asks, &pbprovider.Depth{Price: level.Price.Float(), Quantity: level.Quantity.Round(baseDecimal).Float(), Count: int32(level.Count)})
+	}
+
+	return &response, nil
+}
+
 // GetTrades - This function is a method of the Service type. It is used to get a list of transfers from a database. It takes a
 // context and a request object as parameters. The request object contains information about the requested transfers,
 // such as the limit, order ID and whether the request should only return transfers for a specific user. The function
@@ -1093,16 +1382,22 @@ func (a *Service) GetTrades(ctx context.Context, req *pbprovider.GetRequestTrade
 		// The purpose of this code is to declare a variable called item, and assign it to a value of type types.Trade.
 		// This is used in programming to store data in the form of a variable and access it at a later time.
 		var (
-			item types.Trade
+			item                  types.Trade
+			price, quantity, fees decimal.Float
 		)
 
 		// This code is part of a function that retrieves data from a database. The purpose of the if statement is to scan the
 		// rows of the database and assign each row's values to the corresponding variables. If an error occurs while scanning
 		// the rows, the function will return an error.
-		if err = rows.Scan(&item.Id, &item.UserId, &item.BaseUnit, &item.QuoteUnit, &item.Price, &item.Quantity, &item.Assigning, &item.Fees, &item.Maker, &item.CreateAt); err != nil {
+		if err = rows.Scan(&item.Id, &item.UserId, &item.BaseUnit, &item.QuoteUnit, &price, &quantity, &item.Assigning, &fees, &item.Maker, &item.CreateAt); err != nil {
 			return &response, err
 		}
 
+		// The amounts are sent both as doubles and as exact decimal strings.
+		item.Price, item.PriceDecimal = price.Float(), price.String()
+		item.Quantity, item.QuantityDecimal = quantity.Float(), quantity.String()
+		item.Fees, item.FeesDecimal = fees.Float(), fees.String()
+
 		// This statement is appending a new item to the Fields array of the response object. The purpose of this statement is
 		// to add a new item to the response's Fields array.
 		response.Fields = append(response.Fields, &item)
@@ -1209,7 +1504,8 @@ func (a *Service) GetTransactions(ctx context.Context, req *pbprovider.GetReques
 			// The purpose of this variable declaration is to declare a variable named "item" of type "types.Transaction". This
 			// variable can then be used to store and manipulate data of type "types.Transaction".
 			var (
-				item types.Transaction
+				item               types.Transaction
+				value, price, fees decimal.Float
 			)
 
 			// This code is used to scan the rows of a database table and assign the values to the corresponding fields of the
@@ -1219,9 +1515,9 @@ func (a *Service) GetTransactions(ctx context.Context, req *pbprovider.GetReques
 				&item.Id,
 				&item.Symbol,
 				&item.Hash,
-				&item.Value,
-				&item.Price,
-				&item.Fees,
+				&value,
+				&price,
+				&fees,
 				&item.Confirmation,
 				&item.To,
 				&item.ChainId,
@@ -1247,9 +1543,14 @@ func (a *Service) GetTransactions(ctx context.Context, req *pbprovider.GetReques
 			// This code checks the protocol associated with the item. If the protocol is not equal to the mainnet protocol, then
 			// the fees associated with the item are multiplied by the item's price, and the result is stored as a float.
 			if item.GetProtocol() != types.ProtocolMainnet {
-				item.Fees = decimal.New(item.GetFees()).Mul(item.GetPrice()).Float()
+				fees = *fees.Mul(&price)
 			}
 
+			// The amounts are sent both as doubles and as exact decimal strings.
+			item.Value, item.ValueDecimal = value.Float(), value.String()
+			item.Price, item.PriceDecimal = price.Float(), price.String()
+			item.Fees, item.FeesDecimal = fees.Float(), fees.String()
+
 			// This statement is appending an item to the Fields slice of the response variable. This adds the item to the end of
 			// the slice, increasing its length by one. The purpose of this statement is to add an item to the Fields slice.
 			response.Fields = append(response.Fields, &item)
@@ -1264,93 +1565,792 @@ func (a *Service) GetTransactions(ctx context.Context, req *pbprovider.GetReques
 	return &response, nil
 }
 
-// CancelOrder - This function is used to cancel an order in a spot trading system. It takes in a context and a request object, and
-// returns a response object and an error. It checks the status of the order, updates the order status to "CANCEL",
-// updates the balance, and publishes a message.
-func (a *Service) CancelOrder(ctx context.Context, req *pbprovider.CancelRequestOrder) (*pbprovider.ResponseOrder, error) {
+// SetOrders - This function places a batch of orders of a user. Every order of the batch is placed by SetOrder, so it goes
+// through the same validation as a single order. A failed order does not abort the batch, the response contains a result
+// for every order of the request in the same order, with the placed order or the reason of the failure.
+func (a *Service) SetOrders(ctx context.Context, req *pbprovider.SetRequestOrders) (*pbprovider.ResponseOrders, error) {
 
-	// The purpose of this code is to declare a variable named response of type pbprovider.ResponseOrder. This is a type used to
-	// store information about a response to an order, such as the status, order ID, and other details.
 	var (
-		response pbprovider.ResponseOrder
+		response pbprovider.ResponseOrders
 	)
 
-	// This code is checking to make sure a valid authentication token is present in the context. If it is not, it returns
-	// an error. This is necessary to ensure that only authorized users are accessing certain resources.
-	auth, err := a.Context.Auth(ctx)
-	if err != nil {
-		return &response, err
+	// This code checks the size of the batch, an empty batch or a batch that is larger than the limit is rejected as a whole.
+	if len(req.GetOrders()) == 0 || len(req.GetOrders()) > batch {
+		return &response, status.Errorf(11653, "a batch must contain from 1 to %v orders", batch)
 	}
 
-	// This query is used to fetch data from the orders table in the database. The query is parameterized to ensure that
-	// only the desired records are returned. The parameters are the status, id, and user_id. The query also includes an
-	// order by clause to ensure that the data is returned in a specific order. The data is then stored in the row variable
-	// and the defer statement is used to close the row when the query is finished.
-	row, err := a.Context.Db.Query(`select id, value, quantity, price, assigning, base_unit, quote_unit, user_id, type, create_at from orders where id = $1 and status = $2 and user_id = $3 order by id`, req.GetId(), types.StatusPending, auth)
-	if err != nil {
+	// The authentication is checked once before the batch, so that an unauthenticated batch fails as a whole.
+	if _, err := a.Context.Auth(ctx); err != nil {
 		return &response, err
 	}
-	defer row.Close()
 
-	// The purpose of the following code is to check if there is a row available for retrieving data from. The `row.Next()`
-	// method returns a boolean value indicating whether there is a row available. If the result is true, it means that a
-	// row is available and can be used to retrieve data.
-	if row.Next() {
+	for _, item := range req.GetOrders() {
 
-		// The purpose of the 'var' statement is to declare a new variable, in this case "item", which is of type
-		// "types.Order". This allows the program to use the variable "item" to store values of type "types.Order", such as
-		// orders placed on an online store.
 		var (
-			item types.Order
+			result pbprovider.BatchOrder
 		)
 
-		// This code is used to scan the row of a database table and assign the values to the relevant variables. The if
-		// statement checks for any errors that may occur during the scanning process, and if an error is found, it will return an error response.
-		if err = row.Scan(&item.Id, &item.Value, &item.Quantity, &item.Price, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.UserId, &item.Type, &item.CreateAt); err != nil {
-			return &response, err
+		// The result of the order is either the placed order or the message of the error that rejected it.
+		if order, err := a.SetOrder(ctx, item); err != nil {
+			result.Error = status.Convert(err).Message()
+		} else if len(order.GetFields()) > 0 {
+			result.Id, result.Order, result.Success = order.GetFields()[0].GetId(), order.GetFields()[0], true
 		}
 
-		// The purpose of the code is to update the status of an order for a particular user in the database. It takes three
-		// parameters: the ID of the order, the user ID, and the new status for the order. If the execution of the SQL query
-		// fails, an error is returned.
-		if _, err := a.Context.Db.Exec("update orders set status = $3 where id = $1 and user_id = $2;", item.GetId(), item.GetUserId(), types.StatusCancel); err != nil {
-			return &response, err
-		}
+		response.Fields = append(response.Fields, &result)
+	}
 
-		// The switch statement is used to compare the value of a variable (in this case, item.Assigning) to a list of possible
-		// values. If the value matches one of the values in the list, a specific action will be executed.
-		switch item.Assigning {
-		case types.AssigningBuy:
+	return &response, nil
+}
 
-			// This code is setting the balance of a user for a given item. It is using the item's quote unit, user id, value and
-			// price to calculate the new balance and then updating the balance using the types.Balance_PLUS parameter. If there
-			// is an error setting the balance, an error is returned.
-			if err := a.WriteBalance(item.GetQuoteUnit(), item.GetType(), item.GetUserId(), decimal.New(item.GetValue()).Mul(item.GetPrice()).Float(), types.BalancePlus); err != nil {
-				return &response, err
-			}
+// SetOco - This function places a one-cancels-other order group of a user: a take-profit limit order and a stop-loss stop order
+// of the same quantity. The limit order rests in the book with its reserved balance, the stop order stays dormant. A fill
+// of the limit order or a trigger of the stop order cancels the other leg, a cancellation of one leg cancels both.
+func (a *Service) SetOco(ctx context.Context, req *pbprovider.SetRequestOco) (*pbprovider.ResponseOrder, error) {
 
-			break
-		case types.AssigningSell:
+	var (
+		response pbprovider.ResponseOrder
+		group    = uuid.NewV4().String()
+	)
 
-			// This code is used to set a balance for a user in a particular base unit. The "if err" statement is used to check if
-			// there is an error when setting the balance. If there is an error, the code will return an error message.
-			if err := a.WriteBalance(item.GetBaseUnit(), item.GetType(), item.GetUserId(), item.GetValue(), types.BalancePlus); err != nil {
-				return &response, err
-			}
+	// Validates the type of the request and returns an error if it is invalid.
+	if err := types.Type(req.GetType()); err != nil {
+		return &response, err
+	}
 
-			break
-		}
+	// This code snippet checks if the request is authenticated by calling the Auth() method on the Context object. If the
+	// authentication fails, the code returns an error.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
 
-		// This code is intended to publish an item to an exchange with the routing key "order/cancel". If any errors occur
-		// while attempting to publish the item, the error is returned and the response is returned.
-		if err := a.Context.Publish(&item, "exchange", "order/cancel"); err != nil {
-			return &response, err
-		}
+	// Validate that the requested base and quote units and type are valid for the given configuration before proceeding with the request.
+	if err := a.queryValidatePair(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType()); err != nil {
+		return &response, err
+	}
 
-	} else {
-		return &response, status.Error(11538, "the requested order does not exist")
+	_account := account.Service{
+		Context: a.Context,
 	}
-	response.Success = true
 
-	return &response, nil
+	// This code is attempting to query a user from migrate using the provided authentication credentials (auth). If the
+	// query fails, an error is returned.
+	user, err := _account.QueryUser(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.GetStatus() {
+		return &response, status.Error(748990, "your account and assets have been blocked, please contact technical support for any questions")
+	}
+
+	// The take-profit leg of a sell group is above the stop-loss trigger and the one of a buy group is below it, otherwise
+	// both legs would be executed at once.
+	switch req.GetAssigning() {
+	case types.AssigningBuy:
+		if req.GetPrice() >= req.GetTriggerPrice() {
+			return &response, status.Error(11650, "the limit price of a buy order group must be below its trigger price")
+		}
+		break
+	case types.AssigningSell:
+		if req.GetPrice() <= req.GetTriggerPrice() {
+			return &response, status.Error(11650, "the limit price of a sell order group must be above its trigger price")
+		}
+		break
+	default:
+		return &response, status.Error(11588, "invalid assigning trade position")
+	}
+
+	// A group whose trigger price was already crossed by the last trade would activate its stop leg right away.
+	if price, ok := a.queryLastPrice(req.GetBaseUnit(), req.GetQuoteUnit()); ok && ((req.GetAssigning() == types.AssigningBuy && price >= req.GetTriggerPrice()) || (req.GetAssigning() == types.AssigningSell && price <= req.GetTriggerPrice())) {
+		return &response, status.Errorf(11651, "the trigger price %v has already been crossed by the last trade price %v", req.GetTriggerPrice(), price)
+	}
+
+	// The stop leg is a stop-limit order when a stop price is given and a stop-market order otherwise.
+	stop := types.Order{
+		UserId:       user.GetId(),
+		BaseUnit:     req.GetBaseUnit(),
+		QuoteUnit:    req.GetQuoteUnit(),
+		Assigning:    req.GetAssigning(),
+		Type:         req.GetType(),
+		Trading:      types.TradingStopLimit,
+		TimeInForce:  types.TimeInForceGtc,
+		Price:        req.GetStopPrice(),
+		Quantity:     req.GetQuantity(),
+		TriggerPrice: req.GetTriggerPrice(),
+		Group:        group,
+	}
+
+	// The quantity of a market buy order is given in the quote unit, the quantity of the group is converted at the trigger price.
+	if req.GetStopPrice() == 0 {
+		stop.Trading, stop.TimeInForce = types.TradingStopMarket, types.TimeInForceIoc
+		if req.GetAssigning() == types.AssigningBuy {
+			stop.Quantity = decimal.New(req.GetQuantity()).Mul(req.GetTriggerPrice()).Float()
+		}
+	}
+
+	limit := types.Order{
+		UserId:      user.GetId(),
+		BaseUnit:    req.GetBaseUnit(),
+		QuoteUnit:   req.GetQuoteUnit(),
+		Assigning:   req.GetAssigning(),
+		Type:        req.GetType(),
+		Trading:     types.TradingLimit,
+		TimeInForce: types.TimeInForceGtc,
+		Price:       req.GetPrice(),
+		Quantity:    req.GetQuantity(),
+		Group:       group,
+	}
+
+	// The dormant stop leg is written first, so that an immediate fill of the limit leg already finds it and cancels it.
+	if err := a.placeStop(&stop); err != nil {
+		return &response, err
+	}
+
+	// When the limit leg can not be placed the group is not complete, the dormant stop leg is cancelled again with the book of
+	// the pair locked, like any other cancellation that is journaled.
+	if err := a.placeOrder(&limit, 0); err != nil {
+		if book, err := a.queryBook(stop.GetBaseUnit(), stop.GetQuoteUnit(), stop.GetType()); !a.Context.Debug(err) {
+			a.Context.Debug(a.cancel(&stop, book, types.EventCancel))
+			a.unlockBook(book, stop.GetBaseUnit(), stop.GetQuoteUnit(), stop.GetType())
+		}
+		return &response, err
+	}
+
+	response.Fields = append(response.Fields, &limit, &stop)
+
+	return &response, nil
+}
+
+// AmendOrder - This function changes the price and/or the open quantity of a resting limit order of a user in place of a
+// cancel and a new order. The order and the difference of its reserved balance are written in one database transaction
+// while the book of the pair is locked, so the amendment can not race with a fill. A decrease of the quantity keeps the
+// time priority of the order, a price change or an increase of the quantity moves the order to the tail of its new price
+// level, and a new price that crosses the opposite side is matched right away.
+func (a *Service) AmendOrder(ctx context.Context, req *pbprovider.AmendRequestOrder) (*pbprovider.ResponseOrder, error) {
+
+	var (
+		response            pbprovider.ResponseOrder
+		item                types.Order
+		reserve             = decimal.New(0)
+		open, current, rate decimal.Float
+	)
+
+	// This code is checking to make sure a valid authentication token is present in the context. If it is not, it returns
+	// an error.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// An order can be amended by its client order id instead of its id.
+	if req.GetId() == 0 && len(req.GetClientId()) > 0 {
+		_ = a.Context.Db.QueryRow("select id from orders where user_id = $1 and client_id = $2", auth, req.GetClientId()).Scan(&req.Id)
+	}
+
+	// A price or a quantity below zero is impossible, a zero keeps the current value.
+	if req.GetPrice() < 0 || req.GetQuantity() < 0 || (req.GetPrice() == 0 && req.GetQuantity() == 0) {
+		return &response, status.Errorf(65790, "impossible amendment price %v, quantity %v", req.GetPrice(), req.GetQuantity())
+	}
+
+	// This query reads the pair of the order, the values that can change are read again inside the transaction.
+	if err := a.Context.Db.QueryRow("select id, assigning, base_unit, quote_unit, user_id, type, trading, time_in_force, post_only, display, prevention from orders where id = $1 and user_id = $2 and status = $3", req.GetId(), auth, types.StatusPending).Scan(&item.Id, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.UserId, &item.Type, &item.Trading, &item.TimeInForce, &item.PostOnly, &item.Display, &item.Prevention); err != nil {
+		return &response, status.Error(11538, "the requested order does not exist")
+	}
+
+	// Only a limit order rests in the book with a price of its own.
+	if item.GetTrading() != types.TradingLimit {
+		return &response, status.Error(11654, "only a resting limit order can be amended")
+	}
+
+	// The book of the pair is locked for the time of the amendment, so that the order can not be matched while its price,
+	// quantity and reserved balance are being changed.
+	book, err := a.queryBook(item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType())
+	if err != nil {
+		return &response, err
+	}
+	defer a.unlockBook(book, item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType())
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return &response, err
+	}
+	defer tx.Rollback()
+
+	// The open value and the price are read with the row locked, the order could have been partially filled in the meantime.
+	// The open value and the quantity are kept as exact decimals for the difference of the reserved balance.
+	if err := tx.QueryRow("select value, quantity, price from orders where id = $1 and status = $2 for update", item.GetId(), types.StatusPending).Scan(&open, &current, &rate); err != nil {
+		return &response, status.Error(11538, "the requested order does not exist")
+	}
+	item.Value, item.ValueDecimal = open.Float(), open.String()
+	item.Quantity, item.QuantityDecimal = current.Float(), current.String()
+	item.Price, item.PriceDecimal = rate.Float(), rate.String()
+
+	var (
+		value = &open
+		price = &rate
+	)
+
+	if req.GetPrice() > 0 {
+		price = decimal.New(req.GetPrice())
+	}
+
+	if req.GetQuantity() > 0 {
+		value = decimal.New(req.GetQuantity())
+	}
+
+	// The display quantity of an iceberg order has to stay a part of its open quantity.
+	if item.GetDisplay() > 0 && !value.GreaterThan(decimal.New(item.GetDisplay()).Decimal) {
+		return &response, status.Errorf(11648, "the display quantity %v of an iceberg order must be positive and less than the quantity", item.GetDisplay())
+	}
+
+	// The switch statement calculates the difference of the reserved balance: a buy order reserves its open value multiplied by
+	// the price in the quote unit, a sell order reserves its open value in the base unit. The new open value is checked
+	// against the trading range in the same way as a new order.
+	switch item.GetAssigning() {
+	case types.AssigningBuy:
+
+		if min, max, ok := a.queryRange(item.GetQuoteUnit(), value.Mul(price)); !ok {
+			return &response, status.Errorf(11623, "[quote]: minimum trading amount: %v~%v, maximum trading amount: %v", min.String(), min.Mul(2).String(), max.String())
+		}
+		reserve = value.Mul(price).Sub(open.Mul(&rate))
+
+		break
+	case types.AssigningSell:
+
+		if min, max, ok := a.queryRange(item.GetBaseUnit(), value); !ok {
+			return &response, status.Errorf(11587, "[base]: minimum trading amount: %v~%v, maximum trading amount: %v", min.String(), min.Mul(2).String(), max.String())
+		}
+		reserve = value.Sub(&open)
+
+		break
+	}
+
+	// The new price and quantity have to follow the trading rules of the pair in the same way as a new order.
+	if err := a.queryValidateRules(&types.Order{BaseUnit: item.GetBaseUnit(), QuoteUnit: item.GetQuoteUnit(), Assigning: item.GetAssigning(), Type: item.GetType(), Trading: item.GetTrading(), Price: price.Float(), PriceDecimal: price.String(), Quantity: value.Float(), QuantityDecimal: value.String()}); err != nil {
+		return &response, err
+	}
+
+	// The pair must not be halted and the new price has to stay within the price band of the pair.
+	if err := a.queryValidateBand(&types.Order{BaseUnit: item.GetBaseUnit(), QuoteUnit: item.GetQuoteUnit(), Type: item.GetType(), Trading: item.GetTrading(), Price: price.Float(), PriceDecimal: price.String()}); err != nil {
+		return &response, err
+	}
+
+	// A post-only order must keep only adding liquidity, a new price that would trade right away is rejected.
+	if item.GetPostOnly() && book.Marketable(&orderbook.Order{UserId: item.GetUserId(), Assigning: item.GetAssigning(), Price: *price, Value: *value}) {
+		return &response, status.Error(11644, "the post-only order would take liquidity and was rejected")
+	}
+
+	// The difference of the reserved balance is taken from or returned to the locked balance of the user.
+	symbol := item.GetBaseUnit()
+	if item.GetAssigning() == types.AssigningBuy {
+		symbol = item.GetQuoteUnit()
+	}
+
+	// The amendment is written to the journal of the pair with the new price and the new open value of the order.
+	journal, err := a.writeJournal(tx, types.EventAmend, &item, 0, price, value)
+	if err != nil {
+		return &response, err
+	}
+
+	if reserve.IsPositive() {
+		if err := a.writeBalance(tx, symbol, a.queryWallet(&item), item.GetUserId(), reserve, types.BalanceMinus, journal); err != nil {
+			return &response, err
+		}
+	} else if reserve.IsNegative() {
+		if err := a.writeBalance(tx, symbol, a.queryWallet(&item), item.GetUserId(), reserve.Mul(-1), types.BalancePlus, journal); err != nil {
+			return &response, err
+		}
+	}
+
+	// The quantity of the order follows the change of its open value, so the executed part of the order stays the same.
+	quantity := current.Add(value.Sub(&open))
+	if _, err := tx.Exec("update orders set price = $2, value = $3, quantity = $4 where id = $1", item.GetId(), price.String(), value.String(), quantity.String()); err != nil {
+		return &response, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &response, err
+	}
+
+	// The amendment is published as one event instead of a cancellation and a new order, the error is only logged.
+	requeue := !price.Equal(rate.Decimal) || (!value.Equal(open.Decimal) && !book.Amend(item.GetId(), value))
+	item.Price, item.PriceDecimal = price.Float(), price.String()
+	item.Value, item.ValueDecimal = value.Float(), value.String()
+	item.Quantity, item.QuantityDecimal = quantity.Float(), quantity.String()
+	item.Status = types.StatusPending
+	a.Context.Debug(a.Context.Publish(&item, "exchange", "order/amend"))
+
+	// A decrease of the quantity at the same price keeps the time priority of the order. Otherwise, the order is removed from
+	// the book and matched again at its new price, the part that is not filled rests at the tail of its price level.
+	if requeue {
+		book.Remove(item.GetId())
+		a.trade(&item, book)
+	}
+
+	response.Fields = append(response.Fields, &item)
+
+	return &response, nil
+}
+
+// CancelOrder - This function is used to cancel an order in a spot trading system. It takes in a context and a request object, and
+// returns a response object and an error. It checks the status of the order, updates the order status to "CANCEL",
+// updates the balance, and publishes a message.
+func (a *Service) CancelOrder(ctx context.Context, req *pbprovider.CancelRequestOrder) (*pbprovider.ResponseOrder, error) {
+
+	// The purpose of this code is to declare a variable named response of type pbprovider.ResponseOrder. This is a type used to
+	// store information about a response to an order, such as the status, order ID, and other details.
+	var (
+		response pbprovider.ResponseOrder
+	)
+
+	// This code is checking to make sure a valid authentication token is present in the context. If it is not, it returns
+	// an error. This is necessary to ensure that only authorized users are accessing certain resources.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// An order can be cancelled by its client order id instead of its id, the id of the order is looked up among the orders
+	// of the user.
+	if req.GetId() == 0 && len(req.GetClientId()) > 0 {
+		_ = a.Context.Db.QueryRow("select id from orders where user_id = $1 and client_id = $2", auth, req.GetClientId()).Scan(&req.Id)
+	}
+
+	// This query is used to fetch data from the orders table in the database. The query is parameterized to ensure that
+	// only the desired records are returned. The parameters are the status, id, and user_id. The query also includes an
+	// order by clause to ensure that the data is returned in a specific order. The data is then stored in the row variable
+	// and the defer statement is used to close the row when the query is finished.
+	row, err := a.Context.Db.Query(`select id, value, quantity, price, assigning, base_unit, quote_unit, user_id, type, status, create_at from orders where id = $1 and (status = $2 or status = $4) and user_id = $3 order by id`, req.GetId(), types.StatusPending, auth, types.StatusDormant)
+	if err != nil {
+		return &response, err
+	}
+	defer row.Close()
+
+	// The purpose of the following code is to check if there is a row available for retrieving data from. The `row.Next()`
+	// method returns a boolean value indicating whether there is a row available. If the result is true, it means that a
+	// row is available and can be used to retrieve data.
+	if row.Next() {
+
+		// The purpose of the 'var' statement is to declare a new variable, in this case "item", which is of type
+		// "types.Order". This allows the program to use the variable "item" to store values of type "types.Order", such as
+		// orders placed on an online store.
+		var (
+			item types.Order
+		)
+
+		// This code is used to scan the row of a database table and assign the values to the relevant variables. The if
+		// statement checks for any errors that may occur during the scanning process, and if an error is found, it will return an error response.
+		if err = row.Scan(&item.Id, &item.Value, &item.Quantity, &item.Price, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.UserId, &item.Type, &item.Status, &item.CreateAt); err != nil {
+			return &response, err
+		}
+
+		// The book of the pair is locked for the time of the cancellation, so that the order can not be matched while its
+		// reserved balance is being returned. A dormant stop order is not in the book and has no reserved balance, it is only
+		// marked as cancelled, the book is still locked because the other legs of its order group can rest in the book.
+		book, err := a.queryBook(item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType())
+		if err != nil {
+			return &response, err
+		}
+		defer a.unlockBook(book, item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType())
+
+		// The order is cancelled, its reserved balance is returned and the cancellation is published.
+		if err := a.cancel(&item, book, types.EventCancel); err != nil {
+			return &response, err
+		}
+
+		// The other legs of the order group of the order are cancelled together with it.
+		a.cancelGroup(item.GetId(), book)
+
+	} else {
+		return &response, status.Error(11538, "the requested order does not exist")
+	}
+	response.Success = true
+
+	return &response, nil
+}
+
+// CancelOrders - This function cancels a batch of orders of a user: either the orders with the given ids, or, when no ids are
+// given, every pending and dormant order of the user on the pair, optionally only on one side. The book of a pair is locked
+// once for all orders of the pair, and a failed cancellation does not abort the batch, the response contains a result for
+// every order that was found.
+func (a *Service) CancelOrders(ctx context.Context, req *pbprovider.CancelRequestOrders) (*pbprovider.ResponseOrders, error) {
+
+	var (
+		response pbprovider.ResponseOrders
+		orders   []*types.Order
+	)
+
+	// This code is checking to make sure a valid authentication token is present in the context. If it is not, it returns
+	// an error.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	// A batch of ids is limited in size, without ids the orders of a pair are cancelled, so the pair has to be valid.
+	if len(req.GetIds()) > batch {
+		return &response, status.Errorf(11653, "a batch must contain from 1 to %v orders", batch)
+	}
+
+	if len(req.GetIds()) == 0 {
+
+		if err := types.Type(req.GetType()); err != nil {
+			return &response, err
+		}
+
+		if err := a.queryValidatePair(req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType()); err != nil {
+			return &response, err
+		}
+	}
+
+	// This query selects the open orders of the user that have to be cancelled, sorted by pair so that the book of every
+	// pair is locked only once.
+	rows, err := a.Context.Db.Query(`select id, value, quantity, price, assigning, base_unit, quote_unit, user_id, type, status, create_at from orders where user_id = $1 and (status = $2 or status = $3) and (id = any($4) or (cardinality($4) = 0 and base_unit = $5 and quote_unit = $6 and type = $7 and ($8 = '' or assigning = $8))) order by base_unit, quote_unit, type, id`, auth, types.StatusPending, types.StatusDormant, pq.Array(req.GetIds()), req.GetBaseUnit(), req.GetQuoteUnit(), req.GetType(), req.GetAssigning())
+	if err != nil {
+		return &response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			item types.Order
+		)
+
+		if err := rows.Scan(&item.Id, &item.Value, &item.Quantity, &item.Price, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.UserId, &item.Type, &item.Status, &item.CreateAt); err != nil {
+			return &response, err
+		}
+
+		orders = append(orders, &item)
+	}
+	_ = rows.Close()
+
+	var (
+		book *orderbook.Book
+		last *types.Order
+	)
+
+	for _, item := range orders {
+
+		var (
+			result = pbprovider.BatchOrder{Id: item.GetId(), Order: item}
+		)
+
+		// The book of the previous pair is unlocked and the book of the next pair is locked when the pair changes.
+		if last == nil || last.GetBaseUnit() != item.GetBaseUnit() || last.GetQuoteUnit() != item.GetQuoteUnit() || last.GetType() != item.GetType() {
+
+			if book != nil {
+				a.unlockBook(book, last.GetBaseUnit(), last.GetQuoteUnit(), last.GetType())
+			}
+
+			if book, err = a.queryBook(item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType()); err != nil {
+				return &response, err
+			}
+			last = item
+		}
+
+		// The order is cancelled together with the other legs of its order group. A leg that was already cancelled with its
+		// group earlier in the batch is reported as cancelled as well.
+		if err := a.cancel(item, book, types.EventCancel); err != nil {
+			if result.Success = a.queryOrder(item.GetId()).GetStatus() == types.StatusCancel; !result.Success {
+				result.Error = status.Convert(err).Message()
+			}
+		} else {
+			a.cancelGroup(item.GetId(), book)
+			result.Success = true
+		}
+
+		response.Fields = append(response.Fields, &result)
+	}
+
+	if book != nil {
+		a.unlockBook(book, last.GetBaseUnit(), last.GetQuoteUnit(), last.GetType())
+	}
+
+	return &response, nil
+}
+
+// GetMargin - This function returns the state of the margin account of the user: the value of its assets and liabilities in the
+// reference unit, its equity, its margin level, the leverage limit and its loans. The account is the cross margin wallet,
+// or the isolated wallet of the pair given in the request.
+func (a *Service) GetMargin(ctx context.Context, req *pbprovider.GetRequestMargin) (*pbprovider.ResponseMargin, error) {
+
+	var (
+		response pbprovider.ResponseMargin
+	)
+
+	// An account without a type is the cross margin account.
+	if len(req.GetType()) == 0 {
+		req.Type = types.TypeCross
+	}
+
+	wallet, err := a.queryValidateAccount(req.GetType(), req.GetBaseUnit(), req.GetQuoteUnit())
+	if err != nil {
+		return &response, err
+	}
+
+	// This code snippet checks if the request is authenticated by calling the Auth() method on the Context object. If the
+	// authentication fails, the code returns an error.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	response.Margin, err = a.queryMargin(auth, wallet)
+	if err != nil {
+		return &response, err
+	}
+
+	return &response, nil
+}
+
+// SetTransfer - This function moves collateral between the spot balance of the user and the margin account. A deposit moves the
+// quantity of the asset into the margin account, a withdrawal moves it back to the spot balance as long as the loans of
+// the account stay within the leverage limit. The isolated wallet of a pair only takes the base and the quote unit of the
+// pair. The state of the account after the transfer is returned.
+func (a *Service) SetTransfer(ctx context.Context, req *pbprovider.SetRequestTransfer) (*pbprovider.ResponseMargin, error) {
+
+	var (
+		response pbprovider.ResponseMargin
+	)
+
+	user, wallet, err := a.queryMarginUser(ctx, req.GetType(), req.GetBaseUnit(), req.GetQuoteUnit(), req.GetQuantity())
+	if err != nil {
+		return &response, err
+	}
+
+	if req.GetAssignment() != types.AssignmentDeposit && req.GetAssignment() != types.AssignmentWithdrawal {
+		return &response, status.Errorf(11671, "invalid transfer assignment %v, the assignment must be a deposit or a withdrawal", req.GetAssignment())
+	}
+
+	if err := a.writeTransfer(user, req.GetSymbol(), wallet, req.GetAssignment(), req.GetQuantity()); err != nil {
+		return &response, err
+	}
+
+	response.Margin, err = a.queryMargin(user, wallet)
+	if err != nil {
+		return &response, err
+	}
+	response.Success = true
+
+	return &response, nil
+}
+
+// SetBorrow - This function lends the quantity of the asset to the margin account of the user, the loan is limited by the
+// leverage limit of the account. The state of the account after the loan is returned.
+func (a *Service) SetBorrow(ctx context.Context, req *pbprovider.SetRequestBorrow) (*pbprovider.ResponseMargin, error) {
+
+	var (
+		response pbprovider.ResponseMargin
+	)
+
+	user, wallet, err := a.queryMarginUser(ctx, req.GetType(), req.GetBaseUnit(), req.GetQuoteUnit(), req.GetQuantity())
+	if err != nil {
+		return &response, err
+	}
+
+	if err := a.writeBorrow(user, req.GetSymbol(), wallet, req.GetQuantity()); err != nil {
+		return &response, err
+	}
+
+	response.Margin, err = a.queryMargin(user, wallet)
+	if err != nil {
+		return &response, err
+	}
+	response.Success = true
+
+	return &response, nil
+}
+
+// SetRepay - This function repays the loan of the asset from the margin account of the user, the interest is paid first. The
+// state of the account after the repayment is returned.
+func (a *Service) SetRepay(ctx context.Context, req *pbprovider.SetRequestRepay) (*pbprovider.ResponseMargin, error) {
+
+	var (
+		response pbprovider.ResponseMargin
+	)
+
+	user, wallet, err := a.queryMarginUser(ctx, req.GetType(), req.GetBaseUnit(), req.GetQuoteUnit(), req.GetQuantity())
+	if err != nil {
+		return &response, err
+	}
+
+	if err := a.writeRepay(user, req.GetSymbol(), wallet, req.GetQuantity()); err != nil {
+		return &response, err
+	}
+
+	response.Margin, err = a.queryMargin(user, wallet)
+	if err != nil {
+		return &response, err
+	}
+	response.Success = true
+
+	return &response, nil
+}
+
+// GetPools - This function returns the lending pools with their supply, their utilization and their rates, the lending pool of
+// the asset given in the request or the pools of all the assets enabled for margin trading. For an authenticated user
+// the supply of the user is returned as the balance of the pool.
+func (a *Service) GetPools(ctx context.Context, req *pbprovider.GetRequestPools) (*pbprovider.ResponsePool, error) {
+
+	var (
+		response pbprovider.ResponsePool
+		symbols  []string
+	)
+
+	if len(req.GetSymbol()) > 0 {
+		symbols = append(symbols, req.GetSymbol())
+	} else {
+
+		rows, err := a.Context.Db.Query("select symbol from assets where margin = $1 and status = $1 order by symbol", true)
+		if err != nil {
+			return &response, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+
+			var (
+				symbol string
+			)
+
+			if err := rows.Scan(&symbol); err != nil {
+				return &response, err
+			}
+
+			symbols = append(symbols, symbol)
+		}
+
+		if err := rows.Err(); err != nil {
+			return &response, err
+		}
+	}
+
+	for _, symbol := range symbols {
+
+		pool, err := a.queryPool(symbol)
+		if err != nil {
+			return &response, err
+		}
+
+		// The supply of the user is only returned to an authenticated request, the pools themselves are public.
+		if auth, err := a.Context.Auth(ctx); err == nil {
+			pool.Balance = a.QueryBalance(symbol, types.TypeLending, auth)
+		}
+
+		response.Fields = append(response.Fields, pool)
+	}
+
+	return &response, nil
+}
+
+// SetSupply - This function moves funds between the spot balance of the user and the lending pool of the asset. A deposit
+// supplies the quantity to the pool, where it earns the yield of the loans, a withdrawal takes it back as long as the pool
+// has it available. The state of the pool after the change is returned.
+func (a *Service) SetSupply(ctx context.Context, req *pbprovider.SetRequestSupply) (*pbprovider.ResponsePool, error) {
+
+	var (
+		response pbprovider.ResponsePool
+	)
+
+	if req.GetQuantity() <= 0 {
+		return &response, status.Errorf(11670, "impossible quantity %v, the quantity must be positive", req.GetQuantity())
+	}
+
+	if req.GetAssignment() != types.AssignmentDeposit && req.GetAssignment() != types.AssignmentWithdrawal {
+		return &response, status.Errorf(11671, "invalid transfer assignment %v, the assignment must be a deposit or a withdrawal", req.GetAssignment())
+	}
+
+	user, err := a.queryActiveUser(ctx)
+	if err != nil {
+		return &response, err
+	}
+
+	if err := a.writeSupply(user, req.GetSymbol(), req.GetAssignment(), req.GetQuantity()); err != nil {
+		return &response, err
+	}
+
+	pool, err := a.queryPool(req.GetSymbol())
+	if err != nil {
+		return &response, err
+	}
+	pool.Balance = a.QueryBalance(req.GetSymbol(), types.TypeLending, user)
+
+	response.Fields = append(response.Fields, pool)
+	response.Success = true
+
+	return &response, nil
+}
+
+// queryValidateAccount - This function checks the type of a margin account and returns the balance type of its wallet. The cross
+// margin account has one wallet, an isolated margin account needs the pair of its wallet, which has to exist.
+func (a *Service) queryValidateAccount(_type, base, quote string) (wallet string, err error) {
+
+	switch _type {
+	case types.TypeCross:
+		break
+	case types.TypeIsolated:
+		if err := a.queryValidatePair(base, quote, _type); err != nil {
+			return wallet, err
+		}
+		break
+	default:
+		return wallet, status.Errorf(11669, "invalid margin account type %v", _type)
+	}
+
+	return a.queryWallet(&types.Order{Type: _type, BaseUnit: base, QuoteUnit: quote}), nil
+}
+
+// queryMarginUser - This function checks a request that changes a margin account: the type has to be a margin account type and
+// the quantity has to be positive. It returns the id of the authenticated user, a blocked user can not change the account,
+// and the balance type of the wallet of the account.
+func (a *Service) queryMarginUser(ctx context.Context, _type, base, quote string, quantity float64) (int64, string, error) {
+
+	wallet, err := a.queryValidateAccount(_type, base, quote)
+	if err != nil {
+		return 0, wallet, err
+	}
+
+	if quantity <= 0 {
+		return 0, wallet, status.Errorf(11670, "impossible quantity %v, the quantity must be positive", quantity)
+	}
+
+	user, err := a.queryActiveUser(ctx)
+	if err != nil {
+		return 0, wallet, err
+	}
+
+	return user, wallet, nil
+}
+
+// queryActiveUser - This function returns the id of the authenticated user of the request, a blocked user can not move its funds.
+func (a *Service) queryActiveUser(ctx context.Context) (int64, error) {
+
+	// This code snippet checks if the request is authenticated by calling the Auth() method on the Context object. If the
+	// authentication fails, the code returns an error.
+	auth, err := a.Context.Auth(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// The purpose of this code is to create a Service object that uses the context stored in the variable e. The Service
+	// object is then assigned to the variable migrate.
+	_account := account.Service{
+		Context: a.Context,
+	}
+
+	user, err := _account.QueryUser(auth)
+	if err != nil {
+		return 0, err
+	}
+
+	// This code is checking the user's status. If the user's status is not valid (GetStatus() returns false), it returns an
+	// error message informing the user that their account and assets have been blocked.
+	if !user.GetStatus() {
+		return 0, status.Error(748990, "your account and assets have been blocked, please contact technical support for any questions")
+	}
+
+	return user.GetId(), nil
 }
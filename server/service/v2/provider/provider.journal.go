@@ -0,0 +1,233 @@
+package provider
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/server/types"
+)
+
+// rebuild - The rebuild struct is the state of an order rebuilt from the journal: its open value, its price and its status.
+type rebuild struct {
+	value, price *decimal.Float
+	status       string
+}
+
+// holding - The holding struct identifies a balance rebuilt from the journal, the orders of a pair move spot and margin
+// balances of the same asset.
+type holding struct {
+	user   int64
+	symbol string
+	_type  string
+}
+
+// writeJournal - This function appends an event of the matching engine to the journal of the pair of the order, inside the given
+// transaction and before the state of the event is applied, so the journal and the tables are committed together or not
+// at all. The events of a pair are numbered without gaps, the number is taken while the book of the pair is locked by
+// the caller; two events that would get the same number can not both be committed because of the unique index. The maker
+// is the resting order of a fill, the price and the quantity are the values of the event as exact decimals. The id of the
+// event is returned, the balance movements of the event refer to it. The events of margin orders belong to the journal of
+// the spot pair, because they trade in its book.
+func (a *Service) writeJournal(tx *sql.Tx, event string, order *types.Order, maker int64, price, quantity *decimal.Float) (id int64, err error) {
+
+	var (
+		_type = order.GetType()
+	)
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	if err := tx.QueryRow(`insert into journal (sequence, event, order_id, maker_id, user_id, assigning, base_unit, quote_unit, type, price, quantity) select coalesce(max(sequence), 0) + 1, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10 from journal where base_unit = $6 and quote_unit = $7 and type = $8 returning id`, event, order.GetId(), maker, order.GetUserId(), order.GetAssigning(), order.GetBaseUnit(), order.GetQuoteUnit(), _type, price.String(), quantity.String()).Scan(&id); err != nil {
+		return id, err
+	}
+
+	return id, nil
+}
+
+// Replay - This function rebuilds the orders of the pair and the balances changed by its events from the journal, and returns
+// the values that differ from the current tables. The events are applied in the order of their sequence: an accepted
+// order opens with its quantity, a fill decreases both of its orders and fills the ones that are used up, a decrement of
+// the self-trade prevention decreases both orders and cancels the ones that are used up, an amendment sets the new price
+// and open value, a cancellation or an expiry cancels the order. The balance movements of every user and asset touched
+// by the pair are applied in the order they were written, across all pairs; a balance that changed between two movements
+// was changed outside the matching engine (a deposit, a withdrawal or a transfer) and is reported as external. Everything
+// is read in one read-only snapshot, so the engine can keep trading during a replay.
+func (a *Service) Replay(base, quote, _type string) (differences []*types.Difference, events int32, err error) {
+
+	var (
+		orders = make(map[int64]*rebuild)
+	)
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	tx, err := a.Context.Db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return differences, events, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`select event, order_id, maker_id, price, quantity from journal where base_unit = $1 and quote_unit = $2 and type = $3 order by sequence`, base, quote, _type)
+	if err != nil {
+		return differences, events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			event           string
+			id, maker       int64
+			price, quantity decimal.Float
+		)
+
+		if err := rows.Scan(&event, &id, &maker, &price, &quantity); err != nil {
+			return differences, events, err
+		}
+		events++
+
+		switch event {
+		case types.EventAccepted:
+			orders[id] = &rebuild{value: &quantity, price: &price, status: types.StatusPending}
+			break
+		case types.EventDormant:
+			orders[id] = &rebuild{value: &quantity, price: &price, status: types.StatusDormant}
+			break
+		case types.EventFill:
+
+			// The orders of the fill that were accepted before the journal was started can not be rebuilt and are skipped.
+			for _, item := range []int64{id, maker} {
+				if order, ok := orders[item]; ok {
+					if order.value = order.value.Sub(&quantity); !order.value.IsPositive() {
+						order.status = types.StatusFilled
+					}
+				}
+			}
+
+			break
+		case types.EventPrevent:
+			for _, item := range []int64{id, maker} {
+				if order, ok := orders[item]; ok {
+					if order.value = order.value.Sub(&quantity); !order.value.IsPositive() {
+						order.status = types.StatusCancel
+					}
+				}
+			}
+			break
+		case types.EventAmend:
+			if order, ok := orders[id]; ok {
+				order.value, order.price = &quantity, &price
+			}
+			break
+		case types.EventCancel, types.EventExpire:
+			if order, ok := orders[id]; ok {
+				order.status = types.StatusCancel
+			}
+			break
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return differences, events, err
+	}
+	_ = rows.Close()
+
+	// The orders are compared in the order of their ids, so that two replays of the same journal return the same list.
+	ids := make([]int64, 0, len(orders))
+	for id := range orders {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+
+		var (
+			order        = orders[id]
+			value, price decimal.Float
+			status       string
+		)
+
+		if err := tx.QueryRow(`select value, price, status from orders where id = $1`, id).Scan(&value, &price, &status); err != nil {
+			differences = append(differences, &types.Difference{Kind: "order", OrderId: id, Field: "id", Journal: order.status})
+			continue
+		}
+
+		if !order.value.Equal(value.Decimal) {
+			differences = append(differences, &types.Difference{Kind: "order", OrderId: id, Field: "value", Journal: order.value.String(), Table: value.String()})
+		}
+
+		if !order.price.Equal(price.Decimal) {
+			differences = append(differences, &types.Difference{Kind: "order", OrderId: id, Field: "price", Journal: order.price.String(), Table: price.String()})
+		}
+
+		if order.status != status {
+			differences = append(differences, &types.Difference{Kind: "order", OrderId: id, Field: "status", Journal: order.status, Table: status})
+		}
+	}
+
+	// The balance movements of the users and assets that were touched by the events of the pair, in the order they were written.
+	rows, err = tx.Query(`select b.journal_id, b.user_id, b.symbol, b.type, b.quantity, b.balance from journal_balances b where (b.user_id, b.symbol, b.type) in (select distinct m.user_id, m.symbol, m.type from journal_balances m, journal j where j.id = m.journal_id and j.base_unit = $1 and j.quote_unit = $2 and j.type = $3) order by b.user_id, b.symbol, b.type, b.id`, base, quote, _type)
+	if err != nil {
+		return differences, events, err
+	}
+	defer rows.Close()
+
+	var (
+		balances = make(map[holding]*decimal.Float)
+		holdings []holding
+	)
+
+	for rows.Next() {
+
+		var (
+			journal           int64
+			item              holding
+			quantity, balance decimal.Float
+		)
+
+		if err := rows.Scan(&journal, &item.user, &item.symbol, &item._type, &quantity, &balance); err != nil {
+			return differences, events, err
+		}
+
+		// The balance that the movement was applied to has to be the balance left by the previous movement of the engine.
+		running, ok := balances[item]
+		if !ok {
+			holdings = append(holdings, item)
+		} else if !running.Equal(balance.Decimal) {
+			differences = append(differences, &types.Difference{Kind: "external", JournalId: journal, UserId: item.user, Symbol: item.symbol, Type: item._type, Field: "value", Journal: running.String(), Table: balance.String()})
+		}
+		balances[item] = balance.Add(&quantity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return differences, events, err
+	}
+	_ = rows.Close()
+
+	// The balance left by the last movement of the engine is compared with the current balance, the holdings are already in
+	// the order of the user, the asset and the type.
+	for _, item := range holdings {
+
+		var (
+			running = balances[item]
+			value   decimal.Float
+		)
+
+		if err := tx.QueryRow(`select value from balances where user_id = $1 and symbol = $2 and type = $3`, item.user, item.symbol, item._type).Scan(&value); err != nil {
+			differences = append(differences, &types.Difference{Kind: "balance", UserId: item.user, Symbol: item.symbol, Type: item._type, Field: "id", Journal: running.String()})
+			continue
+		}
+
+		if !running.Equal(value.Decimal) {
+			differences = append(differences, &types.Difference{Kind: "balance", UserId: item.user, Symbol: item.symbol, Type: item._type, Field: "value", Journal: running.String(), Table: value.String()})
+		}
+	}
+
+	return differences, events, nil
+}
@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/server/types"
+	"google.golang.org/grpc/status"
+)
+
+// queryRate - This function returns the daily rates in percent of a lending pool at the given utilization. The borrow rate follows
+// a curve with a kink: from the base rate it rises by the slope up to the kink of the utilization, and from there by the
+// jump up to the full utilization, so that borrowing becomes expensive once the pool runs dry. The supply rate is the
+// interest paid by the borrowers spread over the whole supply, without the reserve share of the exchange.
+func (a *Service) queryRate(utilization, base, slope, jump, kink, reserve float64) (borrow, supply float64) {
+
+	borrow = base
+	if kink > 0 {
+		borrow += slope * math.Min(utilization, kink) / kink
+	}
+
+	if kink < 1 && utilization > kink {
+		borrow += jump * (utilization - kink) / (1 - kink)
+	}
+
+	return borrow, borrow * utilization * (1 - reserve)
+}
+
+// queryPool - This function returns the state of the lending pool of the asset. The supply is the sum of the lending balances of
+// the suppliers, the borrowed quantity is what the loans owe to the pool: the principal and the interest without the
+// reserve share of the exchange, the yield of the suppliers is credited when the interest accrues. The available quantity
+// is the part of the supply that can still be borrowed or withdrawn.
+func (a *Service) queryPool(symbol string) (*types.Pool, error) {
+
+	var (
+		pool = types.Pool{
+			Symbol: symbol,
+		}
+		base, slope, jump, kink, utilization float64
+		supplied, borrowed                   decimal.Float
+	)
+
+	if err := a.Context.Db.QueryRow("select borrow_rate, borrow_slope, borrow_jump, borrow_kink, reserve_rate from assets where symbol = $1", symbol).Scan(&base, &slope, &jump, &kink, &pool.ReserveRate); err != nil {
+		return &pool, status.Errorf(11673, "the asset %v has no lending pool", symbol)
+	}
+
+	if err := a.Context.Db.QueryRow("select coalesce(sum(value), 0) from balances where symbol = $1 and type = $2", symbol, types.TypeLending).Scan(&supplied); err != nil {
+		return &pool, err
+	}
+
+	if err := a.Context.Db.QueryRow("select coalesce(sum(principal + interest - reserve), 0) from loans where symbol = $1", symbol).Scan(&borrowed); err != nil {
+		return &pool, err
+	}
+
+	pool.Supplied, pool.Borrowed = supplied.Float(), borrowed.Float()
+
+	// A pool that lent more than its supply, or that lent without any supply, is fully utilized.
+	if supplied.IsPositive() {
+		utilization = math.Min(borrowed.Div(&supplied).Float(), 1)
+	} else if borrowed.IsPositive() {
+		utilization = 1
+	}
+
+	if available := supplied.Sub(&borrowed); available.IsPositive() {
+		pool.Available = available.Float()
+	}
+
+	pool.Utilization = utilization
+	pool.BorrowRate, pool.SupplyRate = a.queryRate(utilization, base, slope, jump, kink, pool.GetReserveRate())
+
+	return &pool, nil
+}
+
+// queryValidatePool - This function checks that the lending pool of the asset has the quantity available, the pool has to be
+// locked by the caller so that the quantity can not be taken twice.
+func (a *Service) queryValidatePool(symbol string, quantity float64) error {
+
+	pool, err := a.queryPool(symbol)
+	if err != nil {
+		return err
+	}
+
+	if quantity > pool.GetAvailable() {
+		return status.Errorf(11674, "the lending pool of %v has only %v available", symbol, pool.GetAvailable())
+	}
+
+	return nil
+}
+
+// writeSupply - This function moves the quantity of the asset between the spot balance of the user and the lending pool in one
+// database transaction. A deposit supplies it to the pool of an asset enabled for margin trading, a withdrawal takes it
+// back to the spot balance as long as the pool has it available, the borrowed part of the supply stays lent out.
+func (a *Service) writeSupply(userId int64, symbol, assignment string, quantity float64) error {
+
+	var (
+		from, to = types.TypeSpot, types.TypeLending
+		enabled  bool
+	)
+
+	if assignment == types.AssignmentWithdrawal {
+		from, to = types.TypeLending, types.TypeSpot
+	} else if err := a.Context.Db.QueryRow("select margin from assets where symbol = $1 and status = $2", symbol, true).Scan(&enabled); err != nil || !enabled {
+		return status.Errorf(11673, "the asset %v has no lending pool", symbol)
+	}
+
+	if err := a.writeAsset(symbol, to, userId, false); err != nil {
+		return err
+	}
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The pool is locked like a margin account without a user, the loans and the withdrawals of the pool are serialized.
+	if err := a.writeLock(tx, 0, fmt.Sprintf("%v:%v", types.TypeLending, symbol)); err != nil {
+		return err
+	}
+
+	if assignment == types.AssignmentWithdrawal {
+		if err := a.queryValidatePool(symbol, quantity); err != nil {
+			return err
+		}
+	}
+
+	if err := a.writeBalance(tx, symbol, from, userId, decimal.New(quantity), types.BalanceMinus, 0); err != nil {
+		return err
+	}
+
+	if err := a.writeBalance(tx, symbol, to, userId, decimal.New(quantity), types.BalancePlus, 0); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeYield - This function credits the yield of the lending pool to its suppliers in proportion to their supply, every credit
+// is recorded in the yields table together with the supply rate of the pool. The yield is written in the transaction of the
+// interest that it comes from.
+func (a *Service) writeYield(tx *sql.Tx, pool *types.Pool, quantity *decimal.Float) error {
+
+	if !quantity.IsPositive() || pool.GetSupplied() <= 0 {
+		return nil
+	}
+
+	ratio := quantity.Div(pool.GetSupplied())
+
+	if _, err := tx.Exec(`with paid as (update balances b set value = b.value + o.value * $2 from balances o where o.id = b.id and b.symbol = $1 and b.type = $3 and b.value > 0 returning b.user_id, o.value * $2 as quantity) insert into yields (user_id, symbol, quantity, rate) select user_id, $1, quantity, $4 from paid where quantity > 0`, pool.GetSymbol(), ratio.String(), types.TypeLending, decimal.New(pool.GetSupplyRate()).Round(4).String()); err != nil {
+		return err
+	}
+
+	return nil
+}
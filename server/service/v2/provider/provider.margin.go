@@ -0,0 +1,669 @@
+package provider
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/assets/common/orderbook"
+	"github.com/cryptogateway/backend-envoys/assets/common/query"
+	"github.com/cryptogateway/backend-envoys/server/types"
+	"google.golang.org/grpc/status"
+)
+
+// queryWallet - This function returns the balance type of the wallet that the order reserves and receives its funds from. It is
+// the type of the order, except for an isolated margin order: every pair has an isolated wallet of its own, for example
+// "isolated:btc/usd", so the collateral, the loans and the interest of one pair never touch the other holdings of the user.
+func (a *Service) queryWallet(order *types.Order) string {
+
+	if order.GetType() == types.TypeIsolated {
+		return fmt.Sprintf("%v:%v/%v", types.TypeIsolated, order.GetBaseUnit(), order.GetQuoteUnit())
+	}
+
+	return order.GetType()
+}
+
+// queryWalletPair - This function returns the pair of the isolated margin wallet, the boolean reports whether the wallet is the
+// wallet of a pair.
+func (a *Service) queryWalletPair(wallet string) (base, quote string, ok bool) {
+
+	_type, pair, ok := strings.Cut(wallet, ":")
+	if !ok || _type != types.TypeIsolated {
+		return base, quote, false
+	}
+
+	return strings.Cut(pair, "/")
+}
+
+// queryValidateWallet - checks that the asset can be held in the wallet, the isolated wallet of a pair only holds the base and
+// the quote unit of its pair.
+func (a *Service) queryValidateWallet(wallet, symbol string) error {
+
+	if base, quote, ok := a.queryWalletPair(wallet); ok && symbol != base && symbol != quote {
+		return status.Errorf(11672, "the isolated margin wallet of the pair %v-%v only holds %v and %v", base, quote, base, quote)
+	}
+
+	return nil
+}
+
+// queryConvert - This function returns the price of one unit of the asset in the reference unit. The price is taken from
+// pairs.price of the pair of the asset against the reference unit, or inverted from the pair of the reference unit against
+// the asset. The boolean reports whether the asset has such a price.
+func (a *Service) queryConvert(symbol string) (price float64, ok bool) {
+
+	if symbol == reference {
+		return 1, true
+	}
+
+	if err := a.Context.Db.QueryRow("select price from pairs where base_unit = $1 and quote_unit = $2 and price > 0 limit 1", symbol, reference).Scan(&price); err == nil {
+		return price, true
+	}
+
+	if err := a.Context.Db.QueryRow("select 1 / price from pairs where base_unit = $2 and quote_unit = $1 and price > 0 limit 1", symbol, reference).Scan(&price); err == nil {
+		return price, true
+	}
+
+	return price, false
+}
+
+// queryMargin - This function returns the state of the margin account of the user valued in the reference unit, the account is
+// the cross margin wallet or the isolated wallet of a pair. The assets are the balances of the wallet together with the
+// funds reserved by its pending orders, the liabilities are the principal and the accrued interest of its loans. An asset
+// without a price in the reference unit is not counted as collateral. The margin level is the value of the assets divided
+// by the value of the liabilities, it is zero while nothing is borrowed.
+func (a *Service) queryMargin(userId int64, wallet string) (*types.Margin, error) {
+
+	var (
+		margin = types.Margin{
+			UserId:   userId,
+			Type:     wallet,
+			Leverage: leverage,
+		}
+		assets, liabilities = decimal.New(0), decimal.New(0)
+	)
+
+	margin.BaseUnit, margin.QuoteUnit, _ = a.queryWalletPair(wallet)
+
+	// The reserve of a pending buy order is its open value at its price in the quote unit, the reserve of a sell order is its
+	// open value in the base unit, the same amounts that are returned to the balance when the order is cancelled.
+	rows, err := a.Context.Db.Query(`select symbol, sum(value) from (select symbol, value from balances where user_id = $1 and type = $2 union all select case when assigning = $3 then quote_unit else base_unit end, case when assigning = $3 then value * price else value end from orders where user_id = $1 and (type = $2 or type || ':' || base_unit || '/' || quote_unit = $2) and status = $4) h group by symbol`, userId, wallet, types.AssigningBuy, types.StatusPending)
+	if err != nil {
+		return &margin, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			symbol string
+			value  decimal.Float
+		)
+
+		if err := rows.Scan(&symbol, &value); err != nil {
+			return &margin, err
+		}
+
+		if price, ok := a.queryConvert(symbol); ok {
+			assets = assets.Add(value.Mul(price))
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return &margin, err
+	}
+	_ = rows.Close()
+
+	rows, err = a.Context.Db.Query(`select id, user_id, symbol, type, principal, interest, create_at from loans where user_id = $1 and type = $2 order by symbol`, userId, wallet)
+	if err != nil {
+		return &margin, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			item                types.Loan
+			principal, interest decimal.Float
+		)
+
+		if err := rows.Scan(&item.Id, &item.UserId, &item.Symbol, &item.Type, &principal, &interest, &item.CreateAt); err != nil {
+			return &margin, err
+		}
+		item.Principal, item.Interest = principal.Float(), interest.Float()
+
+		// A loan is only granted for an asset with a price, a loan whose asset lost its price can not be valued and blocks the account.
+		price, ok := a.queryConvert(item.GetSymbol())
+		if !ok {
+			return &margin, status.Errorf(11666, "the asset %v has no price in %v", item.GetSymbol(), reference)
+		}
+		liabilities = liabilities.Add(principal.Add(&interest).Mul(price))
+
+		margin.Loans = append(margin.Loans, &item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return &margin, err
+	}
+
+	margin.Assets, margin.Liabilities = assets.Float(), liabilities.Float()
+	margin.Equity = assets.Sub(liabilities).Float()
+
+	if liabilities.IsPositive() {
+		margin.Level = assets.Div(liabilities).Float()
+	}
+
+	return &margin, nil
+}
+
+// queryValidateMargin - This function checks that the liabilities of the margin account stay within the leverage limit: the
+// liabilities may reach (leverage - 1) times the equity, so the assets may reach leverage times the equity. The assets and
+// the liabilities are the values in the reference unit that the account is about to change by.
+func (a *Service) queryValidateMargin(margin *types.Margin, assets, liabilities float64) error {
+
+	var (
+		equity = decimal.New(margin.GetAssets()).Add(assets).Sub(decimal.New(margin.GetLiabilities()).Add(liabilities))
+		total  = decimal.New(margin.GetLiabilities()).Add(liabilities)
+	)
+
+	if !total.IsPositive() {
+		return nil
+	}
+
+	if !equity.IsPositive() || total.GreaterThan(equity.Mul(leverage-1).Decimal) {
+		return status.Errorf(11667, "the liabilities of the %v margin account would exceed the leverage limit of %vx", margin.GetType(), leverage)
+	}
+
+	return nil
+}
+
+// writeLock - This function serializes the changes of the margin account of the user (transfers, loans and repayments) until the
+// end of the given transaction, so that two concurrent requests can not both pass the check of the leverage limit.
+func (a *Service) writeLock(tx *sql.Tx, userId int64, wallet string) error {
+
+	if _, err := tx.Exec("select pg_advisory_xact_lock($1::integer, hashtext($2));", userId, wallet); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeTransfer - This function moves the quantity of the asset between the spot balance of the user and the margin account in
+// one database transaction. A deposit moves it from the spot balance into the margin account, a withdrawal moves it back
+// and is only allowed while the liabilities of the account stay within the leverage limit without the quantity.
+func (a *Service) writeTransfer(userId int64, symbol, wallet, assignment string, quantity float64) error {
+
+	var (
+		from, to = types.TypeSpot, wallet
+	)
+
+	if assignment == types.AssignmentWithdrawal {
+		from, to = wallet, types.TypeSpot
+	}
+
+	if err := a.queryValidateWallet(wallet, symbol); err != nil {
+		return err
+	}
+
+	// An asset without a price is not counted as collateral, so its withdrawal does not change the margin level.
+	price, _ := a.queryConvert(symbol)
+
+	if err := a.writeAsset(symbol, to, userId, false); err != nil {
+		return err
+	}
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := a.writeLock(tx, userId, wallet); err != nil {
+		return err
+	}
+
+	// The withdrawal is checked while the account is locked, the collateral that leaves the account lowers its assets.
+	if assignment == types.AssignmentWithdrawal {
+
+		margin, err := a.queryMargin(userId, wallet)
+		if err != nil {
+			return err
+		}
+
+		if err := a.queryValidateMargin(margin, -decimal.New(quantity).Mul(price).Float(), 0); err != nil {
+			return err
+		}
+	}
+
+	if err := a.writeBalance(tx, symbol, from, userId, decimal.New(quantity), types.BalanceMinus, 0); err != nil {
+		return err
+	}
+
+	if err := a.writeBalance(tx, symbol, to, userId, decimal.New(quantity), types.BalancePlus, 0); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeBorrow - This function lends the quantity of the asset to the margin account of the user. The asset has to be enabled for
+// margin trading, the lending pool of the asset has to have the quantity available, and the liabilities of the account
+// including the new loan have to stay within the leverage limit. The borrowed quantity is credited to the margin account
+// and added to the principal of the loan of the asset, the interest is accrued on the principal every hour by the
+// interest worker.
+func (a *Service) writeBorrow(userId int64, symbol, wallet string, quantity float64) error {
+
+	var (
+		enabled bool
+	)
+
+	if err := a.Context.Db.QueryRow("select margin from assets where symbol = $1 and status = $2", symbol, true).Scan(&enabled); err != nil || !enabled {
+		return status.Errorf(11665, "the asset %v can not be borrowed", symbol)
+	}
+
+	if err := a.queryValidateWallet(wallet, symbol); err != nil {
+		return err
+	}
+
+	price, ok := a.queryConvert(symbol)
+	if !ok {
+		return status.Errorf(11666, "the asset %v has no price in %v", symbol, reference)
+	}
+
+	if err := a.writeAsset(symbol, wallet, userId, false); err != nil {
+		return err
+	}
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := a.writeLock(tx, userId, wallet); err != nil {
+		return err
+	}
+
+	// The loan is taken from the lending pool of the asset, the pool is locked after the account, like in every loan.
+	if err := a.writeLock(tx, 0, fmt.Sprintf("%v:%v", types.TypeLending, symbol)); err != nil {
+		return err
+	}
+
+	if err := a.queryValidatePool(symbol, quantity); err != nil {
+		return err
+	}
+
+	margin, err := a.queryMargin(userId, wallet)
+	if err != nil {
+		return err
+	}
+
+	// The borrowed quantity raises the assets and the liabilities by the same value, only the liabilities are limited.
+	value := decimal.New(quantity).Mul(price).Float()
+	if err := a.queryValidateMargin(margin, value, value); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("insert into loans (user_id, symbol, type, principal) values ($1, $2, $3, $4) on conflict (user_id, symbol, type) do update set principal = loans.principal + excluded.principal;", userId, symbol, wallet, decimal.New(quantity).String()); err != nil {
+		return err
+	}
+
+	if err := a.writeBalance(tx, symbol, wallet, userId, decimal.New(quantity), types.BalancePlus, 0); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeRepay - This function repays the loan of the asset from the margin account of the user to the lending pool. The accrued
+// interest is paid first, its reserve share is collected like the trading fees of the asset and the rest stays in the
+// pool, the suppliers were credited with it when it accrued. The rest of the quantity decreases the principal. A quantity
+// larger than the debt only repays the debt, a loan that is repaid completely is removed.
+func (a *Service) writeRepay(userId int64, symbol, wallet string, quantity float64) error {
+
+	var (
+		principal, interest, reserve decimal.Float
+	)
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := a.writeLock(tx, userId, wallet); err != nil {
+		return err
+	}
+
+	if err := tx.QueryRow("select principal, interest, reserve from loans where user_id = $1 and symbol = $2 and type = $3 for update;", userId, symbol, wallet).Scan(&principal, &interest, &reserve); err != nil {
+		return status.Errorf(11668, "there is no loan of the asset %v", symbol)
+	}
+
+	amount := decimal.New(quantity)
+	if debt := principal.Add(&interest); amount.GreaterThan(debt.Decimal) {
+		amount = debt
+	}
+
+	paid := amount
+	if paid.GreaterThan(interest.Decimal) {
+		paid = decimal.New(&interest)
+	}
+
+	if err := a.writeBalance(tx, symbol, wallet, userId, amount, types.BalanceMinus, 0); err != nil {
+		return err
+	}
+
+	// The reserve share of the paid interest is the share of the accrued reserve that the payment covers.
+	share := decimal.New(0)
+	if interest.IsPositive() {
+		share = reserve.Mul(paid).Div(&interest)
+	}
+
+	if _, err := tx.Exec("update loans set interest = interest - $4, reserve = reserve - $6, principal = principal - $5 where user_id = $1 and symbol = $2 and type = $3;", userId, symbol, wallet, paid.String(), amount.Sub(paid).String(), share.String()); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("delete from loans where user_id = $1 and symbol = $2 and type = $3 and principal = 0 and interest = 0;", userId, symbol, wallet); err != nil {
+		return err
+	}
+
+	// The reserve share is the income of the exchange, it is collected in the same way as the trading fees of the asset.
+	if share.IsPositive() {
+		if _, err := tx.Exec("update assets set fees_charges = fees_charges + $2 where symbol = $1;", symbol, share.String()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// writeInterest - This function accrues one hour of interest on every loan whose last accrual is at least an hour old. The rate is
+// the borrow rate of the lending pool of the asset at its current utilization, a daily rate in percent, one hour accrues
+// a 24th of it on the principal. The reserve share of the interest is kept on the loan for the exchange, the rest is
+// credited to the suppliers of the pool as their yield. Every accrual is recorded in the interests table. The time of the
+// accrual advances by exactly one hour, so the hours missed while the service was stopped are caught up one by one.
+func (a *Service) writeInterest() (accrued int64, err error) {
+
+	var (
+		symbols []string
+	)
+
+	rows, err := a.Context.Db.Query(`select distinct symbol from loans where accrue_at <= now() - interval '1 hour' order by symbol`)
+	if err != nil {
+		return accrued, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			symbol string
+		)
+
+		if err := rows.Scan(&symbol); err != nil {
+			return accrued, err
+		}
+
+		symbols = append(symbols, symbol)
+	}
+
+	if err := rows.Err(); err != nil {
+		return accrued, err
+	}
+	_ = rows.Close()
+
+	for _, symbol := range symbols {
+
+		var (
+			count    int64
+			interest decimal.Float
+		)
+
+		pool, err := a.queryPool(symbol)
+		if err != nil {
+			return accrued, err
+		}
+
+		rate, reserve := decimal.New(pool.GetBorrowRate()).Round(4), decimal.New(pool.GetReserveRate())
+
+		tx, err := a.Context.Db.Begin()
+		if err != nil {
+			return accrued, err
+		}
+
+		if err := tx.QueryRow(`with accrued as (update loans set interest = interest + principal * $2 / 2400, reserve = reserve + principal * $2 / 2400 * $3, accrue_at = accrue_at + interval '1 hour' where symbol = $1 and accrue_at <= now() - interval '1 hour' returning id, user_id, symbol, type, principal * $2 / 2400 as quantity), recorded as (insert into interests (loan_id, user_id, symbol, type, quantity, rate) select id, user_id, symbol, type, quantity, $2 from accrued where quantity > 0 returning quantity) select count(*), coalesce(sum(quantity), 0) from recorded`, symbol, rate.String(), reserve.String()).Scan(&count, &interest); err != nil {
+			_ = tx.Rollback()
+			return accrued, err
+		}
+
+		if err := a.writeYield(tx, pool, interest.Sub(interest.Mul(reserve))); err != nil {
+			_ = tx.Rollback()
+			return accrued, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return accrued, err
+		}
+		accrued += count
+	}
+
+	return accrued, nil
+}
+
+// writeCall - This function sends a margin call when the margin level of the margin account fell below the call level: the user
+// receives a mail and the state of the account is published to the margin/call channel. The call is repeated at most once
+// an hour while the level stays below, and the call is cleared once the level recovers.
+func (a *Service) writeCall(margin *types.Margin) error {
+
+	var (
+		calling bool
+		migrate = query.Migrate{
+			Context: a.Context,
+		}
+	)
+
+	if margin.GetLiabilities() == 0 || margin.GetLevel() >= callLevel {
+		_, err := a.Context.Db.Exec("update loans set call_at = null where user_id = $1 and type = $2 and call_at is not null;", margin.GetUserId(), margin.GetType())
+		return err
+	}
+
+	if err := a.Context.Db.QueryRow("update loans set call_at = now() where user_id = $1 and type = $2 and not exists (select id from loans where user_id = $1 and type = $2 and call_at > now() - interval '1 hour') returning true", margin.GetUserId(), margin.GetType()).Scan(&calling); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	a.Context.Logger.Infof("[MARGIN]: user ID: %v margin call, type: %v, level: %v", margin.GetUserId(), margin.GetType(), margin.GetLevel())
+
+	go migrate.SendMail(margin.GetUserId(), "margin_call", margin.GetType(), margin.GetLevel())
+
+	return a.Context.Publish(margin, "exchange", "margin/call")
+}
+
+// liquidate - This function liquidates the isolated margin wallet of a pair whose margin level fell below the liquidation level.
+// The open orders of the wallet are cancelled first, then the collateral is traded on the book of the pair with the orders
+// of writeLiquidateOrder: a loan of the base unit is covered by buying the outstanding debt and is repaid right away, then a
+// loan of the quote unit is covered by selling the base balance that is left and is repaid. A part of a loan that the
+// wallet can not repay stays open, an order that could not be placed is returned as an error after the repayment. Only the wallet of the pair is touched, the other holdings of
+// the user are never used. The user receives a mail and the result is published to the margin/liquidation channel.
+func (a *Service) liquidate(margin *types.Margin) error {
+
+	var (
+		failed  error
+		migrate = query.Migrate{
+			Context: a.Context,
+		}
+	)
+
+	base, quote, ok := a.queryWalletPair(margin.GetType())
+	if !ok {
+		return nil
+	}
+
+	a.Context.Logger.Infof("[MARGIN]: user ID: %v liquidation, type: %v, level: %v", margin.GetUserId(), margin.GetType(), margin.GetLevel())
+
+	if err := a.writeLiquidateOrders(margin.GetUserId(), base, quote); err != nil {
+		return err
+	}
+
+	// The loan of the base unit is covered and repaid first, so the base unit bought for it is not sold again for the quote loan.
+	for _, assigning := range []string{types.AssigningBuy, types.AssigningSell} {
+
+		var (
+			symbol = base
+		)
+
+		if assigning == types.AssigningSell {
+			symbol = quote
+		}
+
+		for _, loan := range margin.GetLoans() {
+
+			if loan.GetSymbol() != symbol {
+				continue
+			}
+
+			// A wallet that can not be traded anymore (no liquidity, a halted pair) still repays what it holds, the rejected
+			// order is reported once the wallet has repaid what it could.
+			if err := a.writeLiquidateOrder(margin.GetUserId(), assigning, base, quote, margin.GetType()); err != nil && failed == nil {
+				failed = status.Errorf(11675, "the liquidation order of the wallet %v was not placed: %v", margin.GetType(), err)
+			}
+
+			if balance := a.QueryBalance(symbol, margin.GetType(), margin.GetUserId()); balance > 0 {
+				a.Context.Debug(a.writeRepay(margin.GetUserId(), symbol, margin.GetType(), balance))
+			}
+		}
+	}
+
+	result, err := a.queryMargin(margin.GetUserId(), margin.GetType())
+	if err != nil {
+		return err
+	}
+
+	go migrate.SendMail(margin.GetUserId(), "margin_liquidation", margin.GetType(), margin.GetLevel())
+
+	if err := a.Context.Publish(result, "exchange", "margin/liquidation"); err != nil {
+		return err
+	}
+
+	return failed
+}
+
+// writeLiquidateOrder - This function places the order that covers a loan of an isolated margin wallet. A loan of the base unit is
+// covered by an immediate or cancel limit buy of the outstanding principal and interest that the wallet does not hold yet,
+// priced at the level of the book that fills it, a fill at a better price returns the difference to the wallet. The
+// quantity is limited to what the quote balance of the wallet pays at that price. A loan of the quote unit is covered
+// by a market sell of the base balance. Both quantities are rounded down to the step size of the pair, nothing is placed
+// when less than one step is left.
+func (a *Service) writeLiquidateOrder(userId int64, assigning, base, quote, wallet string) error {
+
+	order := types.Order{
+		UserId:    userId,
+		BaseUnit:  base,
+		QuoteUnit: quote,
+		Assigning: assigning,
+		Trading:   types.TradingMarket,
+		Type:      types.TypeIsolated,
+	}
+
+	_, step, _, _, err := a.queryRules(&order)
+	if err != nil {
+		return err
+	}
+
+	quantity := a.queryBalance(base, wallet, userId)
+
+	if assigning == types.AssigningBuy {
+
+		var (
+			debt decimal.Float
+		)
+
+		if err := a.Context.Db.QueryRow("select principal + interest from loans where user_id = $1 and symbol = $2 and type = $3", userId, base, wallet).Scan(&debt); err != nil {
+			return err
+		}
+
+		if quantity = debt.Sub(quantity); !quantity.IsPositive() {
+			return nil
+		}
+
+		// The price is the level of the asks that fills the quantity, read from the locked book of the pair.
+		book, err := a.queryBook(base, quote, order.GetType())
+		if err != nil {
+			return err
+		}
+		owner, _ := a.queryOwner(userId)
+		price := book.Reach(&orderbook.Order{UserId: userId, Owner: owner, Assigning: assigning}, quantity)
+		a.unlockBook(book, base, quote, order.GetType())
+
+		if !price.IsPositive() {
+			return status.Error(11655, "there is no liquidity to fill the market order")
+		}
+
+		if funds := a.queryBalance(quote, wallet, userId); quantity.Mul(price).GreaterThan(funds.Decimal) {
+			quantity = funds.Div(price)
+		}
+
+		order.Trading, order.TimeInForce = types.TradingLimit, types.TimeInForceIoc
+		order.Price, order.PriceDecimal = price.Float(), price.String()
+	}
+
+	if quantity = quantity.Div(step).Floor(0).Mul(step); !quantity.IsPositive() {
+		return nil
+	}
+	order.Quantity, order.QuantityDecimal = quantity.Float(), quantity.String()
+
+	return a.placeOrder(&order, 0)
+}
+
+// writeLiquidateOrders - This function cancels the pending and dormant isolated margin orders of the user on the pair, the book of
+// the pair is locked while the orders are cancelled and their reserved funds return to the wallet.
+func (a *Service) writeLiquidateOrders(userId int64, base, quote string) error {
+
+	var (
+		orders []*types.Order
+	)
+
+	rows, err := a.Context.Db.Query(`select id, value, quantity, price, assigning, base_unit, quote_unit, user_id, type, status, create_at from orders where user_id = $1 and base_unit = $2 and quote_unit = $3 and type = $4 and (status = $5 or status = $6) order by id`, userId, base, quote, types.TypeIsolated, types.StatusPending, types.StatusDormant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var (
+			item types.Order
+		)
+
+		if err := rows.Scan(&item.Id, &item.Value, &item.Quantity, &item.Price, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.UserId, &item.Type, &item.Status, &item.CreateAt); err != nil {
+			return err
+		}
+
+		orders = append(orders, &item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	if len(orders) == 0 {
+		return nil
+	}
+
+	book, err := a.queryBook(base, quote, types.TypeIsolated)
+	if err != nil {
+		return err
+	}
+	defer a.unlockBook(book, base, quote, types.TypeIsolated)
+
+	for _, item := range orders {
+		if err := a.cancel(item, book, types.EventCancel); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,256 +2,850 @@ package provider
 
 import (
 	"context"
+	"math"
+	"strings"
+	"time"
 
+	"github.com/cryptogateway/backend-envoys/assets/common/decimal"
+	"github.com/cryptogateway/backend-envoys/assets/common/orderbook"
 	"github.com/cryptogateway/backend-envoys/assets/common/query"
 	"github.com/cryptogateway/backend-envoys/server/proto/v2/pbprovider"
 	"github.com/cryptogateway/backend-envoys/server/types"
+	"github.com/lib/pq"
 	"google.golang.org/grpc/status"
 )
 
-// trade - This function is used to replay a trade init. It takes an order and a side (BID or ASK) as parameters. It then queries
-// the database for orders with the same base unit, quote unit and user ID, and with a status of "PENDING". It then
-// iterates through the results and checks if the order's price is higher than the item's price for a BID position and
-// lower for an ASK position. If this is the case, it calls the replayTradeProcess() function. Finally, it logs any matches or failed matches.
-func (a *Service) trade(order *types.Order, assigning string) {
+// placeOrder - This function places an active market or limit order. It checks the order with queryValidateOrder, locks the
+// book of the pair and rejects a post-only order that would take liquidity or a fill or kill order that can not be filled
+// completely, then writes the order and takes the reserved quantity from the locked balance in one database transaction,
+// and finally passes the committed order to the matching engine. It is used by SetOrder and by the stop worker when a stop order is triggered,
+// so a triggered stop order goes through exactly the same balance checks as a new order. The slippage is the largest
+// relative distance from the best price of the book that a market order may reach, zero means no limit.
+func (a *Service) placeOrder(order *types.Order, slippage float64) error {
+
+	// The value of the order is the quantity that is still open, before any match it is the whole quantity.
+	order.Value, order.ValueDecimal = order.GetQuantity(), order.GetQuantityDecimal()
+
+	// The Status is set to PENDING and the CreateAt is set to the current time.
+	order.Status = types.StatusPending
+	order.CreateAt = time.Now().UTC().Format(time.RFC3339)
+
+	// The fee tier of the user is resolved before the book is locked, so that the fills of the order read it from the cache
+	// instead of summing the trading volume of the user inside the settlement.
+	if _, err := a.queryTier(order.GetUserId(), order.GetBaseUnit(), order.GetQuoteUnit()); err != nil {
+		return err
+	}
 
-	// This code is checking for an error when publishing to the exchange. If an error occurs, the code is printing out the
-	// error and returning.
-	if err := a.Context.Publish(order, "exchange", "order/create"); a.Context.Debug(err) {
-		return
+	// The book of the pair is locked before the order is priced and written, so the book that a market order was sized
+	// against and the flags checked below still hold when the order is matched: nothing can change the book between the
+	// check and the match.
+	book, err := a.queryBook(order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType())
+	if err != nil {
+		return err
 	}
+	defer a.unlockBook(book, order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType())
 
-	// This code is querying the "orders" table in a database for data that matches the given parameters. It is using the
-	// parameters given to query for a specific set of data from the "orders" table. It is using the $1, $2, $3, $4, $5 and
-	// $6 to represent the given parameters. The query is also ordering the results by the "id" column. It is checking for
-	// errors and deferring the closing of the rows.
-	rows, err := a.Context.Db.Query(`select id, assigning, base_unit, quote_unit, value, quantity, price, user_id, type, status from orders where assigning = $1 and base_unit = $2 and quote_unit = $3 and user_id != $4 and type = $5 and status = $6 order by id`, assigning, order.GetBaseUnit(), order.GetQuoteUnit(), order.GetUserId(), order.GetType(), types.StatusPending)
-	if a.Context.Debug(err) {
-		return
+	// During the call auction of the pair the orders are only collected in the book, so an order that can not rest there (a
+	// market order or an immediate order) is rejected.
+	auction := a.queryAuction(order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType())
+	if auction && (order.GetTrading() == types.TradingMarket || order.GetTimeInForce() == types.TimeInForceIoc || order.GetTimeInForce() == types.TimeInForceFok) {
+		return status.Error(11663, "only limit orders that rest in the book are accepted during the call auction of the pair")
 	}
-	defer rows.Close()
 
-	// The purpose of the for loop is to iterate over a set of rows from a database query. The rows.Next() function advances
-	// the iterator to the next row in the result set, returning false when there are no more rows to iterate over.
-	for rows.Next() {
+	// This is a switch statement that is used to evaluate the trade type of the order. The price of a limit order is the limit
+	// price given by the user, a market order is sized and priced by walking the levels of the book.
+	switch order.GetTrading() {
+	case types.TradingMarket:
+		if err := a.sweep(order, book, slippage); err != nil {
+			return err
+		}
+		break
+	case types.TradingLimit:
+		break
+	default:
+		return status.Error(82284, "invalid type trade position")
+	}
 
-		// Item is a variable of type Order from the types package, used to store a reference to an Order.
-		var (
-			item types.Order
-		)
+	// This code is checking for an error in the queryValidateOrder() function and if one is found, it returns the error. The
+	// quantity variable is used to store the result of queryValidateOrder(), which is the quantity reserved for the order.
+	quantity, err := a.queryValidateOrder(order)
+	if err != nil {
+		return err
+	}
 
-		// This code is attempting to scan the rows of a database table, assigning each column value to a variable (item.Id,
-		// item.Assigning, etc.). The if statement is checking for any errors that might occur when scanning the rows and
-		// returning any errors that might be present.
-		if err = rows.Scan(&item.Id, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.Value, &item.Quantity, &item.Price, &item.UserId, &item.Type, &item.Status); a.Context.Debug(err) {
-			return
+	taker := a.queryTaker(order)
+
+	// A post-only order must only add liquidity, it is rejected when it would trade against the opposite side right away. Nothing
+	// trades during the call auction, so the order is accepted there.
+	if order.GetPostOnly() && !auction && book.Marketable(taker) {
+		return status.Error(11644, "the post-only order would take liquidity and was rejected")
+	}
+
+	// A fill or kill order is rejected when the opposite side of the book can not fill its whole quantity at once, a market
+	// order was already checked by sweep.
+	if order.GetTimeInForce() == types.TimeInForceFok && order.GetTrading() == types.TradingLimit && book.Fillable(taker).LessThan(taker.Value.Decimal) {
+		return status.Error(11645, "the fill or kill order can not be filled completely and was rejected")
+	}
+
+	// The placement is settled in one database transaction: the order is written and the reserved quantity is taken from the
+	// locked balance together, if any step fails nothing is persisted. The transaction is rolled back when the function
+	// returns without a commit.
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// This is a conditional statement used to set a new order (or to activate a triggered stop order) and check for any
+	// errors that might occur.
+	if order.Id, err = a.writeOrder(tx, order); err != nil {
+		return err
+	}
+
+	// The accepted order is written to the journal of the pair before its reserved quantity is taken from the balance.
+	journal, err := a.writeJournal(tx, types.EventAccepted, order, 0, &taker.Price, &taker.Value)
+	if err != nil {
+		return err
+	}
+
+	// The switch statement is used to evaluate the value of the expression "order.GetAssigning()" and execute the
+	// corresponding case statement. It is a type of conditional statement that allows a program to make decisions based on different conditions.
+	switch order.GetAssigning() {
+	case types.AssigningBuy:
+
+		// This code snippet is likely a part of a function that processes an order. The purpose of the code is to use the
+		// function "writeAsset()" to set the base unit and user ID of the order to false. If an error occurs during the process,
+		// the code will return the error.
+		if err := a.writeAsset(order.GetBaseUnit(), a.queryWallet(order), order.GetUserId(), false); err != nil {
+			return err
 		}
 
-		// Check if the order is in a pending status and update the order value accordingly.
-		if row := a.queryOrder(order.GetId()); row.GetStatus() == types.StatusPending {
-			order.Value = row.GetValue()
+		// This code is checking the locked balance of a user and attempting to subtract the specified quantity from it. If the
+		// operation is successful, it will continue with the program. If an error occurs, it will return the error.
+		if err := a.writeBalance(tx, order.GetQuoteUnit(), a.queryWallet(order), order.GetUserId(), quantity, types.BalanceMinus, journal); err != nil {
+			return err
 		}
 
-		// This switch statement is used to check for a match between the order and item prices, depending on the side of the
-		// trade (bid or ask). If the order and item prices match, the trade process is replayed. If not, a message is logged
-		// for the user. If the side is invalid, an error is returned.
-		switch assigning {
+		break
+	case types.AssigningSell:
 
-		case types.AssigningBuy: // Buy at BID price.
+		// This code snippet is likely a part of a function that processes an order. The purpose of the code is to use the
+		// function "writeAsset()" to set the base unit and user ID of the order to false. If an error occurs during the process,
+		// the code will return the error.
+		if err := a.writeAsset(order.GetQuoteUnit(), a.queryWallet(order), order.GetUserId(), false); err != nil {
+			return err
+		}
 
-			// This code checks whether the price of an order is greater than or equal to the price of an item. If it is, it will
-			// log a message and call the replayTradeProcess function. If it is not, it will log another message.
-			if order.GetPrice() >= item.GetPrice() {
-				a.Context.Logger.Infof("[BID]: (item [%v]) >= (order [%v]), order ID: %v", order.GetPrice(), item.GetPrice(), item.GetId())
+		// This code is checking the locked balance of a user and attempting to subtract the specified quantity from it. If the
+		// operation is successful, it will continue with the program. If an error occurs, it will return the error.
+		if err := a.writeBalance(tx, order.GetBaseUnit(), a.queryWallet(order), order.GetUserId(), quantity, types.BalanceMinus, journal); err != nil {
+			return err
+		}
 
-				// A switch statement is used to evaluate the type of order provided and executes the appropriate processing.
-				// If the order type is either Spot or Stock, the defaultProcess function is called; otherwise the marginProcess function is called if the order type is Margin.
-				switch order.GetType() {
-				case types.TypeSpot, types.TypeStock:
-					a.defaultProcess(types.AssigningBuy, order, &item)
-					break
-				case types.TypeCross:
-					a.marginProcess(types.AssigningBuy, order, &item)
-					break
-				}
+		break
+	default:
+		return status.Error(11588, "invalid assigning trade position")
+	}
 
-			} else {
-				a.Context.Logger.Infof("[BID]: no matches found: (item [%v]) >= (order [%v])", order.GetPrice(), item.GetPrice())
-			}
+	// The order and its reserved balance are committed together, only a committed order is passed to the matching engine.
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
-			break
+	// This code is checking for an error when publishing to the exchange, the error is only logged.
+	a.Context.Debug(a.Context.Publish(order, "exchange", "order/create"))
 
-		case types.AssigningSell: // Sell at ASK price.
+	a.trade(order, book)
 
-			// This code is checking if the price of an order is lower than or equal to the price of an item. If it is, it will
-			// log an informational message and call the replayTradeProcess() method. If not, it will log a different
-			// informational message.
-			if order.GetPrice() <= item.GetPrice() {
-				a.Context.Logger.Infof("[ASK]: (order [%v]) <= (item [%v]), order ID: %v", order.GetPrice(), item.GetPrice(), item.GetId())
+	return nil
+}
 
-				// A switch statement is used to evaluate the type of order provided and executes the appropriate processing.
-				// If the order type is either Spot or Stock, the defaultProcess function is called; otherwise the marginProcess function is called if the order type is Margin.
-				switch order.GetType() {
-				case types.TypeSpot, types.TypeStock:
-					a.defaultProcess(types.AssigningSell, order, &item)
-					break
-				case types.TypeCross:
-					a.marginProcess(types.AssigningSell, order, &item)
-					break
-				}
+// sweep - This function sizes and prices a market order against the locked book of its pair. The levels of the opposite side
+// are walked from the best price until the quantity of the order is used up, or until the limit price given with the
+// order or the slippage from the best price is reached, whichever is closer. The quantity of a market buy order is a
+// budget in the quote unit: the order is converted into the base quantity that the budget buys, and its price is set to
+// the spent budget per unit. A market sell order is limited to the base quantity that the book can take, at the worst
+// price reached. The price of a buy order is its average price rounded up to the tick size and its base quantity is rounded
+// down to the step size of the pair, so it follows the same rules as any other order; the levels are walked from the
+// best price, so the rounded quantity costs at most its average price per unit and the reserved part of the budget
+// covers it.
+func (a *Service) sweep(order *types.Order, book *orderbook.Book, slippage float64) error {
+
+	opposite := types.AssigningSell
+	if order.GetAssigning() == types.AssigningSell {
+		opposite = types.AssigningBuy
+	}
 
-			} else {
-				a.Context.Logger.Infof("[ASK]: no matches found: (order [%v]) <= (item [%v])", order.GetPrice(), item.GetPrice())
-			}
+	best, ok := book.Best(opposite)
+	if !ok {
+		return status.Error(11655, "there is no liquidity to fill the market order")
+	}
 
-			break
-		default:
-			if err := a.Context.Debug(status.Error(11589, "invalid assigning trade position")); err {
-				return
-			}
+	ref, band, halted, err := a.queryBand(order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType())
+	if err != nil {
+		return err
+	}
+
+	if halted {
+		return status.Errorf(11661, "the trading of the pair %v-%v is halted", order.GetBaseUnit(), order.GetQuoteUnit())
+	}
+
+	// The limit price of the walk is the stricter one of the limit price given with the order, the slippage bound and the
+	// edge of the price band of the pair.
+	limit := a.queryExact(order.GetPriceDecimal(), order.GetPrice())
+	if band > 0 && ref > 0 {
+
+		edge := decimal.New(ref).Mul(decimal.New(100).Add(band).Div(100))
+		if order.GetAssigning() == types.AssigningSell {
+			edge = decimal.New(ref).Mul(decimal.New(100).Sub(band).Div(100))
+		}
+
+		if limit.IsZero() || (order.GetAssigning() == types.AssigningBuy && edge.LessThan(limit.Decimal)) || (order.GetAssigning() == types.AssigningSell && edge.GreaterThan(limit.Decimal)) {
+			limit = edge
 		}
 	}
 
-	// The purpose of this code is to check for errors when using the rows.Err() function. It returns an error if there is
-	// one and returns the function if this is the case.
-	if err = rows.Err(); a.Context.Debug(err) {
-		return
+	if slippage > 0 {
+
+		bound := best.Mul(decimal.New(1).Add(slippage))
+		if order.GetAssigning() == types.AssigningSell {
+			bound = best.Mul(decimal.New(1).Sub(slippage))
+		}
+
+		if limit.IsZero() || (order.GetAssigning() == types.AssigningBuy && bound.LessThan(limit.Decimal)) || (order.GetAssigning() == types.AssigningSell && bound.GreaterThan(limit.Decimal)) {
+			limit = bound
+		}
+	}
+
+	// The walk follows the self-trade prevention of the order in the same way as the match.
+	taker := a.queryTaker(order)
+	taker.Price = *limit
+
+	budget := a.queryExact(order.GetQuantityDecimal(), order.GetQuantity())
+
+	quantity, price, rest := book.Sweep(taker, budget)
+	if quantity.IsZero() {
+		return status.Error(11655, "there is no liquidity to fill the market order within its price limit")
+	}
+
+	// A fill or kill market order is rejected when a part of its quantity could not be filled within the limit.
+	if order.GetTimeInForce() == types.TimeInForceFok && rest.IsPositive() {
+		return status.Error(11645, "the fill or kill order can not be filled completely and was rejected")
+	}
+
+	switch order.GetAssigning() {
+	case types.AssigningBuy:
+
+		tick, step, _, _, err := a.queryRules(order)
+		if err != nil {
+			return err
+		}
+
+		// The price is the spent budget per unit rounded up to the tick size, so the reservation at this price covers the
+		// levels that were walked and the difference is refunded by trade.
+		price = budget.Sub(rest).Div(quantity).Div(tick).Ceil(0).Mul(tick)
+
+		// The base quantity is limited to what the budget pays at that price and rounded down to the step size, a budget that
+		// buys less than one step is rejected.
+		if limit := budget.Div(price); limit.LessThan(quantity.Decimal) {
+			quantity = limit
+		}
+
+		quantity = quantity.Div(step).Floor(0).Mul(step)
+		if quantity.IsZero() {
+			return status.Errorf(11658, "the budget of the market order buys less than the step size %v", step)
+		}
+
+		break
+	}
+	order.Price, order.PriceDecimal = price.Float(), price.String()
+	order.Quantity, order.QuantityDecimal = quantity.Float(), quantity.String()
+	order.Value, order.ValueDecimal = quantity.Float(), quantity.String()
+
+	// A market order never rests in the book, a remainder that is not filled is cancelled and its funds are returned.
+	if order.GetTimeInForce() != types.TimeInForceFok {
+		order.TimeInForce = types.TimeInForceIoc
 	}
+
+	return nil
+}
+
+// placeStop - This function places a stop-limit or stop-market order. A stop order stays dormant: it is not added to the book
+// and no balance is reserved for it. The balance is only checked here as an estimate, with the limit price of a stop-limit
+// order or the trigger price of a stop-market order, the real check is made by placeOrder when the order is triggered.
+func (a *Service) placeStop(order *types.Order) error {
+
+	// This code checks if the trigger price of the order is positive, a stop order without a trigger would never be activated.
+	if order.GetTriggerPrice() <= 0 {
+		return status.Errorf(11643, "impossible trigger price %v", order.GetTriggerPrice())
+	}
+
+	// The estimate is a copy of the order as it would be placed at the moment of the trigger.
+	estimate := types.Order{
+		UserId:    order.GetUserId(),
+		BaseUnit:  order.GetBaseUnit(),
+		QuoteUnit: order.GetQuoteUnit(),
+		Assigning: order.GetAssigning(),
+		Type:      order.GetType(),
+		Trading:   types.TradingLimit,
+		Price:     order.GetPrice(),
+		Quantity:  order.GetQuantity(),
+	}
+
+	// A stop-market order has no price of its own, it is expected to be executed near the trigger price. The quantity of a
+	// market buy order is given in the quote unit, so it is converted into the base unit as in placeOrder.
+	if order.GetTrading() == types.TradingStopMarket {
+		order.Price, estimate.Price, estimate.Trading = 0, order.GetTriggerPrice(), types.TradingMarket
+		if order.GetAssigning() == types.AssigningBuy {
+			estimate.Quantity = decimal.New(order.GetQuantity()).Div(order.GetTriggerPrice()).Float()
+		}
+	}
+
+	if _, err := a.queryValidateOrder(&estimate); err != nil {
+		return err
+	}
+
+	order.Value, order.ValueDecimal = order.GetQuantity(), order.GetQuantityDecimal()
+	order.Status = types.StatusDormant
+	order.CreateAt = time.Now().UTC().Format(time.RFC3339)
+
+	// The book of the pair is locked while the dormant order is journaled, the journal of the pair is numbered under this lock.
+	book, err := a.queryBook(order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType())
+	if err != nil {
+		return err
+	}
+	defer a.unlockBook(book, order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType())
+
+	// The dormant order is written in its own transaction, no balance is taken for it.
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if order.Id, err = a.writeOrder(tx, order); err != nil {
+		return err
+	}
+
+	// The dormant order is written to the journal of the pair, so that its cancellation or its trigger can be replayed.
+	if _, err := a.writeJournal(tx, types.EventDormant, order, 0, decimal.New(order.GetPrice()), decimal.New(order.GetValue())); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// This code is checking for an error when publishing to the exchange, the error is only logged.
+	a.Context.Debug(a.Context.Publish(order, "exchange", "order/create"))
+
+	return nil
 }
 
-// defaultProcess - This function is used to replay a trade process. It updates two orders with different amounts to determine the result
-// of a trade. It updates the order status in the database with pending in to filled, updates the balance by adding the
-// amount of the order to the balance, and sends a mail. In addition, it logs information about the trade.
-func (a *Service) defaultProcess(assigning string, params ...*types.Order) {
+// trade - This function is used to match a new order against the in-memory order book of its pair, the book is locked by the
+// caller. The book returns the fills in price-time priority: the best price level first and, inside a level, the oldest
+// order first, every fill is executed at the price of the resting order. Each fill is then persisted by defaultProcess
+// (spot and stock) or marginProcess (cross). The part of the order that was not filled is placed into the book and stays
+// pending, unless the order is immediate or cancel (or fill or kill), then it is cancelled and its funds are returned.
+// The average price of the fills is returned in the order. During the call auction of the pair the order is not matched.
+// When a settlement fails, the remainder of an immediate order is cancelled before the book is dropped, otherwise it would
+// be loaded again from the database as a resting order.
+func (a *Service) trade(order *types.Order, book *orderbook.Book) {
 
-	// The purpose of this code is to declare two variables, instance and migrate. The variable instance is declared as an
-	// integer, and migrate is declared as a query.Migrate object with the Context field set to the value of the variable e.Context.
 	var (
-		price    float64
-		instance int
-		migrate  = query.Migrate{
-			Context: a.Context,
+		err    error
+		spent  = decimal.New(0)
+		filled = decimal.New(0)
+	)
+
+	// The incoming order is converted into its in-memory representation, the value is the quantity that is still open.
+	taker := a.queryTaker(order)
+
+	// While the pair is in its call auction the order is only collected in the book, it is matched when the auction ends.
+	if a.queryAuction(order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType()) {
+		book.Add(taker)
+		return
+	}
+
+	// A market order was sized by sweep against the same locked book, so it is matched without a price limit and takes
+	// exactly the levels that it was sized for.
+	if order.GetTrading() == types.TradingMarket {
+		taker.Price = *decimal.New(math.MaxFloat64)
+		if order.GetAssigning() == types.AssigningSell {
+			taker.Price = *decimal.New(0)
+		}
+	}
+
+	// The purpose of the loop is to settle every fill returned by the book. If the settlement of a fill fails, the memory
+	// state can no longer be trusted, so the book is dropped below and will be loaded again from the database on the next
+	// access.
+	fills, prevented := book.Match(taker)
+	for _, fill := range fills {
+
+		a.Context.Logger.Infof("[%v]: order ID: %v matched order ID: %v, price: %v, quantity: %v", strings.ToUpper(order.GetAssigning()), order.GetId(), fill.Maker.Id, fill.Price, fill.Quantity)
+
+		// A switch statement is used to evaluate the type of order provided and executes the appropriate processing.
+		// If the order type is either Spot or Stock, the defaultProcess function is called; otherwise the marginProcess function is called if the order type is Margin.
+		switch order.GetType() {
+		case types.TypeSpot, types.TypeStock:
+			err = a.defaultProcess(order, fill)
+			break
+		case types.TypeCross, types.TypeIsolated:
+			err = a.marginProcess(order, fill)
+			break
+		}
+
+		if a.Context.Debug(err) {
+			break
 		}
+
+		// A fill of an order that belongs to an order group cancels the other legs of its group.
+		a.cancelGroup(order.GetId(), book)
+		a.cancelGroup(fill.Maker.Id, book)
+
+		spent = spent.Add(fill.Price.Mul(&fill.Quantity))
+		filled = filled.Add(fill.Quantity)
+	}
+
+	// The self-trades prevented by the book are settled after the fills, the order itself is cancelled below when its mode
+	// cancels the newest order.
+	var (
+		cancelled bool
 	)
 
-	// This code is checking whether the value of the first parameter is greater than or equal to the value of the second
-	// parameter. If it is, the instance variable is set to 1.
-	if params[0].GetValue() >= params[1].GetValue() {
-		instance = 1
+	if err == nil {
+		cancelled, err = a.prevent(order, prevented, book)
+		a.Context.Debug(err)
 	}
 
-	// This switch statement is used to determine the price based on the side (bid or ask) of an order. The switch statement
-	// checks for the side of the order and assigns the price accordingly, using the params array. If the side is BID, it
-	// will assign the price from the second element in the params array. If the side is ASK, it will assign the price from
-	// the first element in the params array.
-	switch assigning {
-	case types.AssigningBuy:
-		price = params[1].GetPrice()
-	case types.AssigningSell:
-		price = params[0].GetPrice()
+	if filled.IsPositive() {
+		average := spent.Div(filled)
+		order.AveragePrice, order.AveragePriceDecimal = average.Float(), average.String()
+
+		// The trades of the order can move the price of the pair far enough to trip its circuit breaker.
+		a.breaker(order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType())
 	}
 
-	// This code is used to update an order status from pending to filled when the order is completed. It also updates the
-	// quantity of the orders and sets the necessary parameters for the order. Finally, it logs the parameters of the order.
-	if params[instance].GetValue() > 0 {
+	// A market buy order reserved its budget at its average price per unit instead of the price of every single level, so the
+	// difference between the reserved and the spent budget of the filled quantity is returned at once.
+	if order.GetTrading() == types.TradingMarket && order.GetAssigning() == types.AssigningBuy && filled.IsPositive() {
+		if refund := a.queryExact(order.GetPriceDecimal(), order.GetPrice()).Mul(filled).Sub(spent); refund.IsPositive() {
+			a.Context.Debug(a.refund(order, refund))
+		}
+	}
 
-		// The purpose of the for loop is to iterate over the parameters passed in and update the "value" of the specified
-		// order in the database. It also sets the status of the order to FILLED if the value is equal to 0. The code also
-		// checks for any errors that may occur during the process. Lastly, the code sends an email to the user associated with the order once the order is filled.
-		for i := 0; i < 2; i++ {
+	// The settlement failed, so the book is dropped. The remainder of an immediate order, or of an order cancelled by the
+	// self-trade prevention, is cancelled first: writeCancel reads its open value from the database, so only the settled
+	// fills are taken from it and the rest of its funds is returned.
+	if err != nil {
+		if cancelled || order.GetTimeInForce() == types.TimeInForceIoc || order.GetTimeInForce() == types.TimeInForceFok {
+			a.Context.Debug(a.cancel(order, book, types.EventCancel))
+		}
+		a.ResetBook(order.GetBaseUnit(), order.GetType())
+		return
+	}
 
-			// The purpose of this code is to declare a variable named "value" of type float64. This variable can be used to store a decimal number, such as 3.14159.
-			var (
-				value float64
-			)
+	// The remaining quantity of the order is placed into the book, the order keeps resting with the pending status. The
+	// remainder of an immediate order, or of an order cancelled by the self-trade prevention, never rests: it is cancelled
+	// and the reserved balance of the remainder is returned.
+	if order.Value, order.ValueDecimal = taker.Value.Float(), taker.Value.String(); taker.Value.IsPositive() {
 
-			// This if statement is used to update the "value" of a particular order in the database. The parameters passed in are
-			// used in the query to find the specific order to update. If the query is successful, the "value" of the order is
-			// stored in the "value" variable and the function will continue. If the query fails, the function will return.
-			if err := a.Context.Db.QueryRow("update orders set value = value - $2 where id = $1 and type = $3 and status = $4 returning value;", params[i].GetId(), params[instance].GetValue(), params[i].GetType(), types.StatusPending).Scan(&value); a.Context.Debug(err) {
-				return
-			}
+		switch {
+		case cancelled, order.GetTimeInForce() == types.TimeInForceIoc, order.GetTimeInForce() == types.TimeInForceFok:
+			a.Context.Debug(a.cancel(order, book, types.EventCancel))
+			break
+		default:
+			book.Add(taker)
+		}
+	}
+}
+
+// uncross - This function runs a single step of the call auction of the pair. The price that would execute the highest quantity
+// is computed on the locked book and published to the pair/auction channel as the indicative price and volume. When the
+// auction has ended, the crossing orders are matched at that single clearing price: every bid is settled like an incoming
+// order against the asks it was matched with, then the pair switches to continuous trading. If the settlement of a fill
+// fails, the book is dropped and the auction stays open, so the next step continues with the state of the database.
+func (a *Service) uncross(pair *types.Pair, closed bool) {
+
+	book, err := a.queryBook(pair.GetBaseUnit(), pair.GetQuoteUnit(), pair.GetType())
+	if a.Context.Debug(err) {
+		return
+	}
+	defer a.unlockBook(book, pair.GetBaseUnit(), pair.GetQuoteUnit(), pair.GetType())
+
+	price, volume := book.Indicative(decimal.New(pair.GetPrice()))
+
+	auction := types.Auction{
+		BaseUnit:  pair.GetBaseUnit(),
+		QuoteUnit: pair.GetQuoteUnit(),
+		Type:      pair.GetType(),
+		Price:     price.Float(),
+		Volume:    volume.Float(),
+		EndAt:     pair.GetAuctionEnd(),
+		Closed:    closed,
+	}
 
-			if value == 0 {
+	if closed {
+
+		// The bid of every cross takes the place of the incoming order, the asks are the resting orders of its fills.
+		for _, cross := range book.Uncross(price) {
+
+			order := a.queryOrder(cross.Order.Id)
+			for _, fill := range cross.Fills {
+
+				a.Context.Logger.Infof("[AUCTION]: order ID: %v matched order ID: %v, price: %v, quantity: %v", order.GetId(), fill.Maker.Id, fill.Price, fill.Quantity)
+
+				switch order.GetType() {
+				case types.TypeSpot, types.TypeStock:
+					err = a.defaultProcess(order, fill)
+					break
+				case types.TypeCross, types.TypeIsolated:
+					err = a.marginProcess(order, fill)
+					break
+				}
 
-				// This code is performing an update on the orders table in a database. It is setting the status of the order with the
-				// specified ID to the specified status (in this case, FILLED). The code is also checking for any errors that may
-				// occur during the process. If an error is found, the code will return without proceeding.
-				if _, err := a.Context.Db.Exec("update orders set status = $3 where id = $1 and type = $2;", params[i].GetId(), params[i].GetType(), types.StatusFilled); a.Context.Debug(err) {
+				if a.Context.Debug(err) {
+					a.ResetBook(order.GetBaseUnit(), order.GetType())
 					return
 				}
 
-				go migrate.SendMail(params[i].GetUserId(), "order_filled", params[i].GetId(), a.queryQuantity(params[i].GetAssigning(), params[i].GetQuantity(), price, false), params[i].GetBaseUnit(), params[i].GetQuoteUnit(), params[i].GetAssigning())
+				// A fill of an order that belongs to an order group cancels the other legs of its group.
+				a.cancelGroup(order.GetId(), book)
+				a.cancelGroup(fill.Maker.Id, book)
 			}
-		}
-
-		switch params[1].GetAssigning() {
-		case types.AssigningBuy:
 
-			// Order trades logs.
-			quantity, err := a.writeTrade(params[0].GetId(), params[0].GetQuoteUnit(), params[instance].GetValue(), price, true)
+			// The self-trades prevented for the bid are settled like the ones of an incoming order, the book already
+			// removed a cancelled bid.
+			cancelled, err := a.prevent(order, cross.Prevented, book)
 			if a.Context.Debug(err) {
+				a.ResetBook(order.GetBaseUnit(), order.GetType())
 				return
 			}
 
-			// This code is part of a function that allows the user to set the balance of a certain item to a certain quantity.
-			// The purpose of the if statement is to check if there is an error when setting the balance. If there is an error, the function will return without doing anything.
-			if err := a.WriteBalance(params[0].GetQuoteUnit(), params[0].GetType(), params[0].GetUserId(), quantity, types.BalancePlus); a.Context.Debug(err) {
-				return
+			if cancelled {
+				a.Context.Debug(a.cancel(order, book, types.EventCancel))
 			}
+		}
 
-			// Order trades logs.
-			quantity, err = a.writeTrade(params[1].GetId(), params[0].GetBaseUnit(), params[instance].GetValue(), price, false)
-			if a.Context.Debug(err) {
-				return
+		// The auction is over, the orders that did not cross keep resting in the book for the continuous trading.
+		if _, err := a.Context.Db.Exec("update pairs set auction_end = null where id = $1", pair.GetId()); a.Context.Debug(err) {
+			return
+		}
+	}
+
+	a.Context.Debug(a.Context.Publish(&auction, "exchange", "pair/auction"))
+}
+
+// cancelGroup - This function cancels the other legs of the order group (one-cancels-other) of the order, it is called when an
+// order of the group was filled, triggered or cancelled. The legs are cancelled through cancel, so their reserved balance
+// is released and the cancellation of every leg is published. The book of the pair is locked by the caller.
+func (a *Service) cancelGroup(id int64, book *orderbook.Book) {
+	for _, item := range a.queryGroup(id) {
+		a.Context.Logger.Infof("[GROUP]: order ID: %v cancelled by order ID: %v", item.GetId(), id)
+		a.Context.Debug(a.cancel(item, book, types.EventCancel))
+	}
+}
+
+// cancel - This function cancels an order in one database transaction: the status of the order is set to cancel and the
+// reserved balance of its open value is returned by writeCancel. The committed order is then removed from the book of
+// its pair, which is locked by the caller, and the cancellation is published. A dormant stop order is not in any book,
+// the book is nil for it. The event tells the journal whether the order was cancelled or has expired.
+func (a *Service) cancel(order *types.Order, book *orderbook.Book, event string) error {
+
+	// The cancellation is settled in one database transaction, the status of the order and the returned balance are
+	// written together. The transaction is rolled back when the function returns without a commit.
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := a.writeCancel(tx, order, event); err != nil {
+		return err
+	}
+
+	// The cancellation is committed, only then the order is removed from the in-memory book.
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if book != nil {
+		book.Remove(order.GetId())
+	}
+
+	// This code is intended to publish the order to an exchange with the routing key "order/cancel".
+	return a.Context.Publish(order, "exchange", "order/cancel")
+}
+
+// prevent - This function settles the self-trades that the book prevented for the incoming order: a resting order of the same
+// owner cancelled by the mode of the order is cancelled in the same way as by its user, a decrement is settled by
+// decrement. It reports whether the incoming order itself has to be cancelled. The book of the pair is locked by the
+// caller.
+func (a *Service) prevent(order *types.Order, prevented []*orderbook.Prevention, book *orderbook.Book) (cancelled bool, err error) {
+
+	for _, item := range prevented {
+
+		a.Context.Logger.Infof("[PREVENT]: order ID: %v prevented a self-trade with order ID: %v, mode: %v", order.GetId(), item.Maker.Id, order.GetPrevention())
+
+		if item.Quantity.IsPositive() {
+			if err := a.decrement(order, a.queryOrder(item.Maker.Id), &item.Quantity); err != nil {
+				return cancelled, err
 			}
+			continue
+		}
 
-			// This code is part of a function that allows the user to set the balance of a certain item to a certain quantity.
-			// The purpose of the if statement is to check if there is an error when setting the balance. If there is an error, the function will return without doing anything.
-			if err := a.WriteBalance(params[0].GetBaseUnit(), params[0].GetType(), params[1].GetUserId(), quantity, types.BalancePlus); err != nil {
-				return
+		if item.Oldest {
+			if err := a.cancel(a.queryOrder(item.Maker.Id), book, types.EventCancel); err != nil {
+				return cancelled, err
 			}
+			a.cancelGroup(item.Maker.Id, book)
+		}
+
+		if item.Newest {
+			cancelled = true
+		}
+	}
+
+	return cancelled, nil
+}
+
+// decrement - This function settles a decrement of the self-trade prevention in one database transaction: the open value of both
+// orders is decreased by the quantity without a trade, the reserved balance of the quantity is returned to each user and
+// an order that is used up is cancelled. The event is written to the journal of the pair first.
+func (a *Service) decrement(order, maker *types.Order, quantity *decimal.Float) error {
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	journal, err := a.writeJournal(tx, types.EventPrevent, order, maker.GetId(), a.queryExact(order.GetPriceDecimal(), order.GetPrice()), quantity)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range []*types.Order{order, maker} {
 
+		var (
+			value decimal.Float
+		)
+
+		if err := tx.QueryRow("update orders set value = value - $2 where id = $1 and status = $3 returning value;", item.GetId(), quantity.String(), types.StatusPending).Scan(&value); err != nil {
+			return err
+		}
+
+		// The reserved balance of the quantity is returned in the same way as on a cancellation.
+		switch item.GetAssigning() {
+		case types.AssigningBuy:
+			if err := a.writeBalance(tx, item.GetQuoteUnit(), a.queryWallet(item), item.GetUserId(), quantity.Mul(a.queryExact(item.GetPriceDecimal(), item.GetPrice())), types.BalancePlus, journal); err != nil {
+				return err
+			}
 			break
 		case types.AssigningSell:
-
-			// Order trades logs.
-			quantity, err := a.writeTrade(params[0].GetId(), params[0].GetBaseUnit(), params[instance].GetValue(), price, false)
-			if a.Context.Debug(err) {
-				return
+			if err := a.writeBalance(tx, item.GetBaseUnit(), a.queryWallet(item), item.GetUserId(), quantity, types.BalancePlus, journal); err != nil {
+				return err
 			}
+			break
+		}
 
-			// This code is part of a function that allows the user to set the balance of a certain item to a certain quantity.
-			// The purpose of the if statement is to check if there is an error when setting the balance. If there is an error, the function will return without doing anything.
-			if err := a.WriteBalance(params[0].GetBaseUnit(), params[0].GetType(), params[0].GetUserId(), quantity, types.BalancePlus); a.Context.Debug(err) {
-				return
+		if !value.IsPositive() {
+			if _, err := tx.Exec("update orders set status = $2 where id = $1;", item.GetId(), types.StatusCancel); err != nil {
+				return err
 			}
+		}
+	}
 
-			// Order trades logs.
-			quantity, err = a.writeTrade(params[1].GetId(), params[0].GetQuoteUnit(), params[instance].GetValue(), price, true)
-			if a.Context.Debug(err) {
-				return
-			}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
-			// This code is part of a function that allows the user to set the balance of a certain item to a certain quantity.
-			// The purpose of the if statement is to check if there is an error when setting the balance. If there is an error, the function will return without doing anything.
-			if err := a.WriteBalance(params[0].GetQuoteUnit(), params[0].GetType(), params[1].GetUserId(), quantity, types.BalancePlus); a.Context.Debug(err) {
-				return
+	// Both orders are published with their decreased value, the errors of the notifications are only logged.
+	for _, item := range []*types.Order{order, maker} {
+		a.Context.Debug(a.Context.Publish(a.queryOrder(item.GetId()), "exchange", "order/status"))
+	}
+
+	return nil
+}
+
+// refund - This function returns the part of the reserved budget that a market buy order did not spend to the balance of the
+// user. The refund is written to the journal of the pair in the same database transaction as the balance.
+func (a *Service) refund(order *types.Order, quantity *decimal.Float) error {
+
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	journal, err := a.writeJournal(tx, types.EventRefund, order, 0, a.queryExact(order.GetPriceDecimal(), order.GetPrice()), quantity)
+	if err != nil {
+		return err
+	}
+
+	if err := a.writeBalance(tx, order.GetQuoteUnit(), a.queryWallet(order), order.GetUserId(), quantity, types.BalancePlus, journal); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// defaultProcess - This function is used to settle a single fill between the incoming order and a resting order of the book. The
+// whole fill is written in one database transaction: the balance rows of both users are locked first (always in the order
+// of their ids, so that two fills can not wait for each other), the open value of both orders is decreased and the orders
+// that are completely executed are marked as filled, the trades are written, the buyer is credited with the base unit
+// and the seller with the quote unit. When the incoming order is a buy and the fill was executed below its limit price,
+// the reserved difference is returned to the buyer. Every user is credited on the balance of the wallet of its own order,
+// a spot order can be filled against an order of a margin account. Mails, order notifications and the ticker of
+// the pair are only sent after the transaction was committed.
+func (a *Service) defaultProcess(order *types.Order, fill *orderbook.Fill) error {
+
+	// The purpose of this code is to declare the resting order of the fill, read from the database, the list of the orders
+	// that are completely filled by this fill, and migrate, which is a query.Migrate object with the Context field set to
+	// the value of the variable a.Context.
+	var (
+		filled  []*types.Order
+		maker   = a.queryOrder(fill.Maker.Id)
+		migrate = query.Migrate{
+			Context: a.Context,
+		}
+	)
+
+	// The purpose of this code is to start a new database transaction, the transaction is rolled back when the function
+	// returns without a commit.
+	tx, err := a.Context.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// This code locks every balance row that can be changed by the fill, the rows are locked in the order of their ids.
+	if _, err := tx.Exec("select id from balances where user_id = any($1) and symbol = any($2) and type = any($3) order by id for update;", pq.Array([]int64{order.GetUserId(), maker.GetUserId()}), pq.Array([]string{order.GetBaseUnit(), order.GetQuoteUnit()}), pq.Array([]string{a.queryWallet(order), a.queryWallet(maker)})); err != nil {
+		return err
+	}
+
+	// The fill is written to the journal of the pair before the orders and the balances are changed.
+	journal, err := a.writeJournal(tx, types.EventFill, order, maker.GetId(), &fill.Price, &fill.Quantity)
+	if err != nil {
+		return err
+	}
+
+	// The purpose of the for loop is to iterate over both orders of the fill and update the "value" of the order in the
+	// database. It also sets the status of the order to FILLED once nothing of the value is left.
+	for _, item := range []*types.Order{order, maker} {
+
+		// The purpose of this code is to declare a variable named "value", the exact open value of the order after the fill.
+		var (
+			value decimal.Float
+		)
+
+		// This if statement is used to update the "value" of a particular order in the database. The quantity is sent as
+		// the exact decimal of the fill, the same decimal that the book subtracted from its open value, so the order is
+		// filled in the database exactly when it leaves the book. If the query fails, the function will return the error.
+		if err := tx.QueryRow("update orders set value = value - $2 where id = $1 and status = $3 returning value;", item.GetId(), fill.Quantity.String(), types.StatusPending).Scan(&value); err != nil {
+			return err
+		}
+
+		if !value.IsPositive() {
+
+			// This code is performing an update on the orders table in a database. It is setting the status of the order with the
+			// specified ID to the specified status (in this case, FILLED).
+			if _, err := tx.Exec("update orders set status = $2 where id = $1;", item.GetId(), types.StatusFilled); err != nil {
+				return err
 			}
 
-			break
+			filled = append(filled, item)
 		}
 	}
 
-	//The purpose of this code is to create a new API client for the pbprovider package using the existing gRPC client in the context.
-	if _, err := a.SetTicker(context.Background(), &pbprovider.SetRequestTicker{Key: a.Context.Secrets[2], Price: params[0].GetPrice(), Value: params[0].GetValue(), BaseUnit: params[0].GetBaseUnit(), QuoteUnit: params[0].GetQuoteUnit(), Assigning: params[0].GetAssigning()}); a.Context.Debug(err) {
-		return
+	// The buyer and the seller of the fill, the incoming order is the buyer unless it is a sell order.
+	buyer, seller := order, maker
+	if order.GetAssigning() == types.AssigningSell {
+		buyer, seller = maker, order
+	}
+
+	// Order trades logs, the seller receives the quote unit at the price of the fill.
+	quantity, err := a.writeTrade(tx, seller.GetId(), order.GetQuoteUnit(), &fill.Quantity, &fill.Price, true, seller == maker)
+	if err != nil {
+		return err
+	}
+
+	// This code credits the seller with the quote unit received for the fill.
+	if err := a.writeBalance(tx, order.GetQuoteUnit(), a.queryWallet(seller), seller.GetUserId(), quantity, types.BalancePlus, journal); err != nil {
+		return err
+	}
+
+	// Order trades logs, the buyer receives the base unit.
+	quantity, err = a.writeTrade(tx, buyer.GetId(), order.GetBaseUnit(), &fill.Quantity, &fill.Price, false, buyer == maker)
+	if err != nil {
+		return err
+	}
+
+	// This code credits the buyer with the base unit received for the fill.
+	if err := a.writeBalance(tx, order.GetBaseUnit(), a.queryWallet(buyer), buyer.GetUserId(), quantity, types.BalancePlus, journal); err != nil {
+		return err
 	}
+
+	// The incoming buy order reserved its quantity at its own limit price, a fill at a better price of the book returns the
+	// difference to the buyer, otherwise the reserved balance would drift away from the trades.
+	if limit := a.queryExact(order.GetPriceDecimal(), order.GetPrice()); buyer == order && order.GetTrading() != types.TradingMarket && limit.GreaterThan(fill.Price.Decimal) {
+		if err := a.writeBalance(tx, order.GetQuoteUnit(), a.queryWallet(order), order.GetUserId(), limit.Sub(&fill.Price).Mul(&fill.Quantity), types.BalancePlus, journal); err != nil {
+			return err
+		}
+	}
+
+	// The fill is committed, the notifications below are sent only for the persisted state.
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The mail is sent to the users whose orders were completely executed by the fill.
+	for _, item := range filled {
+		go migrate.SendMail(item.GetUserId(), "order_filled", item.GetId(), a.queryQuantity(item.GetAssigning(), item.GetQuantity(), fill.Price.Float(), false), item.GetBaseUnit(), item.GetQuoteUnit(), item.GetAssigning())
+	}
+
+	// The purpose of the code snippet is to publish both orders of the fill to an exchange with the routing key "order/status".
+	// The fill is already persisted at this point, so the errors of the notifications are only logged and the book is kept.
+	for _, item := range []*types.Order{order, maker} {
+		a.Context.Debug(a.Context.Publish(a.queryOrder(item.GetId()), "exchange", "order/status"))
+	}
+
+	// The fill is sent to the subscribers of the trade stream of the pair, without the users of the orders.
+	streams.publish(streams.topic("trade", order.GetBaseUnit(), order.GetQuoteUnit(), order.GetType()), &types.Trade{
+		BaseUnit:        order.GetBaseUnit(),
+		QuoteUnit:       order.GetQuoteUnit(),
+		Price:           fill.Price.Float(),
+		Quantity:        fill.Quantity.Float(),
+		PriceDecimal:    fill.Price.String(),
+		QuantityDecimal: fill.Quantity.String(),
+		Assigning:       order.GetAssigning(),
+		CreateAt:        time.Now().UTC().Format(time.RFC3339),
+	})
+
+	//The purpose of this code is to update the ticker of the pair with the price and the quantity of the fill.
+	_, err = a.SetTicker(context.Background(), &pbprovider.SetRequestTicker{Key: a.Context.Secrets[2], Price: fill.Price.Float(), Value: fill.Quantity.Float(), BaseUnit: order.GetBaseUnit(), QuoteUnit: order.GetQuoteUnit(), Assigning: order.GetAssigning()})
+	a.Context.Debug(err)
+
+	return nil
 }
 
-// marginProcess - This function is used to replay a trade process. It updates two orders with different amounts to determine the result
-// of a trade. It updates the order status in the database with pending in to filled, updates the balance by adding the
-// amount of the order to the balance, and sends a mail. In addition, it logs information about the trade.
-func (a *Service) marginProcess(assigning string, params ...*types.Order) {
-	// TODO margin trade process.
+// marginProcess - This function is used to settle a single fill of a cross or isolated margin order. The fill is settled by
+// defaultProcess like any other fill, the order only reserves and receives the balances of its margin wallet. The fill
+// changes the collateral of the wallet, so its margin level is checked right away and a margin call is sent when it fell
+// below the call level. The wallets of the resting orders, and the liquidation of an isolated wallet that needs the book
+// locked here, are left to the margin worker.
+func (a *Service) marginProcess(order *types.Order, fill *orderbook.Fill) error {
+
+	if err := a.defaultProcess(order, fill); err != nil {
+		return err
+	}
+
+	// The fill is already persisted, an error of the margin call is only logged.
+	margin, err := a.queryMargin(order.GetUserId(), a.queryWallet(order))
+	if a.Context.Debug(err) {
+		return nil
+	}
+	a.Context.Debug(a.writeCall(margin))
+
+	return nil
 }
@@ -136,7 +136,8 @@ func (a *Service) price() {
 			// e.Context.Db.Query() function to execute a query and assigns the output of the query to the rows variable. If there
 			// is an error, it calls the e.Context.Debug() function to debug the error, and if successful, it will defer the
 			// rows.Close() function to close the rows after the function call.
-			rows, err := a.Context.Db.Query(`select id, price, base_unit, quote_unit, type from pairs where status = $1 order by id`, true)
+			// The price of a futures pair is its mark price, it is written by the mark worker of the futures service.
+			rows, err := a.Context.Db.Query(`select id, price, base_unit, quote_unit, type from pairs where status = $1 and type != $2 order by id`, true, types.TypeFuture)
 			if a.Context.Debug(err) {
 				return
 			}
@@ -232,3 +233,290 @@ func (a *Service) price() {
 		}()
 	}
 }
+
+// stop - This function activates the dormant stop orders at a specific time interval. A stop order is triggered when the last
+// trade price of its pair recorded in the ohlcv table crosses the trigger price: a buy stop when the price rises to or
+// above the trigger and a sell stop when it falls to or below it. A triggered stop-limit order becomes a limit order and
+// a stop-market order becomes a market order, both are placed through placeOrder with the same balance checks as a new
+// order. A triggered order that can not be placed (for example because the balance is no longer sufficient) is cancelled.
+func (a *Service) stop() {
+
+	// The code above creates a new ticker that will run once a second and then loop through each range of the ticker.C
+	// channel, so the stop orders follow the trades closely.
+	ticker := time.NewTicker(time.Second * 1)
+	for range ticker.C {
+
+		func() {
+
+			var (
+				orders []*types.Order
+			)
+
+			// This code selects the dormant orders whose trigger price was crossed by the last trade of their pair, the oldest
+			// orders first. The rows are read completely before any order is placed, the placement locks the book of the pair.
+			rows, err := a.Context.Db.Query(`select o.id, o.assigning, o.base_unit, o.quote_unit, o.price, o.value, o.quantity, o.trigger_price, o.user_id, o.type, o.trading, o.time_in_force, o.post_only, o.display, o.prevention from orders o, lateral (select price from ohlcv where base_unit = o.base_unit and quote_unit = o.quote_unit order by id desc limit 1) l where o.status = $1 and ((o.assigning = $2 and l.price >= o.trigger_price) or (o.assigning = $3 and l.price <= o.trigger_price)) and not exists (select id from pairs where base_unit = o.base_unit and quote_unit = o.quote_unit and (halted or auction_end is not null)) order by o.id`, types.StatusDormant, types.AssigningBuy, types.AssigningSell)
+			if a.Context.Debug(err) {
+				return
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+
+				var (
+					item types.Order
+				)
+
+				if err := rows.Scan(&item.Id, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.Price, &item.Value, &item.Quantity, &item.TriggerPrice, &item.UserId, &item.Type, &item.Trading, &item.TimeInForce, &item.PostOnly, &item.Display, &item.Prevention); a.Context.Debug(err) {
+					return
+				}
+
+				orders = append(orders, &item)
+			}
+			_ = rows.Close()
+
+			for _, item := range orders {
+
+				a.Context.Logger.Infof("[STOP]: order ID: %v triggered at the price %v", item.GetId(), item.GetTriggerPrice())
+
+				// The stop order is converted into the order type that it activates.
+				switch item.GetTrading() {
+				case types.TradingStopLimit:
+					item.Trading = types.TradingLimit
+					break
+				case types.TradingStopMarket:
+					item.Trading = types.TradingMarket
+					break
+				}
+
+				// The other legs of the order group of the triggered order are cancelled before it is placed, so that their
+				// reserved balance is released first.
+				if book, err := a.queryBook(item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType()); !a.Context.Debug(err) {
+					a.cancelGroup(item.GetId(), book)
+					a.unlockBook(book, item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType())
+				}
+
+				if err := a.placeOrder(item, 0); !a.Context.Debug(err) {
+					continue
+				}
+
+				// The order could not be placed, it is cancelled while it is still dormant, with the book of its pair locked so
+				// that the cancellation is journaled. An order that was cancelled by the user in the meantime is left as it is.
+				item.Status = types.StatusDormant
+				if book, err := a.queryBook(item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType()); !a.Context.Debug(err) {
+					a.Context.Debug(a.cancel(item, book, types.EventCancel))
+					a.unlockBook(book, item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType())
+				}
+			}
+		}()
+	}
+}
+
+// expire - This function cancels the good till date orders whose expiry time has passed, at a specific time interval. The order
+// is cancelled with the book of its pair locked: the reserved balance of a pending order is returned, a dormant stop order
+// is only marked as cancelled. The other legs of the order group of an expired order are cancelled as well.
+func (a *Service) expire() {
+
+	// The code above creates a new ticker that will run once a second and then loop through each range of the ticker.C
+	// channel, so an expired order does not stay in the book for long.
+	ticker := time.NewTicker(time.Second * 1)
+	for range ticker.C {
+
+		func() {
+
+			var (
+				orders []*types.Order
+			)
+
+			// This code selects the expired orders that are still pending or dormant, the rows are read completely before
+			// any book is locked.
+			rows, err := a.Context.Db.Query(`select id, assigning, base_unit, quote_unit, price, value, user_id, type, status from orders where time_in_force = $1 and expire_at <= now() and (status = $2 or status = $3) order by id`, types.TimeInForceGtd, types.StatusPending, types.StatusDormant)
+			if a.Context.Debug(err) {
+				return
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+
+				var (
+					item types.Order
+				)
+
+				if err := rows.Scan(&item.Id, &item.Assigning, &item.BaseUnit, &item.QuoteUnit, &item.Price, &item.Value, &item.UserId, &item.Type, &item.Status); a.Context.Debug(err) {
+					return
+				}
+
+				orders = append(orders, &item)
+			}
+			_ = rows.Close()
+
+			for _, item := range orders {
+
+				a.Context.Logger.Infof("[EXPIRE]: order ID: %v", item.GetId())
+
+				// The book of the pair is locked for the time of the cancellation, so that the order can not be matched while its
+				// reserved balance is being returned.
+				book, err := a.queryBook(item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType())
+				if a.Context.Debug(err) {
+					continue
+				}
+
+				if err := a.cancel(item, book, types.EventExpire); !a.Context.Debug(err) {
+					a.cancelGroup(item.GetId(), book)
+				}
+				a.unlockBook(book, item.GetBaseUnit(), item.GetQuoteUnit(), item.GetType())
+			}
+		}()
+	}
+}
+
+// resume - This function resumes the trading of the halted pairs whose cool-down has passed, the pairs halted without a cool-down
+// stay halted until an administrator resumes them. A pair with an auction duration reopens with a call auction. The
+// resumption is published to the pair/resume channel.
+func (a *Service) resume() {
+
+	ticker := time.NewTicker(time.Second * 1)
+	for range ticker.C {
+
+		func() {
+
+			rows, err := a.Context.Db.Query(`update pairs set halted = false, resume_at = null, auction_end = case when auction > 0 then now() + make_interval(secs => auction) end where halted and resume_at <= now() returning id, base_unit, quote_unit, type, halted, coalesce(auction_end::text, '')`)
+			if a.Context.Debug(err) {
+				return
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+
+				var (
+					item types.Pair
+				)
+
+				if err := rows.Scan(&item.Id, &item.BaseUnit, &item.QuoteUnit, &item.Type, &item.Halted, &item.AuctionEnd); a.Context.Debug(err) {
+					continue
+				}
+
+				a.Context.Debug(a.Context.Publish(&item, "exchange", "pair/resume"))
+			}
+		}()
+	}
+}
+
+// auction - This function runs the call auctions of the pairs at a specific time interval. Every second the indicative price and
+// volume of every running auction are published, and the auctions whose end has passed are uncrossed and switched to
+// continuous trading. The auction of a halted pair is not run.
+func (a *Service) auction() {
+
+	ticker := time.NewTicker(time.Second * 1)
+	for range ticker.C {
+
+		func() {
+
+			var (
+				pairs  []*types.Pair
+				closed []bool
+			)
+
+			// The rows are read completely before any auction is run, the auction locks the book of the pair.
+			rows, err := a.Context.Db.Query(`select id, base_unit, quote_unit, type, price, auction_end::text, auction_end <= now() from pairs where auction_end is not null and not halted order by id`)
+			if a.Context.Debug(err) {
+				return
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+
+				var (
+					item types.Pair
+					end  bool
+				)
+
+				if err := rows.Scan(&item.Id, &item.BaseUnit, &item.QuoteUnit, &item.Type, &item.Price, &item.AuctionEnd, &end); a.Context.Debug(err) {
+					continue
+				}
+
+				pairs, closed = append(pairs, &item), append(closed, end)
+			}
+
+			if a.Context.Debug(rows.Err()) {
+				return
+			}
+
+			for i, item := range pairs {
+				a.uncross(item, closed[i])
+			}
+		}()
+	}
+}
+
+// interest - This function accrues the interest of the margin loans at a specific time interval. Every minute the loans whose last
+// accrual is at least an hour old are charged one hour of interest, so every loan is charged once an hour.
+func (a *Service) interest() {
+
+	ticker := time.NewTicker(time.Minute * 1)
+	for range ticker.C {
+
+		accrued, err := a.writeInterest()
+		if a.Context.Debug(err) {
+			continue
+		}
+
+		if accrued > 0 {
+			a.Context.Logger.Infof("[INTEREST]: accrued the interest of %v loans", accrued)
+		}
+	}
+}
+
+// margin - This function checks the margin level of every margin account with a loan at a specific time interval, the prices of
+// the pairs and the accrued interest change the level without any action of the user. An account whose level fell below
+// the call level receives a margin call, an isolated wallet whose level fell below the liquidation level is liquidated.
+func (a *Service) margin() {
+
+	ticker := time.NewTicker(time.Minute * 1)
+	for range ticker.C {
+
+		func() {
+
+			var (
+				accounts []*types.Margin
+			)
+
+			// The accounts are read completely before they are checked, every check runs its own queries.
+			rows, err := a.Context.Db.Query(`select distinct user_id, type from loans order by user_id, type`)
+			if a.Context.Debug(err) {
+				return
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+
+				var (
+					item types.Margin
+				)
+
+				if err := rows.Scan(&item.UserId, &item.Type); a.Context.Debug(err) {
+					continue
+				}
+
+				accounts = append(accounts, &item)
+			}
+
+			if a.Context.Debug(rows.Err()) {
+				return
+			}
+
+			for _, item := range accounts {
+
+				margin, err := a.queryMargin(item.GetUserId(), item.GetType())
+				if a.Context.Debug(err) {
+					continue
+				}
+				a.Context.Debug(a.writeCall(margin))
+
+				// Only the isolated wallet of a pair is liquidated, a cross account only receives the margin call.
+				if _, _, isolated := a.queryWalletPair(margin.GetType()); isolated && margin.GetLevel() > 0 && margin.GetLevel() < liquidationLevel {
+					a.Context.Debug(a.liquidate(margin))
+				}
+			}
+		}()
+	}
+}
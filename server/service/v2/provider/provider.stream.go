@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cryptogateway/backend-envoys/assets/common/orderbook"
+	"github.com/cryptogateway/backend-envoys/server/proto/v2/pbprovider"
+	"github.com/cryptogateway/backend-envoys/server/types"
+)
+
+// stream - The stream struct delivers the live updates of the provider (depth diffs, trades and tickers) to the subscribers
+// of the server-streaming RPCs. Every subscriber has its own buffered channel, a subscriber that does not read its channel
+// fast enough is dropped and its channel is closed, so a slow client never blocks the matching engine.
+type stream struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan interface{}]bool
+}
+
+// streams - The subscribers of all topics, shared by every Service value of the package just like the order books.
+var (
+	streams = &stream{
+		subscribers: make(map[string]map[chan interface{}]bool),
+	}
+)
+
+// subscribe - This method registers a new subscriber of the topic and returns its channel.
+func (s *stream) subscribe(topic string) chan interface{} {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	channel := make(chan interface{}, 256)
+	if s.subscribers[topic] == nil {
+		s.subscribers[topic] = make(map[chan interface{}]bool)
+	}
+	s.subscribers[topic][channel] = true
+
+	return channel
+}
+
+// unsubscribe - This method removes the subscriber from the topic, the channel is closed when it was not closed yet.
+func (s *stream) unsubscribe(topic string, channel chan interface{}) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.subscribers[topic][channel]; ok {
+		delete(s.subscribers[topic], channel)
+		close(channel)
+	}
+}
+
+// publish - This method sends the message to every subscriber of the topic without blocking. A subscriber whose buffer is
+// full is removed and its channel is closed, the RPC of the subscriber then ends with an error.
+func (s *stream) publish(topic string, message interface{}) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for channel := range s.subscribers[topic] {
+		select {
+		case channel <- message:
+		default:
+			delete(s.subscribers[topic], channel)
+			close(channel)
+		}
+	}
+}
+
+// topic - returns the name of the topic of a pair, for example "depth:btc/usd/spot". The margin orders trade in the book of the
+// spot pair, so the margin types share the topics of the spot pair.
+func (s *stream) topic(name, base, quote, _type string) string {
+
+	// Convert TypeCross and TypeIsolated to TypeSpot to ensure the correct type is used.
+	if _type == types.TypeCross || _type == types.TypeIsolated {
+		_type = types.TypeSpot
+	}
+
+	return fmt.Sprintf("%v:%v/%v/%v", name, base, quote, _type)
+}
+
+// unlockBook - This function publishes the diff of the price levels changed while the book was locked to the depth stream of
+// the pair and unlocks the book. It is used instead of book.Unlock() wherever the book was changed.
+func (a *Service) unlockBook(book *orderbook.Book, base, quote, _type string) {
+
+	// A book that was reset is not published anymore, the book that replaces it continues with a gap in the sequence.
+	if diff := book.Flush(); diff != nil && !book.Closed() {
+		streams.publish(streams.topic("depth", base, quote, _type), diff)
+	}
+
+	book.Unlock()
+}
+
+// queryDepthResponse - This function converts a diff or a snapshot of the book into the response of the depth stream.
+func (a *Service) queryDepthResponse(diff *orderbook.Diff, snapshot bool) *pbprovider.ResponseDepth {
+
+	response := pbprovider.ResponseDepth{
+		Sequence: diff.Sequence,
+		Snapshot: snapshot,
+	}
+
+	for _, level := range diff.Bids {
+		response.Bids = append(response.Bids, &pbprovider.Depth{Price: level.Price.Float(), Quantity: level.Quantity.Float(), Count: int32(level.Count)})
+	}
+
+	for _, level := range diff.Asks {
+		response.Asks = append(response.Asks, &pbprovider.Depth{Price: level.Price.Float(), Quantity: level.Quantity.Float(), Count: int32(level.Count)})
+	}
+
+	return &response
+}
@@ -721,10 +721,11 @@ func (e *Service) transfer(userId, txId int64, symbol string, to string, value,
 		// transaction ID, fees, and hash. The message is sent to the exchange topic with the label "withdraw/status". The code
 		// also checks for an error and returns if there is one.
 		if err := e.Context.Publish(&types.Transaction{
-			Id:     txId,
-			Fees:   charges,
-			Hash:   hash,
-			Status: types.StatusFilled,
+			Id:          txId,
+			Fees:        charges,
+			FeesDecimal: decimal.New(charges).String(),
+			Hash:        hash,
+			Status:      types.StatusFilled,
 		}, "exchange", "withdraw/status"); e.Context.Debug(err) {
 			return
 		}
@@ -3,11 +3,13 @@ package types
 import "github.com/pkg/errors"
 
 const (
-	TypeZero   = ""
-	TypeSpot   = "spot"
-	TypeStock  = "stock"
-	TypeCross  = "cross"
-	TypeFuture = "future"
+	TypeZero     = ""
+	TypeSpot     = "spot"
+	TypeStock    = "stock"
+	TypeCross    = "cross"
+	TypeIsolated = "isolated"
+	TypeFuture   = "future"
+	TypeLending  = "lending"
 
 	KYCLevel1 = "level_1"
 	KYCLevel2 = "level_2"
@@ -36,9 +38,18 @@ const (
 	StatusAccess     = "access"
 	StatsRejected    = "rejected"
 	StatusBlocked    = "blocked"
+	StatusDormant    = "dormant"
 
-	TradingMarket = "market"
-	TradingLimit  = "limit"
+	TradingMarket     = "market"
+	TradingLimit      = "limit"
+	TradingStopLimit  = "stop_limit"
+	TradingStopMarket = "stop_market"
+	TradingLiquidate  = "liquidate"
+
+	TimeInForceGtc = "gtc"
+	TimeInForceIoc = "ioc"
+	TimeInForceFok = "fok"
+	TimeInForceGtd = "gtd"
 
 	GroupAction = "action"
 	GroupCrypto = "crypto"
@@ -62,6 +73,20 @@ const (
 	BalanceMinus = "minus"
 	BalancePlus  = "plus"
 
+	EventAccepted = "accepted"
+	EventDormant  = "dormant"
+	EventFill     = "fill"
+	EventAmend    = "amend"
+	EventCancel   = "cancel"
+	EventExpire   = "expire"
+	EventRefund   = "refund"
+	EventPrevent  = "prevent"
+
+	PreventionCancelNewest = "cancel_newest"
+	PreventionCancelOldest = "cancel_oldest"
+	PreventionCancelBoth   = "cancel_both"
+	PreventionDecrement    = "decrement"
+
 	TagNone      = "tag_none"
 	TagBitcoin   = "tag_bitcoin"
 	TagEthereum  = "tag_ethereum"
@@ -202,6 +227,7 @@ func Status(request string) error {
 		StatusAccess:     true,
 		StatsRejected:    true,
 		StatusBlocked:    true,
+		StatusDormant:    true,
 	}
 	if _, ok := statuses[request]; !ok {
 		return errors.New("Invalid status")
@@ -211,9 +237,10 @@ func Status(request string) error {
 
 func Type(request string) error {
 	types := map[string]bool{
-		TypeSpot:  true,
-		TypeStock: true,
-		TypeCross: true,
+		TypeSpot:     true,
+		TypeStock:    true,
+		TypeCross:    true,
+		TypeIsolated: true,
 	}
 	if _, ok := types[request]; !ok {
 		return errors.New("Invalid type")
@@ -221,6 +248,36 @@ func Type(request string) error {
 	return nil
 }
 
+// TimeInForce - checks if the requested time in force of an order is valid: good till cancelled, immediate or cancel, fill
+// or kill and good till date. If it is not, an error is returned.
+func TimeInForce(request string) error {
+	times := map[string]bool{
+		TimeInForceGtc: true,
+		TimeInForceIoc: true,
+		TimeInForceFok: true,
+		TimeInForceGtd: true,
+	}
+	if _, ok := times[request]; !ok {
+		return errors.New("Invalid time in force")
+	}
+	return nil
+}
+
+// Prevention - checks if the requested self-trade prevention mode is valid: cancel the newest order, cancel the oldest order,
+// cancel both orders or decrement both orders and cancel the smaller one. If it is not, an error is returned.
+func Prevention(request string) error {
+	preventions := map[string]bool{
+		PreventionCancelNewest: true,
+		PreventionCancelOldest: true,
+		PreventionCancelBoth:   true,
+		PreventionDecrement:    true,
+	}
+	if _, ok := preventions[request]; !ok {
+		return errors.New("Invalid self-trade prevention")
+	}
+	return nil
+}
+
 func Group(request string) error {
 	groups := map[string]bool{
 		GroupAction: true,